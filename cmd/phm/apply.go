@@ -0,0 +1,469 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+
+	"github.com/phm-dev/phm/internal/manifest"
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/phm-dev/phm/internal/tools"
+	"github.com/spf13/cobra"
+)
+
+// applyingManifest is set while phm apply installs packages. The manifest
+// decides the default version, so install does not ask for it.
+var applyingManifest bool
+
+func newApplyCmd() *cobra.Command {
+	var yes, prune bool
+
+	cmd := &cobra.Command{
+		Use:   "apply [manifest]",
+		Short: "Converge PHP versions, extensions and tools to a manifest",
+		Long: `Compare a manifest (default: phm.toml) with the installed packages,
+extensions, php.ini overrides, PHP-FPM services, default version and tools,
+show the changes and apply them.
+
+Packages of listed PHP versions that the manifest does not need are removed.
+PHP versions and tools the manifest does not list are kept unless --prune is given.
+
+Example phm.toml:
+  default = "8.5"
+  tools = ["composer"]
+
+  [php."8.5"]
+  packages = ["cli", "fpm"]
+  extensions = ["redis", "xdebug"]
+  enabled = ["opcache", "redis"]
+  fpm = true
+
+  [php."8.5".sapi.cli]
+  enabled = ["xdebug"]
+
+  [php."8.5".ini]
+  memory_limit = "512M"
+
+Examples:
+  phm apply                    # Apply ./phm.toml
+  phm apply --dry-run          # Show the changes only
+  phm apply team.toml --yes    # Apply without confirmation`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := manifest.DefaultFile
+			if len(args) > 0 {
+				path = args[0]
+			}
+			return runApply(path, yes, prune)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Apply without asking for confirmation")
+	cmd.Flags().BoolVar(&prune, "prune", false, "Also remove PHP versions and tools the manifest does not list")
+	return cmd
+}
+
+// extChange is an extension to enable or disable
+type extChange struct {
+	version string
+	name    string
+	sapi    string // cli, fpm or all
+}
+
+func (c extChange) String() string {
+	if c.sapi == "all" {
+		return fmt.Sprintf("%s (PHP %s)", c.name, c.version)
+	}
+	return fmt.Sprintf("%s (PHP %s, %s)", c.name, c.version, c.sapi)
+}
+
+// applyPlan is what phm apply changes to match a manifest
+type applyPlan struct {
+	install        []string // packages and tools
+	remove         []string
+	enable         []extChange
+	disable        []extChange
+	ini            map[string]map[string]string // PHP version -> php.ini overrides to write
+	iniChanges     []string
+	fpmEnable      []string
+	fpmDisable     []string
+	defaultVersion string
+}
+
+func (p *applyPlan) empty() bool {
+	return len(p.install) == 0 && len(p.remove) == 0 && len(p.enable) == 0 &&
+		len(p.disable) == 0 && len(p.ini) == 0 && len(p.fpmEnable) == 0 &&
+		len(p.fpmDisable) == 0 && p.defaultVersion == ""
+}
+
+func runApply(path string, yes, prune bool) error {
+	m, err := manifest.Load(path)
+	if err != nil {
+		return err
+	}
+
+	r, err := getRepo()
+	if err != nil {
+		return err
+	}
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+	toolsMgr := getToolsManager()
+	if err := toolsMgr.LoadInstalled(); err != nil {
+		if cfg.Debug {
+			fmt.Printf("\033[33mWarning:\033[0m Could not load installed tools: %v\n", err)
+		}
+	}
+
+	plan, err := planApply(m, mgr, toolsMgr, r.GetPackages(), prune)
+	if err != nil {
+		return err
+	}
+	if plan.empty() {
+		fmt.Printf("\033[32m[OK]\033[0m Nothing to do, the system matches %s\n", path)
+		return nil
+	}
+
+	fmt.Printf("\033[1mThe following changes will be made to match %s:\033[0m\n\n", path)
+	plan.print()
+
+	if cfg.DryRun {
+		fmt.Printf("\033[33mDry run:\033[0m no changes were made\n")
+		return nil
+	}
+	if !yes {
+		fmt.Print("Apply these changes? [y/N]: ")
+		var answer string
+		_, _ = fmt.Scanln(&answer)
+		if answer != "y" && answer != "Y" && answer != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+		fmt.Println()
+	}
+
+	if err := ensureSudo(); err != nil {
+		return err
+	}
+	if err := executeApply(plan); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n\033[32m[OK]\033[0m The system matches %s\n", path)
+	return nil
+}
+
+// planApply compares the manifest with the installed state
+func planApply(m *manifest.Manifest, mgr *pkg.Manager, toolsMgr *tools.Manager, available []pkg.Package, prune bool) (*applyPlan, error) {
+	plan := &applyPlan{ini: make(map[string]map[string]string)}
+	extMgr := getExtManager()
+	fpm := getFpmManager()
+	linker := getLinker()
+
+	// Packages
+	wanted := make(map[string]bool)
+	for _, slot := range m.Slots {
+		for _, name := range slot.PackageNames() {
+			if !mgr.IsInstalled(name) {
+				if parseInstallRequest(name, available) == nil {
+					return nil, fmt.Errorf("package not found: %s (php.%s in manifest)", name, slot.Version)
+				}
+				plan.install = append(plan.install, name)
+			}
+			wanted[name] = true
+		}
+	}
+	addDependencies(wanted, mgr, available)
+
+	for _, slot := range m.Slots {
+		var unwanted []string
+		for _, installed := range mgr.GetInstalledForVersion(slot.Version) {
+			if !wanted[installed.Name] {
+				unwanted = append(unwanted, installed.Name)
+			}
+		}
+		sort.Strings(unwanted)
+		plan.remove = append(plan.remove, unwanted...)
+	}
+	if prune {
+		var unlisted []string
+		for _, installed := range mgr.GetAllInstalled() {
+			slot := installed.InstallSlot
+			if slot == "" {
+				slot = extractPHPVersion(installed.Name)
+			}
+			if slot != "" && m.Slot(slot) == nil {
+				unlisted = append(unlisted, installed.Name)
+			}
+		}
+		sort.Strings(unlisted)
+		plan.remove = append(plan.remove, unlisted...)
+	}
+
+	// Tools
+	listedTools := make(map[string]bool)
+	for _, name := range m.Tools {
+		if !tools.IsKnownTool(name) {
+			return nil, fmt.Errorf("unknown tool: %s (tools in manifest)", name)
+		}
+		listedTools[name] = true
+		if !toolsMgr.IsInstalled(name) {
+			plan.install = append(plan.install, name)
+		}
+	}
+	if prune {
+		var unlisted []string
+		for _, installed := range toolsMgr.GetAllInstalled() {
+			if !listedTools[installed.Name] {
+				unlisted = append(unlisted, installed.Name)
+			}
+		}
+		sort.Strings(unlisted)
+		plan.remove = append(plan.remove, unlisted...)
+	}
+
+	// Extensions, php.ini overrides and PHP-FPM of each PHP version
+	for _, slot := range m.Slots {
+		enabled := make(map[string]bool)
+		if extensions, err := extMgr.ListExtensions(slot.Version); err == nil {
+			for _, ext := range extensions {
+				enabled[ext.Name] = ext.Enabled
+			}
+		}
+
+		// An extension enabled for all SAPIs is enabled once
+		for _, name := range slot.Enabled {
+			if !enabled[name] {
+				plan.enable = append(plan.enable, extChange{slot.Version, name, "all"})
+				enabled[name] = true
+			}
+		}
+		for _, sapi := range []string{"cli", "fpm"} {
+			for _, name := range slot.SAPIEnabled[sapi] {
+				if !enabled[name] {
+					plan.enable = append(plan.enable, extChange{slot.Version, name, sapi})
+					enabled[name] = true
+				}
+			}
+		}
+		for _, name := range slot.Disabled {
+			if enabled[name] {
+				plan.disable = append(plan.disable, extChange{slot.Version, name, "all"})
+			}
+		}
+
+		current, err := extMgr.IniOverrides(slot.Version)
+		if err != nil {
+			return nil, fmt.Errorf("could not read php.ini overrides of PHP %s: %w", slot.Version, err)
+		}
+		if !maps.Equal(current, slot.INI) {
+			plan.ini[slot.Version] = slot.INI
+			plan.iniChanges = append(plan.iniChanges, iniChanges(slot.Version, current, slot.INI)...)
+		}
+
+		if slot.FPM != nil {
+			if *slot.FPM && !fpm.IsEnabled(slot.Version) {
+				plan.fpmEnable = append(plan.fpmEnable, slot.Version)
+			} else if !*slot.FPM && fpm.IsInstalled(slot.Version) && fpm.IsEnabled(slot.Version) {
+				plan.fpmDisable = append(plan.fpmDisable, slot.Version)
+			}
+		}
+	}
+
+	if m.Default != "" && linker.GetDefaultVersion() != m.Default {
+		plan.defaultVersion = m.Default
+	}
+	return plan, nil
+}
+
+// addDependencies adds the packages that wanted packages depend on (including
+// alternatives), so apply does not remove them. Dependencies of pinned packages
+// are pinned to the same slot, as install does.
+func addDependencies(wanted map[string]bool, mgr *pkg.Manager, available []pkg.Package) {
+	var queue []string
+	for name := range wanted {
+		queue = append(queue, name)
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		var depends []string
+		if installed := mgr.GetInstalled(name); installed != nil {
+			depends = append(depends, installed.Depends...)
+		}
+		if req := parseInstallRequest(name, available); req != nil {
+			depends = append(depends, req.Package.Depends...)
+		}
+		vinfo := pkg.ParsePackageName(name)
+
+		for _, entry := range depends {
+			clauses, err := pkg.ParseRelations(entry)
+			if err != nil {
+				continue
+			}
+			for _, clause := range clauses {
+				for _, dep := range clause {
+					depName := dep.Name
+					if vinfo != nil && vinfo.IsPinned {
+						if depInfo := pkg.ParsePackageName(depName); depInfo != nil && depInfo.MinorVersion == vinfo.MinorVersion {
+							depName = "php" + vinfo.PatchVersion + "-" + depInfo.PackageType
+						}
+					}
+					if !wanted[depName] {
+						wanted[depName] = true
+						queue = append(queue, depName)
+					}
+				}
+			}
+		}
+	}
+}
+
+// iniChanges describes the difference between two sets of php.ini overrides
+func iniChanges(version string, current, desired map[string]string) []string {
+	var changes []string
+	for key, value := range desired {
+		if old, ok := current[key]; !ok {
+			changes = append(changes, fmt.Sprintf("+ %s = %s (PHP %s)", key, value, version))
+		} else if old != value {
+			changes = append(changes, fmt.Sprintf("~ %s = %s (PHP %s, was %s)", key, value, version, old))
+		}
+	}
+	for key, value := range current {
+		if _, ok := desired[key]; !ok {
+			changes = append(changes, fmt.Sprintf("- %s = %s (PHP %s)", key, value, version))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i][2:] < changes[j][2:]
+	})
+	return changes
+}
+
+// print shows the plan
+func (p *applyPlan) print() {
+	prefixed := func(prefix string, items []string) []string {
+		lines := make([]string, len(items))
+		for i, item := range items {
+			lines[i] = prefix + item
+		}
+		return lines
+	}
+	describe := func(prefix string, changes []extChange) []string {
+		lines := make([]string, len(changes))
+		for i, c := range changes {
+			lines[i] = prefix + c.String()
+		}
+		return lines
+	}
+
+	printDryRunSection("Packages to install:", prefixed("\033[32m+\033[0m ", p.install))
+	printDryRunSection("Packages to remove:", prefixed("\033[31m-\033[0m ", p.remove))
+	printDryRunSection("Extensions to enable:", describe("\033[32m+\033[0m ", p.enable))
+	printDryRunSection("Extensions to disable:", describe("\033[31m-\033[0m ", p.disable))
+	printDryRunSection("php.ini overrides:", p.iniChanges)
+
+	var fpmLines []string
+	for _, version := range p.fpmEnable {
+		fpmLines = append(fpmLines, fmt.Sprintf("\033[32m+\033[0m PHP-FPM %s starts at boot", version))
+	}
+	for _, version := range p.fpmDisable {
+		fpmLines = append(fpmLines, fmt.Sprintf("\033[31m-\033[0m PHP-FPM %s does not start at boot", version))
+	}
+	printDryRunSection("Services:", fpmLines)
+
+	if p.defaultVersion != "" {
+		current := getLinker().GetDefaultVersion()
+		if current == "" {
+			current = "none"
+		}
+		printDryRunSection("Default version:", []string{fmt.Sprintf("%s -> %s", current, p.defaultVersion)})
+	}
+}
+
+// executeApply makes the changes of the plan: removals first, so packages
+// that are replaced do not conflict, then installs and configuration
+func executeApply(plan *applyPlan) error {
+	if len(plan.remove) > 0 {
+		if err := runRemove(plan.remove); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+
+	if len(plan.install) > 0 {
+		applyingManifest = true
+		err := runInstall(plan.install, false, false)
+		applyingManifest = false
+		if err != nil {
+			return err
+		}
+	}
+
+	extMgr := getExtManager()
+	changed := make(map[string]bool) // PHP versions whose configuration changed
+	for _, c := range plan.disable {
+		fmt.Printf("\033[34m==>\033[0m Disabling %s...\n", c)
+		if err := extMgr.Disable(c.version, c.name, c.sapi); err != nil {
+			return err
+		}
+		changed[c.version] = true
+	}
+	for _, c := range plan.enable {
+		fmt.Printf("\033[34m==>\033[0m Enabling %s...\n", c)
+		if err := extMgr.Enable(c.version, c.name, c.sapi); err != nil {
+			return err
+		}
+		changed[c.version] = true
+	}
+
+	var versions []string
+	for version := range plan.ini {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		fmt.Printf("\033[34m==>\033[0m Writing php.ini overrides (PHP %s)...\n", version)
+		if err := extMgr.SetIniOverrides(version, plan.ini[version]); err != nil {
+			return err
+		}
+		changed[version] = true
+	}
+
+	fpm := getFpmManager()
+	for _, version := range plan.fpmEnable {
+		fmt.Printf("\033[34m==>\033[0m Enabling PHP-FPM %s at boot...\n", version)
+		if err := fpm.Enable(version); err != nil {
+			return err
+		}
+	}
+	for _, version := range plan.fpmDisable {
+		fmt.Printf("\033[34m==>\033[0m Disabling PHP-FPM %s at boot...\n", version)
+		if err := fpm.Disable(version); err != nil {
+			return err
+		}
+	}
+
+	if plan.defaultVersion != "" {
+		fmt.Printf("\033[34m==>\033[0m Setting PHP %s as default...\n", plan.defaultVersion)
+		if err := getLinker().SetDefaultVersion(plan.defaultVersion); err != nil {
+			return fmt.Errorf("failed to set default version: %w", err)
+		}
+	}
+
+	var restart []string
+	for version := range changed {
+		if fpm.IsRunning(version) {
+			restart = append(restart, version)
+		}
+	}
+	sort.Strings(restart)
+	for _, version := range restart {
+		fmt.Printf("\n\033[33mNote:\033[0m Restart PHP-FPM to apply changes: phm fpm restart %s\n", version)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+func newConfigDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config-diff [packages or files...]",
+		Short: "Show changes between modified config files and their new versions",
+		Long: `Show a unified diff between each locally modified config file and the
+new version shipped by an upgrade (saved next to it as <file>.phmnew).
+
+Without arguments, all pending config files are shown.
+
+Examples:
+  phm config-diff
+  phm config-diff php8.5-fpm
+  phm config-diff /opt/php/8.5/etc/php.ini`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigDiff(args)
+		},
+	}
+	return cmd
+}
+
+func newConfigMergeCmd() *cobra.Command {
+	var useNew, keep bool
+
+	cmd := &cobra.Command{
+		Use:   "config-merge [packages or files...]",
+		Short: "Resolve config files that have new versions",
+		Long: `Resolve locally modified config files that have a new version (<file>.phmnew).
+
+For each file you can keep your version, replace it with the new version, or
+look at the diff first. With --use-new or --keep, all selected files are
+resolved without asking. The .phmnew file is removed once a file is resolved.
+
+Examples:
+  phm config-merge
+  phm config-merge php8.5-fpm --keep
+  phm config-merge /opt/php/8.5/etc/php.ini --use-new`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigMerge(args, useNew, keep)
+		},
+	}
+
+	cmd.Flags().BoolVar(&useNew, "use-new", false, "Replace local files with the new versions")
+	cmd.Flags().BoolVar(&keep, "keep", false, "Keep local files and discard the new versions")
+	cmd.MarkFlagsMutuallyExclusive("use-new", "keep")
+
+	return cmd
+}
+
+// selectConffiles returns pending config files matching the given package names or paths (all if none)
+func selectConffiles(mgr *pkg.Manager, filters []string) []pkg.Conffile {
+	pending := mgr.PendingConffiles()
+	if len(filters) == 0 {
+		return pending
+	}
+
+	var result []pkg.Conffile
+	for _, c := range pending {
+		for _, f := range filters {
+			if c.Package == f || c.Path == f || c.New == f {
+				result = append(result, c)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// printPendingConffiles tells the user about config files that need review
+func printPendingConffiles(mgr *pkg.Manager) {
+	pending := mgr.PendingConffiles()
+	if len(pending) == 0 {
+		return
+	}
+	fmt.Printf("\n\033[33mNote:\033[0m %d modified config file(s) were kept; new versions saved as .phmnew:\n", len(pending))
+	for _, c := range pending {
+		fmt.Printf("  %s\n", c.Path)
+	}
+	fmt.Printf("      Review with: phm config-diff, resolve with: phm config-merge\n")
+}
+
+// showConffileDiff prints a unified diff between the local file and the new version
+func showConffileDiff(c pkg.Conffile) error {
+	cmd := exec.Command("diff", "-u", c.Path, c.New)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	// diff exits with 1 when the files differ
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	return err
+}
+
+func runConfigDiff(filters []string) error {
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+
+	selected := selectConffiles(mgr, filters)
+	if len(selected) == 0 {
+		fmt.Println("\033[32m[OK]\033[0m No config files waiting for review")
+		return nil
+	}
+
+	for _, c := range selected {
+		fmt.Printf("\033[34m==>\033[0m %s (%s)\n", c.Path, c.Package)
+		if err := showConffileDiff(c); err != nil {
+			return fmt.Errorf("failed to diff %s: %w", c.Path, err)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func runConfigMerge(filters []string, useNew, keep bool) error {
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+
+	selected := selectConffiles(mgr, filters)
+	if len(selected) == 0 {
+		fmt.Println("\033[32m[OK]\033[0m No config files waiting for review")
+		return nil
+	}
+
+	// Config files may be owned by root
+	if err := ensureSudo(); err != nil {
+		return err
+	}
+
+	release, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, c := range selected {
+		fmt.Printf("\033[34m==>\033[0m %s (%s)\n", c.Path, c.Package)
+
+		choice := ""
+		switch {
+		case useNew:
+			choice = "n"
+		case keep:
+			choice = "k"
+		}
+		for choice == "" {
+			fmt.Printf("Keep your version (k), use the new version (n), show diff (d) or skip (s)? [k/n/d/S]: ")
+			var answer string
+			_, _ = fmt.Scanln(&answer)
+			switch answer {
+			case "k", "K", "n", "N":
+				choice = answer
+			case "d", "D":
+				if err := showConffileDiff(c); err != nil {
+					fmt.Printf("\033[33mWarning:\033[0m Could not show diff: %v\n", err)
+				}
+			default:
+				choice = "s"
+			}
+		}
+
+		switch choice {
+		case "k", "K":
+			if err := mgr.ResolveConffile(c, false); err != nil {
+				return err
+			}
+			fmt.Printf("\033[32m[OK]\033[0m Kept your version, removed %s\n", c.New)
+		case "n", "N":
+			if err := mgr.ResolveConffile(c, true); err != nil {
+				return err
+			}
+			fmt.Printf("\033[32m[OK]\033[0m Installed the new version of %s\n", c.Path)
+		default:
+			fmt.Println("Skipped.")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+func newDowngradeCmd() *cobra.Command {
+	var forceOverwrite bool
+
+	cmd := &cobra.Command{
+		Use:   "downgrade <package>[=version[-revision]]...",
+		Short: "Install an older build of installed packages",
+		Long: `Install an older build of installed packages from the index.
+
+Without a version, the newest build older than the installed one is
+installed. With a version (and revision), exactly that build is installed; it
+must be older than the installed one. The package stays in its slot, so
+php8.5-cli is downgraded in /opt/php/8.5 and php8.5.1-cli in /opt/php/8.5.1.
+
+Other installed packages of the PHP version are reinstalled at their
+installed build. 'phm upgrade' brings packages that track a minor version
+(php8.5-cli) back to the latest build.
+
+Examples:
+  phm downgrade php8.5-cli               # Previous build
+  phm downgrade php8.5-cli=8.5.1         # Newest revision of 8.5.1
+  phm downgrade php8.5-cli=8.5.1-2       # Exactly 8.5.1-2`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDowngrade(args, forceOverwrite)
+		},
+	}
+
+	cmd.Flags().BoolVar(&forceOverwrite, "force-overwrite", false, "Allow overwriting files owned by other packages")
+	return cmd
+}
+
+// runDowngrade selects the older builds and installs them like
+// phm install name=version-revision
+func runDowngrade(packages []string, forceOverwrite bool) error {
+	r, err := getRepo()
+	if err != nil {
+		return err
+	}
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+	available := r.GetPackages()
+
+	var specs []string
+	for _, arg := range packages {
+		name, version, _ := splitVersionSpec(arg)
+		installed := mgr.GetInstalled(name)
+		if installed == nil {
+			return fmt.Errorf("%s is not installed (use: phm install %s)", name, arg)
+		}
+		req := parseInstallRequest(name, available)
+		if req == nil {
+			return fmt.Errorf("%s is not in the index", name)
+		}
+		phpVersion := ""
+		if req.IsPinned {
+			phpVersion = req.InstallSlot
+		}
+
+		var build *pkg.Package
+		if version == "" {
+			build = previousBuild(available, req.CanonicalName, phpVersion, &installed.Package)
+			if build == nil {
+				return fmt.Errorf("no build of %s older than the installed %s-%d in the index", name, installed.Version, installed.Revision)
+			}
+		} else {
+			spec, err := parseVersionedRequest(arg, available)
+			if err != nil {
+				return err
+			}
+			build = &spec.Package
+			if pkg.CompareBuilds(build, &installed.Package) >= 0 {
+				return fmt.Errorf("%s %s-%d is not older than the installed %s-%d (use: phm install %s=%s-%d)",
+					name, build.Version, build.Revision, installed.Version, installed.Revision, name, build.Version, build.Revision)
+			}
+		}
+		specs = append(specs, fmt.Sprintf("%s=%s-%d", name, build.Version, build.Revision))
+	}
+
+	return runInstall(specs, false, forceOverwrite)
+}
+
+// previousBuild returns the newest listed build of a package that is older
+// than the installed one (restricted to a PHP patch version when phpVersion is set)
+func previousBuild(available []pkg.Package, name, phpVersion string, installed *pkg.Package) *pkg.Package {
+	var found *pkg.Package
+	for i := range available {
+		p := &available[i]
+		if p.Name != name || pkg.CompareBuilds(p, installed) >= 0 {
+			continue
+		}
+		if phpVersion != "" && p.Version != phpVersion && p.PHPVersion != phpVersion {
+			continue
+		}
+		if found == nil || pkg.CompareBuilds(p, found) > 0 {
+			found = p
+		}
+	}
+	return found
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/phm-dev/phm/internal/privfs"
+	"github.com/phm-dev/phm/internal/repo"
+)
+
+// dryRunCommands are the commands that support --dry-run
+var dryRunCommands = map[string]bool{
+	"install":   true,
+	"apply":     true,
+	"remove":    true,
+	"upgrade":   true,
+	"downgrade": true,
+	"use":       true,
+	"ext":       true,
+	"destruct":  true,
+	"undo":      true,
+}
+
+// dryRunFS records the changes of a command run with --dry-run instead of making them
+var dryRunFS = privfs.NewFake()
+
+// acquireLock takes the install lock. A dry run changes nothing and does not
+// wait for other phm processes.
+func acquireLock() (func(), error) {
+	if cfg.DryRun {
+		return func() {}, nil
+	}
+	return pkg.AcquireLock(cfg.InstallPrefix)
+}
+
+// dryRunPlan collects what a command would change, for --dry-run
+type dryRunPlan struct {
+	downloads []pkg.Package
+	written   []string // "+ path" for new files, "~ path" for replaced ones
+	removed   []string
+	links     map[string]string // symlink -> target
+	services  map[string]string // PHP-FPM slot -> "restart" or "stop"
+	commands  []string
+	notes     []string
+
+	planned    map[string]bool // files the planned installs write
+	incomplete bool            // file lists of packages not downloaded yet are unknown
+}
+
+func newDryRunPlan() *dryRunPlan {
+	return &dryRunPlan{
+		links:    make(map[string]string),
+		services: make(map[string]string),
+		planned:  make(map[string]bool),
+	}
+}
+
+// addInstall plans the installation of a package. Its files are listed when
+// the package is available without downloading it.
+func (d *dryRunPlan) addInstall(r *repo.Repository, mgr *pkg.Manager, p pkg.Package, opts pkg.InstallOptions) error {
+	d.downloads = append(d.downloads, p)
+
+	path := r.CachedPackage(&p)
+	if path == "" {
+		d.incomplete = true
+		d.notes = append(d.notes, fmt.Sprintf("Files of %s are listed once it is downloaded (%s installed)", p.Name, formatSize(p.InstalledSize)))
+		return nil
+	}
+	return d.addTarball(mgr, p.Name, path, opts)
+}
+
+// addTarball plans the installation of a package tarball that is already on disk
+func (d *dryRunPlan) addTarball(mgr *pkg.Manager, name, path string, opts pkg.InstallOptions) error {
+	plan, err := mgr.PlanInstall(path, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, f := range plan.Files {
+		d.planned[f.Path] = true
+		if f.Replaces {
+			d.written = append(d.written, "~ "+f.Path)
+		} else {
+			d.written = append(d.written, "+ "+f.Path)
+		}
+	}
+	for owner, files := range plan.Takeovers {
+		d.notes = append(d.notes, fmt.Sprintf("%s takes over %d file(s) from %s", name, len(files), owner))
+	}
+	return nil
+}
+
+// addLinks plans symlinks (link -> target). Links that already point to their
+// target, and links to binaries the planned installs do not provide, are skipped.
+func (d *dryRunPlan) addLinks(links map[string]string) {
+	linker := getLinker()
+	for link, target := range links {
+		if current, err := linker.ReadLink(link); err == nil && current == target {
+			continue
+		}
+		if _, err := os.Stat(target); err != nil && !d.planned[target] && !d.incomplete {
+			continue
+		}
+		d.links[link] = target
+	}
+}
+
+// addService notes that the PHP-FPM service of a slot has to be restarted (or
+// stopped) after the change, if it is running
+func (d *dryRunPlan) addService(slot, action string) {
+	d.services[slot] = action
+}
+
+// addRecorded adds the changes recorded by dryRunFS
+func (d *dryRunPlan) addRecorded() {
+	for _, op := range dryRunFS.Ops() {
+		kind, rest, _ := strings.Cut(op, " ")
+		switch kind {
+		case "install", "write", "copy", "rename", "chmod":
+			path, _, _ := strings.Cut(rest, " ")
+			if kind == "write" && unchanged(path) {
+				continue
+			}
+			if fileExists(path) {
+				d.written = append(d.written, "~ "+path)
+			} else {
+				d.written = append(d.written, "+ "+path)
+			}
+		case "remove":
+			if path, _, _ := strings.Cut(rest, " "); fileExists(path) {
+				d.removed = append(d.removed, rest)
+			}
+		case "symlink":
+			if link, target, ok := strings.Cut(rest, " -> "); ok {
+				d.addLinks(map[string]string{link: target})
+			}
+		case "run":
+			d.commands = append(d.commands, rest)
+		}
+	}
+}
+
+// print shows the plan, ending with a note that nothing was changed
+func (d *dryRunPlan) print(r *repo.Repository) {
+	if len(d.downloads) > 0 {
+		fmt.Printf("\033[1mDownloads:\033[0m\n")
+		var total, needed int64
+		for _, p := range d.downloads {
+			status := ""
+			if r.CachedPackage(&p) != "" {
+				status = " (cached)"
+			} else {
+				needed += p.Size
+			}
+			total += p.Size
+			fmt.Printf("  %-50s %10s%s\n", p.Filename(), formatSize(p.Size), status)
+		}
+		fmt.Printf("  Total: %s, %s to download\n\n", formatSize(total), formatSize(needed))
+	}
+
+	printDryRunSection("Files to be written:", dedupe(d.written))
+	printDryRunSection("Files to be removed:", dedupe(d.removed))
+
+	var links []string
+	for link, target := range d.links {
+		links = append(links, link+" -> "+target)
+	}
+	sort.Strings(links)
+	printDryRunSection("Symlinks to be changed:", links)
+
+	var services []string
+	fpm := getFpmManager()
+	for slot, action := range d.services {
+		if fpm.IsRunning(slot) {
+			services = append(services, fmt.Sprintf("PHP-FPM %s is running: %s it with phm fpm %s %s", slot, action, action, slot))
+		}
+	}
+	sort.Strings(services)
+	printDryRunSection("Services affected:", services)
+	printDryRunSection("Commands to be run:", d.commands)
+	for _, note := range d.notes {
+		fmt.Printf("\033[33mNote:\033[0m %s\n", note)
+	}
+	fmt.Printf("\033[33mDry run:\033[0m no changes were made\n")
+}
+
+// printDryRunSection prints a titled list, nothing if it is empty
+func printDryRunSection(title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Printf("\033[1m%s\033[0m\n", title)
+	for _, line := range lines {
+		fmt.Printf("  %s\n", line)
+	}
+	fmt.Println()
+}
+
+// dedupe returns lines sorted by path without duplicates, keeping the first of each
+func dedupe(lines []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, line := range lines {
+		path := strings.TrimLeft(line, "+~ ")
+		if !seen[path] {
+			seen[path] = true
+			result = append(result, line)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return strings.TrimLeft(result[i], "+~ ") < strings.TrimLeft(result[j], "+~ ")
+	})
+	return result
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Lstat(filepath.Clean(path))
+	return err == nil
+}
+
+// unchanged reports whether a file written to dryRunFS has the same contents on disk
+func unchanged(path string) bool {
+	data, err := dryRunFS.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	current, err := os.ReadFile(path)
+	return err == nil && bytes.Equal(current, data)
+}
+
+// formatSize formats a byte count for display
+func formatSize(size int64) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
+
+// printRecordedDryRun shows the changes recorded by dryRunFS. The PHP-FPM
+// service of slot, if given, has to be restarted to pick them up.
+func printRecordedDryRun(slot string) {
+	plan := newDryRunPlan()
+	plan.addRecorded()
+	if slot != "" {
+		plan.addService(slot, "restart")
+	}
+	plan.print(nil)
+}
+
+// printInstallDryRun shows what installing the requests into slots would change
+func printInstallDryRun(r *repo.Repository, mgr *pkg.Manager, linker *pkg.Linker, requests []*installRequest, slots map[string]bool, forceOverwrite bool) error {
+	plan := newDryRunPlan()
+	for _, req := range requests {
+		if err := plan.addInstall(r, mgr, req.Package, req.options(forceOverwrite)); err != nil {
+			return err
+		}
+	}
+	for slot := range slots {
+		plan.addLinks(linker.VersionLinks(slot))
+	}
+
+	// The first PHP version installed becomes the default
+	if targetSlot := preferredSlot(slots); targetSlot != "" {
+		others := 0
+		for _, v := range linker.GetAvailableVersions() {
+			if v != targetSlot {
+				others++
+			}
+		}
+		if others == 0 {
+			plan.addLinks(linker.DefaultLinks(targetSlot))
+		} else if current := linker.GetDefaultVersion(); current != targetSlot {
+			plan.notes = append(plan.notes, fmt.Sprintf("You would be asked whether to make PHP %s the default (currently %s)", targetSlot, current))
+		}
+	}
+
+	for slot := range slots {
+		plan.addService(slot, "restart")
+	}
+	plan.print(r)
+	return nil
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/phm-dev/phm/internal/repo"
+	"github.com/spf13/cobra"
+)
+
+func newHistoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history [id]",
+		Short: "Show the install, remove and upgrade history",
+		Long: `Show every install, remove, upgrade and repair transaction: when it ran,
+the command line, the user, the packages with their old and new versions and
+whether it completed or was rolled back.
+
+Give a transaction ID to show it in detail. Reverse it with: phm undo <id>
+
+Examples:
+  phm history
+  phm history 12
+  phm history --output json`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return runHistory()
+			}
+			id, err := parseTransactionID(args[0])
+			if err != nil {
+				return err
+			}
+			return runHistoryEntry(id)
+		},
+	}
+	return cmd
+}
+
+func newUndoCmd() *cobra.Command {
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "undo <id>",
+		Short: "Reverse a transaction from the history",
+		Long: `Reverse a transaction listed by phm history: packages it installed are
+removed, and packages it upgraded or removed are installed again at their
+previous version.
+
+Previous versions are taken from the repositories, or from the downloaded
+tarballs in the cache when the repositories no longer offer them. Packages
+changed again by a later transaction must be undone in order, newest first.
+
+Examples:
+  phm undo 12
+  phm undo 12 --dry-run`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseTransactionID(args[0])
+			if err != nil {
+				return err
+			}
+			return runUndo(id, yes)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Undo without asking for confirmation")
+	return cmd
+}
+
+// parseTransactionID parses a history ID argument
+func parseTransactionID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid transaction ID %q (see phm history)", arg)
+	}
+	return id, nil
+}
+
+// describeChange summarizes a history change: name 8.5.0-1 -> 8.5.1-1
+func describeChange(c pkg.HistoryChange) string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("%s (new %s)", c.Name, c.New)
+	case c.New == nil:
+		return fmt.Sprintf("%s (removed %s)", c.Name, c.Old)
+	case c.Old.Same(c.New):
+		return fmt.Sprintf("%s (%s)", c.Name, c.New)
+	}
+	return fmt.Sprintf("%s %s -> %s", c.Name, c.Old, c.New)
+}
+
+// historyResult colors a transaction result
+func historyResult(result string) string {
+	if result == pkg.HistoryRolledBack {
+		return "\033[33m" + result + "\033[0m"
+	}
+	return "\033[32m" + result + "\033[0m"
+}
+
+func runHistory() error {
+	mgr := getManager()
+	entries, err := mgr.History()
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		if entries == nil {
+			entries = []pkg.HistoryEntry{}
+		}
+		return writeOutput(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No transactions recorded yet")
+		return nil
+	}
+
+	fmt.Printf("\n\033[1m%-5s %-16s %-9s %-12s %-11s %s\033[0m\n", "ID", "Date", "Operation", "User", "Result", "Changes")
+	fmt.Printf("%-5s %-16s %-9s %-12s %-11s %s\n", strings.Repeat("-", 5), strings.Repeat("-", 16), strings.Repeat("-", 9), strings.Repeat("-", 12), strings.Repeat("-", 11), strings.Repeat("-", 30))
+	for _, e := range entries {
+		var changes []string
+		for _, c := range e.Changes {
+			changes = append(changes, describeChange(c))
+		}
+		summary := strings.Join(changes, ", ")
+		if len(summary) > 60 {
+			summary = summary[:57] + "..."
+		}
+		// Pad before coloring, escape codes have no width
+		result := fmt.Sprintf("%-11s", e.Result)
+		result = strings.Replace(result, e.Result, historyResult(e.Result), 1)
+		fmt.Printf("%-5d %-16s %-9s %-12s %s %s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04"), e.Operation, e.User, result, summary)
+	}
+	fmt.Printf("\nShow details with: phm history <id>\n")
+	return nil
+}
+
+func runHistoryEntry(id int) error {
+	mgr := getManager()
+	e, err := mgr.HistoryEntry(id)
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		return writeOutput(e)
+	}
+
+	fmt.Printf("\n\033[1mTransaction %d\033[0m\n\n", e.ID)
+	fmt.Printf("  Date:      %s\n", e.Time.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Operation: %s\n", e.Operation)
+	if e.Command != "" {
+		fmt.Printf("  Command:   %s\n", e.Command)
+	}
+	if e.User != "" {
+		fmt.Printf("  User:      %s\n", e.User)
+	}
+	fmt.Printf("  Result:    %s\n", historyResult(e.Result))
+
+	fmt.Printf("\n\033[1mPackages:\033[0m\n")
+	for _, c := range e.Changes {
+		switch {
+		case c.Old == nil:
+			fmt.Printf("  \033[32m+\033[0m %s\n", describeChange(c))
+		case c.New == nil:
+			fmt.Printf("  \033[31m-\033[0m %s\n", describeChange(c))
+		default:
+			fmt.Printf("  \033[33m~\033[0m %s\n", describeChange(c))
+		}
+	}
+	if e.Undoable() {
+		fmt.Printf("\nReverse with: phm undo %d\n", e.ID)
+	}
+	return nil
+}
+
+// undoInstall is a previous build to install again
+type undoInstall struct {
+	name    string
+	version *pkg.HistoryVersion
+	build   *pkg.Package // build offered by a repository, nil if only cached
+	path    string       // cached tarball, when build is nil
+}
+
+func runUndo(id int, yes bool) error {
+	if err := ensureSudo(); err != nil {
+		return err
+	}
+	release, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+	if err := checkPendingTransaction(mgr); err != nil {
+		return err
+	}
+
+	entries, err := mgr.History()
+	if err != nil {
+		return err
+	}
+	e, err := mgr.HistoryEntry(id)
+	if err != nil {
+		return err
+	}
+	if e.Result == pkg.HistoryRolledBack {
+		return fmt.Errorf("transaction %d was rolled back, there is nothing to undo", id)
+	}
+	if !e.Undoable() {
+		return fmt.Errorf("transaction %d changed no package versions, there is nothing to undo", id)
+	}
+
+	// Undoing is only safe while the packages are as the transaction left them
+	var conflicts []string
+	for _, c := range e.Changes {
+		if c.Old.Same(c.New) {
+			continue
+		}
+		var current *pkg.HistoryVersion
+		if p := mgr.GetInstalled(c.Name); p != nil {
+			current = &pkg.HistoryVersion{Version: p.Version, Revision: p.Revision}
+		}
+		if current.Same(c.New) {
+			continue
+		}
+		state := "not installed"
+		if current != nil {
+			state = current.String()
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s is %s now", c.Name, state))
+	}
+	if len(conflicts) > 0 {
+		for _, c := range conflicts {
+			fmt.Printf("\033[31mError:\033[0m %s\n", c)
+		}
+		return fmt.Errorf("packages changed since transaction %d; undo the later transactions first", id)
+	}
+
+	r, err := getRepo()
+	if err != nil {
+		return err
+	}
+
+	var toRemove []string
+	var toInstall []undoInstall
+	var missing []string
+	for _, c := range e.Changes {
+		if c.Old.Same(c.New) {
+			continue
+		}
+		if c.Old == nil {
+			toRemove = append(toRemove, c.Name)
+			continue
+		}
+
+		u := undoInstall{name: c.Name, version: c.Old}
+		u.build = r.FindBuild(canonicalPackageName(c.Name), c.Old.Version, c.Old.Revision, c.Old.Repo)
+		if u.build == nil {
+			p := pkg.Package{
+				Name:     canonicalPackageName(c.Name),
+				Version:  c.Old.Version,
+				Revision: c.Old.Revision,
+				Platform: cfg.Platform(),
+				Repo:     c.Old.Repo,
+				SHA256:   tarballSHA256(entries, c.Name, c.Old),
+			}
+			if u.path = r.CachedBuild(&p); u.path == "" {
+				missing = append(missing, fmt.Sprintf("%s %s", c.Name, c.Old))
+				continue
+			}
+		}
+		toInstall = append(toInstall, u)
+	}
+	if len(missing) > 0 {
+		for _, m := range missing {
+			fmt.Printf("\033[31mError:\033[0m %s is not offered by any repository and not in the cache\n", m)
+		}
+		return fmt.Errorf("cannot undo transaction %d: %d previous version(s) unavailable", id, len(missing))
+	}
+
+	// Packages removed together may depend on each other
+	for _, name := range toRemove {
+		dependents := slices.DeleteFunc(mgr.GetDependents(name), func(dep string) bool { return slices.Contains(toRemove, dep) })
+		if len(dependents) > 0 {
+			return fmt.Errorf("cannot remove %s, required by: %s", name, strings.Join(dependents, ", "))
+		}
+	}
+
+	// Extensions before the packages they depend on when removing, the reverse when installing
+	sort.SliceStable(toRemove, func(i, j int) bool {
+		return getPackagePriority(toRemove[i]) > getPackagePriority(toRemove[j])
+	})
+	sort.SliceStable(toInstall, func(i, j int) bool {
+		return getPackagePriority(toInstall[i].name) < getPackagePriority(toInstall[j].name)
+	})
+
+	fmt.Printf("\033[1mUndoing transaction %d (%s, %s):\033[0m\n", id, e.Operation, e.Time.Local().Format("2006-01-02 15:04"))
+	for _, name := range toRemove {
+		fmt.Printf("  \033[31m-\033[0m %s\n", name)
+	}
+	for _, u := range toInstall {
+		source := "cached"
+		if u.build != nil {
+			source = u.build.Repo
+		}
+		fmt.Printf("  \033[32m+\033[0m %s (%s, %s)\n", u.name, u.version, source)
+	}
+	fmt.Println()
+
+	slots := make(map[string]bool)
+	for _, c := range e.Changes {
+		if slot := extractPHPVersion(c.Name); slot != "" && !c.Old.Same(c.New) {
+			slots[slot] = true
+		}
+	}
+
+	linker := getLinker()
+	if cfg.DryRun {
+		return printUndoDryRun(r, mgr, linker, toRemove, toInstall, slots)
+	}
+
+	if !yes {
+		fmt.Print("Proceed? [y/N]: ")
+		var answer string
+		_, _ = fmt.Scanln(&answer)
+		if answer != "y" && answer != "Y" && answer != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+		fmt.Println()
+	}
+
+	var actions []pkg.TransactionAction
+	for _, name := range toRemove {
+		actions = append(actions, pkg.TransactionAction{Kind: pkg.ActionRemove, Name: name})
+	}
+	for _, u := range toInstall {
+		path := u.path
+		if u.build != nil {
+			fmt.Printf("\033[34m==>\033[0m Fetching %s...\n", u.build.Filename())
+			if path, err = r.DownloadPackage(u.build); err != nil {
+				return fmt.Errorf("failed to download %s: %w", u.name, err)
+			}
+		}
+		actions = append(actions, pkg.TransactionAction{
+			Kind: pkg.ActionInstall,
+			Name: u.name,
+			Path: path,
+			Options: pkg.InstallOptions{
+				InstallSlot: u.version.InstallSlot,
+				Pinned:      u.version.Pinned,
+				CustomName:  u.name,
+				Repository:  u.version.Repo,
+			},
+		})
+	}
+
+	tx, err := mgr.Begin("undo", actions)
+	if err != nil {
+		return err
+	}
+	for i, a := range actions {
+		if a.Kind == pkg.ActionRemove {
+			fmt.Printf("\033[34m==>\033[0m Removing %s...\n", a.Name)
+		} else {
+			fmt.Printf("\033[34m==>\033[0m Installing %s...\n", a.Name)
+		}
+		if _, err := tx.Apply(i); err != nil {
+			fmt.Printf("\033[31mError:\033[0m %v\n", err)
+			fmt.Printf("\033[31m==>\033[0m Undo failed, rolling back...\n")
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return fmt.Errorf("undo failed and could not be rolled back: %w (run: phm recover)", rbErr)
+			}
+			return fmt.Errorf("undo failed, changes rolled back")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for slot := range slots {
+		if len(mgr.GetInstalledForVersion(slot)) == 0 {
+			fmt.Printf("\033[34m==>\033[0m Removing symlinks for PHP %s...\n", slot)
+			_ = linker.RemoveVersionLinks(slot)
+			if linker.GetDefaultVersion() == slot {
+				available := slices.DeleteFunc(linker.GetAvailableVersions(), func(v string) bool { return v == slot })
+				if len(available) > 0 {
+					fmt.Printf("\033[34m==>\033[0m Setting PHP %s as new default...\n", available[0])
+					_ = linker.SetDefaultVersion(available[0])
+				}
+			}
+			continue
+		}
+		fmt.Printf("\033[34m==>\033[0m Setting up symlinks for PHP %s...\n", slot)
+		if err := linker.SetupVersionLinks(slot); err != nil {
+			fmt.Printf("\033[33mWarning:\033[0m Could not create symlinks: %v\n", err)
+		}
+		if linker.GetDefaultVersion() == slot {
+			_ = linker.SetDefaultVersion(slot)
+		}
+	}
+
+	fmt.Printf("\n\033[32m[OK]\033[0m Transaction %d undone\n", id)
+	printPendingConffiles(mgr)
+	return nil
+}
+
+// tarballSHA256 returns the tarball checksum of a build from the transaction
+// that installed it, "" if no transaction recorded it
+func tarballSHA256(entries []pkg.HistoryEntry, name string, v *pkg.HistoryVersion) string {
+	if v.SHA256 != "" {
+		return v.SHA256
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		for _, c := range entries[i].Changes {
+			if c.Name == name && c.New != nil && c.New.Same(v) && c.New.SHA256 != "" {
+				return c.New.SHA256
+			}
+		}
+	}
+	return ""
+}
+
+// printUndoDryRun shows the files and links an undo would change
+func printUndoDryRun(r *repo.Repository, mgr *pkg.Manager, linker *pkg.Linker, toRemove []string, toInstall []undoInstall, slots map[string]bool) error {
+	plan := newDryRunPlan()
+	for _, name := range toRemove {
+		files, err := mgr.PlanRemove(name)
+		if err != nil {
+			return err
+		}
+		plan.removed = append(plan.removed, files...)
+	}
+	for _, u := range toInstall {
+		opts := pkg.InstallOptions{InstallSlot: u.version.InstallSlot, Pinned: u.version.Pinned, CustomName: u.name, Repository: u.version.Repo}
+		var err error
+		if u.build != nil {
+			err = plan.addInstall(r, mgr, *u.build, opts)
+		} else {
+			err = plan.addTarball(mgr, u.name, u.path, opts)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for slot := range slots {
+		remaining := 0
+		for _, p := range mgr.GetInstalledForVersion(slot) {
+			if !slices.Contains(toRemove, p.Name) {
+				remaining++
+			}
+		}
+		if remaining == 0 && !slices.ContainsFunc(toInstall, func(u undoInstall) bool { return extractPHPVersion(u.name) == slot }) {
+			_ = linker.RemoveVersionLinks(slot)
+			plan.addService(slot, "stop")
+			continue
+		}
+		plan.addLinks(linker.VersionLinks(slot))
+		plan.addService(slot, "restart")
+	}
+	plan.addRecorded()
+	plan.print(r)
+	return nil
+}
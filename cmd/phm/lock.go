@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/phm-dev/phm/internal/lockfile"
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/phm-dev/phm/internal/repo"
+	"github.com/phm-dev/phm/internal/tools"
+	"github.com/spf13/cobra"
+)
+
+func newLockCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock [file]",
+		Short: "Write a lockfile of the installed packages and tools",
+		Long: `Write a lockfile (default: phm.lock) with the exact version, revision,
+repository and SHA256 of every installed package, and the version and
+checksum of every installed tool.
+
+Install the same builds on another machine with: phm install --locked phm.lock
+
+Every installed build must still be offered by a configured repository, so
+the lockfile can be installed again.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := lockfile.DefaultFile
+			if len(args) > 0 {
+				path = args[0]
+			}
+			return runLock(path)
+		},
+	}
+	return cmd
+}
+
+func runLock(path string) error {
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+	r, err := getRepo()
+	if err != nil {
+		return err
+	}
+	toolsMgr := getToolsManager()
+	if err := toolsMgr.LoadInstalled(); err != nil {
+		if cfg.Debug {
+			fmt.Printf("\033[33mWarning:\033[0m Could not load installed tools: %v\n", err)
+		}
+	}
+
+	l := &lockfile.Lockfile{
+		LockVersion: lockfile.Version,
+		Platform:    cfg.Platform(),
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+		Packages:    []lockfile.Package{},
+		Tools:       []lockfile.Tool{},
+	}
+
+	installed := mgr.GetAllInstalled()
+	sort.Slice(installed, func(i, j int) bool {
+		return installed[i].Name < installed[j].Name
+	})
+	var missing []string
+	for _, p := range installed {
+		build := r.FindBuild(canonicalPackageName(p.Name), p.Version, p.Revision, p.Repo)
+		if build == nil || build.SHA256 == "" {
+			missing = append(missing, fmt.Sprintf("%s %s-%d", p.Name, p.Version, p.Revision))
+			continue
+		}
+		l.Packages = append(l.Packages, lockfile.Package{
+			Name:        p.Name,
+			Package:     build.Name,
+			Version:     build.Version,
+			Revision:    build.Revision,
+			PHPVersion:  build.PHPVersion,
+			InstallSlot: p.InstallSlot,
+			Pinned:      p.Pinned,
+			Repo:        build.Repo,
+			Filename:    build.Filename(),
+			SHA256:      build.SHA256,
+		})
+	}
+	if len(missing) > 0 {
+		for _, m := range missing {
+			fmt.Printf("\033[31mError:\033[0m %s is not offered by any repository\n", m)
+		}
+		return fmt.Errorf("cannot lock %d package(s); upgrade or reinstall them first", len(missing))
+	}
+
+	installedTools := toolsMgr.GetAllInstalled()
+	sort.Slice(installedTools, func(i, j int) bool {
+		return installedTools[i].Name < installedTools[j].Name
+	})
+	for _, t := range installedTools {
+		if t.Version == "" || t.Version == "unknown" {
+			fmt.Printf("\033[33mWarning:\033[0m Version of %s is unknown, it is not locked\n", t.Name)
+			continue
+		}
+		sum, err := toolsMgr.InstalledSHA256(t.Name)
+		if err != nil {
+			return fmt.Errorf("could not checksum %s: %w", t.Name, err)
+		}
+		tool := lockfile.Tool{Name: t.Name, Version: t.Version, SHA256: sum}
+		if t.Type != tools.ToolTypePhar {
+			tool.SourceURL = t.SourceURL
+		}
+		l.Tools = append(l.Tools, tool)
+	}
+
+	if err := l.Write(path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	fmt.Printf("\033[32m[OK]\033[0m Locked %d package(s) and %d tool(s) in %s\n", len(l.Packages), len(l.Tools), path)
+	return nil
+}
+
+// runInstallLocked installs exactly the builds of a lockfile. Packages that are
+// installed at the locked build are kept; the others are installed together
+// with every package of the same PHP version (see runInstall), installed
+// packages that are not locked at their installed build.
+func runInstallLocked(path string, forceOverwrite bool) error {
+	l, err := lockfile.Read(path)
+	if err != nil {
+		return err
+	}
+	if l.Platform != cfg.Platform() {
+		return fmt.Errorf("%s was locked for %s, this machine is %s", path, l.Platform, cfg.Platform())
+	}
+
+	if err := ensureSudo(); err != nil {
+		return err
+	}
+	release, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	r, err := getRepo()
+	if err != nil {
+		return err
+	}
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+	if err := checkPendingTransaction(mgr); err != nil {
+		return err
+	}
+	toolsMgr := getToolsManager()
+	if err := toolsMgr.LoadInstalled(); err != nil {
+		if cfg.Debug {
+			fmt.Printf("\033[33mWarning:\033[0m Could not load installed tools: %v\n", err)
+		}
+	}
+
+	// Every locked build must still be offered, with the locked checksum
+	var requests []*installRequest
+	var problems []string
+	changedSlots := make(map[string]bool)
+	for _, e := range l.Packages {
+		build := r.FindBuild(e.Package, e.Version, e.Revision, e.Repo)
+		switch {
+		case build == nil:
+			problems = append(problems, fmt.Sprintf("%s %s-%d is no longer offered by any repository", e.Name, e.Version, e.Revision))
+			continue
+		case build.SHA256 != e.SHA256:
+			problems = append(problems, fmt.Sprintf("%s %s-%d has a different checksum in repository %s", e.Name, e.Version, e.Revision, build.Repo))
+			continue
+		}
+		requests = append(requests, &installRequest{
+			RequestedName: e.Name,
+			CanonicalName: e.Package,
+			InstallSlot:   e.InstallSlot,
+			IsPinned:      e.Pinned,
+			Package:       *build,
+		})
+		if installed := mgr.GetInstalled(e.Name); installed == nil || installed.Version != e.Version || installed.Revision != e.Revision {
+			changedSlots[e.InstallSlot] = true
+		}
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Printf("\033[31mError:\033[0m %s\n", p)
+		}
+		return fmt.Errorf("cannot install %s: %d locked package(s) unavailable", path, len(problems))
+	}
+
+	// MACINTOSH CODE SIGNING FIX: every package of a changed PHP version is
+	// reinstalled, so installed packages that are not locked are reinstalled at
+	// their installed build
+	locked := make(map[string]bool)
+	for _, e := range l.Packages {
+		locked[e.Name] = true
+	}
+	var reinstalls []*installRequest
+	for slot := range changedSlots {
+		if slot == "" {
+			continue
+		}
+		for _, installed := range mgr.GetInstalledForVersion(slot) {
+			if locked[installed.Name] {
+				continue
+			}
+			build := r.FindBuild(canonicalPackageName(installed.Name), installed.Version, installed.Revision, installed.Repo)
+			if build == nil {
+				problems = append(problems, fmt.Sprintf("%s %s-%d is installed but not locked, and no repository offers it to reinstall it with PHP %s",
+					installed.Name, installed.Version, installed.Revision, slot))
+				continue
+			}
+			reinstalls = append(reinstalls, &installRequest{
+				RequestedName: installed.Name,
+				CanonicalName: canonicalPackageName(installed.Name),
+				InstallSlot:   installed.InstallSlot,
+				IsPinned:      installed.Pinned,
+				Package:       *build,
+			})
+		}
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Printf("\033[31mError:\033[0m %s\n", p)
+		}
+		return fmt.Errorf("cannot install %s: remove the packages or add them to the lockfile", path)
+	}
+	sort.Slice(reinstalls, func(i, j int) bool {
+		return reinstalls[i].RequestedName < reinstalls[j].RequestedName
+	})
+
+	var toInstall []*installRequest
+	slots := make(map[string]bool)
+	for _, req := range requests {
+		if changedSlots[req.InstallSlot] {
+			toInstall = append(toInstall, req)
+			if req.InstallSlot != "" {
+				slots[req.InstallSlot] = true
+			}
+		}
+	}
+	toInstall = append(toInstall, reinstalls...)
+
+	// Composer first, phar tools are installed with it
+	var lockedTools []lockfile.Tool
+	for _, t := range l.Tools {
+		if !tools.IsKnownTool(t.Name) {
+			return fmt.Errorf("unknown tool in %s: %s", path, t.Name)
+		}
+		if installed := toolsMgr.GetInstalled(t.Name); installed != nil && installed.Version == t.Version {
+			if sum, err := toolsMgr.InstalledSHA256(t.Name); err == nil && (t.SHA256 == "" || sum == t.SHA256) {
+				continue
+			}
+		}
+		lockedTools = append(lockedTools, t)
+	}
+	sort.SliceStable(lockedTools, func(i, j int) bool {
+		return lockedTools[i].Name == "composer" && lockedTools[j].Name != "composer"
+	})
+
+	if len(toInstall) == 0 && len(lockedTools) == 0 {
+		fmt.Printf("\033[32m[OK]\033[0m Everything in %s is already installed\n", path)
+		return nil
+	}
+
+	sort.SliceStable(toInstall, func(i, j int) bool {
+		return getPackagePriority(toInstall[i].RequestedName) < getPackagePriority(toInstall[j].RequestedName)
+	})
+	fmt.Printf("\033[1mThe following locked builds will be installed from %s:\033[0m\n", path)
+	for _, req := range toInstall {
+		if !locked[req.RequestedName] {
+			continue
+		}
+		fmt.Printf("  \033[32m+\033[0m %s (%s-%d)\n", req.RequestedName, req.Package.Version, req.Package.Revision)
+	}
+	for _, t := range lockedTools {
+		fmt.Printf("  \033[32m+\033[0m %s (%s)\n", t.Name, t.Version)
+	}
+	if len(reinstalls) > 0 {
+		fmt.Printf("\n\033[1mThe following packages are not locked and will be reinstalled (macOS code signing):\033[0m\n")
+		for _, req := range reinstalls {
+			fmt.Printf("  \033[34m↻\033[0m %s (%s-%d)\n", req.RequestedName, req.Package.Version, req.Package.Revision)
+		}
+	}
+	fmt.Println()
+
+	linker := getLinker()
+	if cfg.DryRun {
+		if len(toInstall) == 0 {
+			newDryRunPlan().print(nil)
+			return nil
+		}
+		return printInstallDryRun(r, mgr, linker, toInstall, slots, forceOverwrite)
+	}
+
+	if len(toInstall) > 0 {
+		if err := installLockedPackages(r, mgr, toInstall, forceOverwrite); err != nil {
+			return err
+		}
+
+		for slot := range slots {
+			fmt.Printf("\033[34m==>\033[0m Setting up symlinks for PHP %s...\n", slot)
+			if err := linker.SetupVersionLinks(slot); err != nil {
+				fmt.Printf("\033[33mWarning:\033[0m Could not create symlinks: %v\n", err)
+			}
+		}
+		if linker.GetDefaultVersion() == "" {
+			if targetSlot := preferredSlot(slots); targetSlot != "" {
+				if err := linker.SetDefaultVersion(targetSlot); err != nil {
+					fmt.Printf("\033[33mWarning:\033[0m Could not set default: %v\n", err)
+				} else {
+					fmt.Printf("\033[32m[OK]\033[0m Default set to PHP %s\n", targetSlot)
+				}
+			}
+		}
+	}
+
+	var toolErrors int
+	for _, t := range lockedTools {
+		pin := tools.Pin{Version: t.Version, SourceURL: t.SourceURL, SHA256: t.SHA256}
+		if err := toolsMgr.InstallPinned(t.Name, pin); err != nil {
+			fmt.Printf("\033[31mError:\033[0m Failed to install %s %s: %v\n", t.Name, t.Version, err)
+			toolErrors++
+		}
+	}
+	if toolErrors > 0 {
+		return fmt.Errorf("failed to install %d tool(s)", toolErrors)
+	}
+
+	fmt.Printf("\n\033[32m[OK]\033[0m Installed %d package(s) and %d tool(s) from %s\n", len(toInstall), len(lockedTools), path)
+	return nil
+}
+
+// installLockedPackages downloads the locked builds, checks them against the
+// lockfile and installs them in one transaction
+func installLockedPackages(r *repo.Repository, mgr *pkg.Manager, requests []*installRequest, forceOverwrite bool) error {
+	actions := make([]pkg.TransactionAction, len(requests))
+	for i, req := range requests {
+		fmt.Printf("\033[34m==>\033[0m Fetching %s...\n", req.Package.Filename())
+		path, err := r.DownloadPackage(&req.Package)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", req.RequestedName, err)
+		}
+		// Local repositories are not checked by DownloadPackage
+		if err := verifySHA256(path, req.Package.SHA256); err != nil {
+			return fmt.Errorf("%s does not match the lockfile: %w", path, err)
+		}
+		actions[i] = pkg.TransactionAction{
+			Kind:    pkg.ActionInstall,
+			Name:    req.RequestedName,
+			Path:    path,
+			Options: req.options(forceOverwrite),
+		}
+	}
+
+	tx, err := mgr.Begin("install", actions)
+	if err != nil {
+		return err
+	}
+	for i, req := range requests {
+		fmt.Printf("\033[34m==>\033[0m Installing %s (%s-%d)...\n", req.RequestedName, req.Package.Version, req.Package.Revision)
+		if _, err := tx.Apply(i); err != nil {
+			fmt.Printf("\033[31mError:\033[0m Failed to install: %v\n", err)
+			fmt.Printf("\033[31m==>\033[0m Installation failed, rolling back...\n")
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return fmt.Errorf("installation failed and could not be rolled back: %w (run: phm recover)", rbErr)
+			}
+			return fmt.Errorf("installation failed, changes rolled back")
+		}
+		fmt.Printf("\033[32m[OK]\033[0m %s installed\n", req.RequestedName)
+	}
+	return tx.Commit()
+}
@@ -11,12 +11,17 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/phm-dev/phm/internal/config"
 	"github.com/phm-dev/phm/internal/httputil"
 	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/phm-dev/phm/internal/privfs"
 	"github.com/phm-dev/phm/internal/repo"
 	"github.com/phm-dev/phm/internal/tools"
 	"github.com/spf13/cobra"
@@ -69,36 +74,80 @@ func classifyPackage(name string) PackageType {
 
 // getToolsManager returns a tools manager instance
 func getToolsManager() *tools.Manager {
-	return tools.NewManager(cfg.ToolsPrefix, cfg.ToolsDataDir)
+	mgr := tools.NewManager(cfg.ToolsPrefix, cfg.ToolsDataDir, filepath.Join(cfg.InstallPrefix, "bin", "php"))
+	mgr.SetFS(getFS())
+	return mgr
 }
 
 func main() {
 	cfg = config.New()
+	var root string
 
 	rootCmd := &cobra.Command{
 		Use:     "phm",
 		Short:   "PHM - PHP Manager for macOS",
 		Long:    "A package manager for PHP installations and developer tools on macOS",
 		Version: version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Only commands that can preview their changes accept --dry-run
+			if cfg.DryRun && !dryRunCommands[cmd.Name()] {
+				return fmt.Errorf("--dry-run is not supported by phm %s", cmd.Name())
+			}
+			switch cfg.Output {
+			case outputText, outputJSON, outputYAML:
+			default:
+				return fmt.Errorf("invalid output format %q (use text, json or yaml)", cfg.Output)
+			}
+			// The configuration is read from inside the root
+			if root != "" {
+				if err := cfg.SetRoot(root); err != nil {
+					return err
+				}
+			}
+			// An invalid configuration may leave signature checks without their keys
+			if err := cfg.Load(); err != nil {
+				return fmt.Errorf("invalid configuration: %w", err)
+			}
+			return nil
+		},
 	}
 
 	// Global flags
+	rootCmd.PersistentFlags().StringVar(&root, "root", os.Getenv("PHM_ROOT"), "Install into this directory instead of / (env: PHM_ROOT)")
 	rootCmd.PersistentFlags().BoolVar(&cfg.Offline, "offline", false, "Use offline mode (local repository)")
 	rootCmd.PersistentFlags().StringVar(&cfg.RepoPath, "repo", "", "Path to local repository (implies --offline)")
 	rootCmd.PersistentFlags().BoolVar(&cfg.Debug, "debug", false, "Enable debug output")
+	rootCmd.PersistentFlags().BoolVar(&cfg.DryRun, "dry-run", false, "Show what would change without changing anything")
+	rootCmd.PersistentFlags().StringVar(&cfg.Output, "output", outputText, "Output format of list, search, info, config, use, ext list, fpm status and history: text, json or yaml")
 
 	// Commands
 	rootCmd.AddCommand(
 		newInstallCmd(),
 		newRemoveCmd(),
+		newApplyCmd(),
+		newLockCmd(),
 		newListCmd(),
 		newSearchCmd(),
 		newUpgradeCmd(),
+		newDowngradeCmd(),
 		newInfoCmd(),
+		newFilesCmd(),
+		newOwnsCmd(),
 		newUseCmd(),
 		newFpmCmd(),
 		newExtCmd(),
 		newConfigCmd(),
+		newConfigDiffCmd(),
+		newConfigMergeCmd(),
+		newUpdateCmd(),
+		newRepoCmd(),
+		newPackCmd(),
+		newServeCmd(),
+		newRecoverCmd(),
+		newHistoryCmd(),
+		newUndoCmd(),
+		newVerifyCmd(),
+		newRepairCmd(),
 		newDestructCmd(),
 		newSelfUpdateCmd(),
 	)
@@ -110,7 +159,8 @@ func main() {
 }
 
 func newInstallCmd() *cobra.Command {
-	var force bool
+	var force, forceOverwrite bool
+	var locked string
 
 	cmd := &cobra.Command{
 		Use:     "install [packages...]",
@@ -127,14 +177,26 @@ Examples:
   phm install php8.5-cli php8.5-fpm     # Install PHP packages
   phm install php8.5                     # Install PHP 8.5 (slim meta-package)
   phm install composer phpstan           # Install developer tools
-  phm install php8.5 composer phpstan    # Install PHP and tools together`,
-		Args: cobra.MinimumNArgs(1),
+  phm install php8.5 composer phpstan    # Install PHP and tools together
+  phm install php8.5-cli=8.5.1-2         # Install a specific build from the index
+  phm install --locked phm.lock          # Install the exact builds of a lockfile (see phm lock)`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runInstall(args, force)
+			if locked != "" {
+				if len(args) > 0 {
+					return fmt.Errorf("--locked installs the lockfile only, no packages can be given")
+				}
+				return runInstallLocked(locked, forceOverwrite)
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("requires at least 1 arg(s), only received 0")
+			}
+			return runInstall(args, force, forceOverwrite)
 		},
 	}
 
 	cmd.Flags().BoolVarP(&force, "force", "f", false, "Force reinstall")
+	cmd.Flags().BoolVar(&forceOverwrite, "force-overwrite", false, "Allow overwriting files owned by other packages")
+	cmd.Flags().StringVar(&locked, "locked", "", "Install exactly the builds recorded in a lockfile")
 	return cmd
 }
 
@@ -186,13 +248,17 @@ func newSearchCmd() *cobra.Command {
 }
 
 func newUpgradeCmd() *cobra.Command {
+	var forceOverwrite bool
+
 	cmd := &cobra.Command{
 		Use:   "upgrade [packages...]",
 		Short: "Upgrade packages",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runUpgrade(args)
+			return runUpgrade(args, forceOverwrite)
 		},
 	}
+
+	cmd.Flags().BoolVar(&forceOverwrite, "force-overwrite", false, "Allow overwriting files owned by other packages")
 	return cmd
 }
 
@@ -209,6 +275,23 @@ func newInfoCmd() *cobra.Command {
 	return cmd
 }
 
+func newUpdateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update package index",
+		Long: `Fetch the package index from the repository.
+
+The index is cached in the cache directory and reused by other commands
+until it is older than PHM_CACHE_EXPIRY (default: 1 hour). This command
+always revalidates it; unchanged indexes are not downloaded again.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUpdate()
+		},
+	}
+	return cmd
+}
+
 func newConfigCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "config",
@@ -287,6 +370,9 @@ Examples:
 
 // ensureSudo prompts for sudo password upfront to avoid interruptions during installation
 func ensureSudo() error {
+	if cfg.DryRun || !needsSudo() {
+		return nil
+	}
 	fmt.Printf("\033[34m==>\033[0m Checking root privileges...\n")
 	cmd := exec.Command("sudo", "-v")
 	cmd.Stdin = os.Stdin
@@ -298,7 +384,16 @@ func ensureSudo() error {
 	return nil
 }
 
-// getRepo creates and initializes repository with fresh index
+// needsSudo reports whether installing requires root privileges. User mode and
+// a relocated root (--root) that the user can write to do not.
+func needsSudo() bool {
+	if os.Geteuid() == 0 || cfg.UserMode {
+		return false
+	}
+	return cfg.Root == "" || syscall.Access(cfg.Root, 2) != nil
+}
+
+// getRepo creates and initializes repository, refreshing the index when the cache is stale
 func getRepo() (*repo.Repository, error) {
 	// If --repo is set, enable offline mode
 	if cfg.RepoPath != "" {
@@ -306,6 +401,7 @@ func getRepo() (*repo.Repository, error) {
 	}
 
 	r := repo.New(cfg)
+	out := progressOut()
 
 	// In offline mode, just load local index
 	if cfg.Offline {
@@ -315,34 +411,71 @@ func getRepo() (*repo.Repository, error) {
 		return r, nil
 	}
 
-	// Always fetch fresh index (auto-sync)
-	fmt.Printf("\033[34m==>\033[0m Syncing package index...\n")
-	if err := r.FetchIndex(); err != nil {
+	// Use the cached index while it is fresh (or when auto-update is disabled)
+	if r.IsCacheFresh() || (!cfg.AutoUpdate && r.HasCachedIndex()) {
+		if err := r.LoadIndex(); err == nil {
+			return r, nil
+		} else if cfg.Debug {
+			fmt.Fprintf(out, "\033[33mWarning:\033[0m Could not load cached index: %v\n", err)
+		}
+	}
+
+	fmt.Fprintf(out, "\033[34m==>\033[0m Syncing package index...\n")
+	if _, err := r.FetchIndex(); err != nil {
 		// Fall back to cached index if available
 		if loadErr := r.LoadIndex(); loadErr != nil {
 			return nil, fmt.Errorf("failed to fetch index: %w", err)
 		}
-		fmt.Printf("\033[33m[!]\033[0m Using cached index (fetch failed: %v)\n", err)
+		fmt.Fprintf(out, "\033[33m[!]\033[0m Using cached index (fetch failed: %v)\n", err)
 	} else {
-		fmt.Printf("\033[32m[OK]\033[0m Package index synced\n")
+		fmt.Fprintf(out, "\033[32m[OK]\033[0m Package index synced\n")
 	}
-	fmt.Println()
+	fmt.Fprintln(out)
 
 	return r, nil
 }
 
 // getManager returns a package manager instance
 func getManager() *pkg.Manager {
-	return pkg.NewManager(cfg.InstallPrefix, cfg.DataDir)
+	mgr := pkg.NewManager(cfg.InstallPrefix, cfg.DataDir)
+	mgr.SetScriptTimeout(cfg.ScriptTimeout)
+	mgr.SetLayout(getLayout())
+	mgr.SetFS(getFS())
+	mgr.SetCommand(strings.Join(append([]string{"phm"}, os.Args[1:]...), " "))
+	return mgr
+}
+
+// getFS returns how installed files are changed: directly when no root
+// privileges are needed, otherwise falling back to sudo. With --dry-run the
+// changes are only recorded.
+func getFS() privfs.FS {
+	if cfg.DryRun {
+		return dryRunFS
+	}
+	if needsSudo() {
+		return privfs.Sudo{}
+	}
+	return privfs.Direct{}
+}
+
+// getLayout returns where files outside the install prefix go: system
+// directories, or the home directory in user mode
+func getLayout() pkg.Layout {
+	if cfg.UserMode {
+		return pkg.UserLayout(cfg.Root, cfg.HomeDir)
+	}
+	return pkg.SystemLayout(cfg.Root)
 }
 
 // getLinker returns a linker instance
 func getLinker() *pkg.Linker {
-	return pkg.NewLinker(cfg.InstallPrefix)
+	linker := pkg.NewLinker(cfg.InstallPrefix, getLayout())
+	linker.SetFS(getFS())
+	return linker
 }
 
 // Command implementations
-func runInstall(packages []string, force bool) error {
+func runInstall(packages []string, force, forceOverwrite bool) error {
 	// Classify packages into tools and PHP packages
 	var toolsToInstall []string
 	var phpPackages []string
@@ -365,14 +498,20 @@ func runInstall(packages []string, force bool) error {
 	}
 
 	// Acquire exclusive lock to prevent concurrent operations
-	release, err := pkg.AcquireLock(cfg.InstallPrefix)
+	release, err := acquireLock()
 	if err != nil {
 		return err
 	}
 	defer release()
 
 	// Install tools first (they're independent)
-	if len(toolsToInstall) > 0 {
+	if len(toolsToInstall) > 0 && cfg.DryRun {
+		fmt.Printf("\033[1mThe following tools will be installed into %s:\033[0m\n", cfg.ToolsPrefix)
+		for _, name := range toolsToInstall {
+			fmt.Printf("  \033[32m+\033[0m %s\n", name)
+		}
+		fmt.Println()
+	} else if len(toolsToInstall) > 0 {
 		toolsMgr := getToolsManager()
 		if err := toolsMgr.LoadInstalled(); err != nil {
 			if cfg.Debug {
@@ -396,7 +535,9 @@ func runInstall(packages []string, force bool) error {
 
 	// If no PHP packages to install, we're done
 	if len(phpPackages) == 0 {
-		if len(toolsToInstall) > 0 {
+		if cfg.DryRun {
+			newDryRunPlan().print(nil)
+		} else if len(toolsToInstall) > 0 {
 			fmt.Printf("\033[33mNote:\033[0m Add to your PATH: export PATH=\"%s:$PATH\"\n", cfg.ToolsPrefix)
 		}
 		return nil
@@ -411,6 +552,9 @@ func runInstall(packages []string, force bool) error {
 	if err := mgr.LoadInstalled(); err != nil {
 		fmt.Printf("\033[33mWarning:\033[0m Could not load installed packages: %v\n", err)
 	}
+	if err := checkPendingTransaction(mgr); err != nil {
+		return err
+	}
 
 	linker := getLinker()
 	allAvailable := r.GetPackages()
@@ -425,7 +569,20 @@ func runInstall(packages []string, force bool) error {
 	seenPackages := make(map[string]bool)
 	installedSlots := make(map[string]bool) // Track install slots (e.g., "8.5", "8.5.1")
 
+	var requests []*installRequest
+	versionedSlots := make(map[string]bool) // slots with a name=version request
 	for _, name := range packages {
+		// A specific build: php8.5-cli=8.5.1-2
+		if strings.Contains(name, "=") {
+			req, err := parseVersionedRequest(name, allAvailable)
+			if err != nil {
+				return err
+			}
+			versionedSlots[req.InstallSlot] = true
+			requests = append(requests, req)
+			continue
+		}
+
 		// Parse the install request to handle both php8.5-cli and php8.5.1-cli
 		req := parseInstallRequest(name, allAvailable)
 		if req == nil {
@@ -444,15 +601,24 @@ func runInstall(packages []string, force bool) error {
 			}
 		}
 
-		// Resolve dependencies using canonical package
-		toInstall, err := mgr.ResolveDependencies(&req.Package, allAvailable)
-		if err != nil {
-			fmt.Printf("\033[31mError:\033[0m Failed to resolve dependencies: %v\n", err)
-			continue
-		}
+		requests = append(requests, req)
+	}
+
+	// Resolve dependencies of all requested packages together (canonical packages),
+	// so they end up as one consistent set
+	roots := make([]pkg.Package, len(requests))
+	for i, req := range requests {
+		roots[i] = req.Package
+	}
+	resolved, err := mgr.Resolve(roots, allAvailable)
+	if err != nil {
+		fmt.Printf("\033[31mError:\033[0m Failed to resolve dependencies: %v\n", err)
+		return err
+	}
 
+	for i, req := range requests {
 		// Add to install list (deduplicated by requested name)
-		for _, p := range toInstall {
+		for _, p := range resolved[i] {
 			// For dependencies, determine their install request
 			depReqName := p.Name
 			depSlot := req.InstallSlot
@@ -490,6 +656,16 @@ func runInstall(packages []string, force bool) error {
 		return nil
 	}
 
+	// Packages requested together must not conflict with each other
+	var requested []pkg.Package
+	for _, req := range newPackages {
+		requested = append(requested, req.Package)
+	}
+	if err := mgr.CheckConflicts(requested); err != nil {
+		fmt.Printf("\033[31mError:\033[0m %v\n", err)
+		return err
+	}
+
 	// MACINTOSH CODE SIGNING FIX:
 	// When adding new packages to an existing PHP installation, we must reinstall ALL
 	// packages for that version to avoid macOS Library Validation issues.
@@ -511,8 +687,14 @@ func runInstall(packages []string, force bool) error {
 				continue
 			}
 
-			// Find the latest available version
+			// Find the latest available version. Next to a specific build, the
+			// installed build is kept: the latest may not match it.
 			available := r.GetPackage(installed.Name)
+			if versionedSlots[slot] {
+				if build := r.FindBuild(installed.Name, installed.Version, installed.Revision, installed.Repo); build != nil {
+					available = build
+				}
+			}
 			if available == nil {
 				continue
 			}
@@ -550,7 +732,7 @@ func runInstall(packages []string, force bool) error {
 	for _, req := range newInstalls {
 		location := ""
 		if req.IsPinned {
-			location = fmt.Sprintf(" \033[36m[pinned -> /opt/php/%s]\033[0m", req.InstallSlot)
+			location = fmt.Sprintf(" \033[36m[pinned -> %s]\033[0m", filepath.Join(cfg.InstallPrefix, req.InstallSlot))
 		}
 		fmt.Printf("  \033[32m+\033[0m %s (%s)%s\n", req.RequestedName, req.Package.Version, location)
 	}
@@ -560,8 +742,10 @@ func runInstall(packages []string, force bool) error {
 		for _, req := range reinstalls {
 			installed := mgr.GetInstalled(req.RequestedName)
 			versionInfo := ""
-			if installed != nil && pkg.CompareVersions(req.Package.Version, installed.Version) > 0 {
+			if installed != nil && pkg.CompareVersions(req.Package.Version, installed.Version) != 0 {
 				versionInfo = fmt.Sprintf(" \033[33m%s -> %s\033[0m", installed.Version, req.Package.Version)
+			} else if installed != nil && installed.Revision != req.Package.Revision {
+				versionInfo = fmt.Sprintf(" \033[33m%s-%d -> %s-%d\033[0m", installed.Version, installed.Revision, req.Package.Version, req.Package.Revision)
 			} else {
 				versionInfo = fmt.Sprintf(" (%s)", req.Package.Version)
 			}
@@ -570,6 +754,10 @@ func runInstall(packages []string, force bool) error {
 	}
 	fmt.Println()
 
+	if cfg.DryRun {
+		return printInstallDryRun(r, mgr, linker, allToInstall, installedSlots, forceOverwrite)
+	}
+
 	// Download ALL packages in parallel
 	fmt.Printf("\033[34m==>\033[0m Downloading %d package(s) in parallel...\n", len(allToInstall))
 	var pkgsToDownload []*pkg.Package
@@ -584,7 +772,7 @@ func runInstall(packages []string, force bool) error {
 	var downloadErrors []string
 	for _, result := range downloadResults {
 		if result.Error != nil {
-			downloadErrors = append(downloadErrors, fmt.Sprintf("%s: %v", result.Package.Name, result.Error))
+			downloadErrors = append(downloadErrors, fmt.Sprintf("%s: %v", result.Package.Filename(), result.Error))
 		}
 	}
 	if len(downloadErrors) > 0 {
@@ -599,6 +787,7 @@ func runInstall(packages []string, force bool) error {
 	// Install ALL packages using merge strategy
 	var installedPkgs []pkg.Package
 	var upgradedPkgs []pkg.Package
+	var downgradedPkgs []pkg.Package
 
 	// Sort packages: common first, then cli, then others (dependency order)
 	sort.Slice(allToInstall, func(i, j int) bool {
@@ -607,73 +796,65 @@ func runInstall(packages []string, force bool) error {
 		return iPriority < jPriority
 	})
 
-	// Create backups of affected slots for rollback
-	backups := make(map[string]bool)
-	for slot := range installedSlots {
-		slotDir := filepath.Join(cfg.InstallPrefix, slot)
-		backupDir := slotDir + ".bak"
-		_ = exec.Command("sudo", "rm", "-rf", backupDir).Run()
-		if exec.Command("sudo", "cp", "-a", slotDir, backupDir).Run() == nil {
-			backups[slot] = true
+	// Install everything in one transaction, so a failure (or crash) can be rolled back
+	actions := make([]pkg.TransactionAction, len(allToInstall))
+	for i, req := range allToInstall {
+		result := downloadResults[req.Package.Filename()]
+		if result.Path == "" {
+			return fmt.Errorf("no download path for %s", req.RequestedName)
+		}
+		actions[i] = pkg.TransactionAction{
+			Kind:    pkg.ActionInstall,
+			Name:    req.RequestedName,
+			Path:    result.Path,
+			Options: req.options(forceOverwrite),
 		}
 	}
 
-	var installFailed bool
-	for _, req := range allToInstall {
-		result := downloadResults[req.CanonicalName]
-		if result.Path == "" {
-			fmt.Printf("\033[31mError:\033[0m No download path for %s\n", req.RequestedName)
-			installFailed = true
-			break
-		}
+	tx, err := mgr.Begin("install", actions)
+	if err != nil {
+		return err
+	}
 
-		wasInstalled := mgr.IsInstalled(req.RequestedName)
-		oldVersion := ""
-		if wasInstalled {
-			if old := mgr.GetInstalled(req.RequestedName); old != nil {
-				oldVersion = old.Version
-			}
+	var installFailed bool
+	for i, req := range allToInstall {
+		// Copy the installed build, Apply replaces it
+		var old *pkg.Package
+		if installed := mgr.GetInstalled(req.RequestedName); installed != nil {
+			build := installed.Package
+			old = &build
 		}
 
 		fmt.Printf("\033[34m==>\033[0m Installing %s (%s)...\n", req.RequestedName, req.Package.Version)
 
 		// Install package with merge strategy
-		opts := pkg.InstallOptions{
-			InstallSlot: req.InstallSlot,
-			Pinned:      req.IsPinned,
-			CustomName:  req.RequestedName,
-		}
-		_, err := mgr.InstallWithMerge(result.Path, opts)
-		if err != nil {
+		if _, err := tx.Apply(i); err != nil {
 			fmt.Printf("\033[31mError:\033[0m Failed to install: %v\n", err)
 			installFailed = true
 			break
 		}
 
 		// Track for summary
-		if !wasInstalled {
+		if old == nil {
 			installedPkgs = append(installedPkgs, req.Package)
-		} else if oldVersion != "" && pkg.CompareVersions(req.Package.Version, oldVersion) > 0 {
+		} else if pkg.CompareBuilds(&req.Package, old) > 0 {
 			upgradedPkgs = append(upgradedPkgs, req.Package)
+		} else if pkg.CompareBuilds(&req.Package, old) < 0 {
+			downgradedPkgs = append(downgradedPkgs, req.Package)
 		}
 
 		fmt.Printf("\033[32m[OK]\033[0m %s installed\n", req.RequestedName)
 	}
 
-	// Rollback or cleanup backups
 	if installFailed {
 		fmt.Printf("\033[31m==>\033[0m Installation failed, rolling back...\n")
-		for slot := range backups {
-			slotDir := filepath.Join(cfg.InstallPrefix, slot)
-			backupDir := slotDir + ".bak"
-			_ = exec.Command("sudo", "rm", "-rf", slotDir).Run()
-			_ = exec.Command("sudo", "mv", backupDir, slotDir).Run()
+		if err := tx.Rollback(); err != nil {
+			return fmt.Errorf("installation failed and could not be rolled back: %w (run: phm recover)", err)
 		}
 		return fmt.Errorf("installation failed, changes rolled back")
 	}
-	for slot := range backups {
-		backupDir := filepath.Join(cfg.InstallPrefix, slot) + ".bak"
-		_ = exec.Command("sudo", "rm", "-rf", backupDir).Run()
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
 	// Setup symlinks for all installed slots (once at the end)
@@ -688,18 +869,7 @@ func runInstall(packages []string, force bool) error {
 	}
 
 	// Handle default version (only once at the end)
-	// Prefer minor version slots (8.5) over pinned slots (8.5.1)
-	var targetSlot string
-	for slot := range installedSlots {
-		if targetSlot == "" {
-			targetSlot = slot
-		} else if strings.Count(slot, ".") < strings.Count(targetSlot, ".") {
-			// Prefer minor version (fewer dots)
-			targetSlot = slot
-		}
-	}
-
-	if targetSlot != "" {
+	if targetSlot := preferredSlot(installedSlots); targetSlot != "" {
 		allVersions := linker.GetAvailableVersions()
 		currentDefault := linker.GetDefaultVersion()
 
@@ -710,10 +880,10 @@ func runInstall(packages []string, force bool) error {
 				fmt.Printf("\033[33mWarning:\033[0m Could not set default: %v\n", err)
 			} else {
 				fmt.Printf("\033[32m[OK]\033[0m Default set to PHP %s\n", targetSlot)
-				fmt.Printf("\n\033[33mNote:\033[0m Add to your PATH: export PATH=\"/opt/php/bin:$PATH\"\n")
+				fmt.Printf("\n\033[33mNote:\033[0m Add to your PATH: export PATH=\"%s:$PATH\"\n", filepath.Join(cfg.InstallPrefix, "bin"))
 				fmt.Printf("      Or run: phm use %s --system\n", targetSlot)
 			}
-		} else if currentDefault != targetSlot {
+		} else if currentDefault != targetSlot && !applyingManifest {
 			// Multiple versions installed and different version is default - ask user
 			fmt.Printf("\n\033[33mCurrent default is PHP %s.\033[0m\n", currentDefault)
 			fmt.Printf("Set PHP %s as default? [y/N]: ", targetSlot)
@@ -730,11 +900,27 @@ func runInstall(packages []string, force bool) error {
 	}
 
 	// Print summary
-	printInstallSummary(installedPkgs, upgradedPkgs, installedSlots, linker)
+	printInstallSummary(installedPkgs, upgradedPkgs, downgradedPkgs, installedSlots, linker)
+	printPendingConffiles(mgr)
 
 	return nil
 }
 
+// preferredSlot returns the slot to offer as default version after installing
+// into slots: minor version slots (8.5) are preferred over pinned slots (8.5.1)
+func preferredSlot(slots map[string]bool) string {
+	var targetSlot string
+	for slot := range slots {
+		if targetSlot == "" {
+			targetSlot = slot
+		} else if strings.Count(slot, ".") < strings.Count(targetSlot, ".") {
+			// Prefer minor version (fewer dots)
+			targetSlot = slot
+		}
+	}
+	return targetSlot
+}
+
 // getPackagePriority returns installation priority (lower = first)
 func getPackagePriority(name string) int {
 	if strings.HasSuffix(name, "-common") {
@@ -795,47 +981,43 @@ type installRequest struct {
 	Package       pkg.Package
 }
 
-// parseInstallRequest parses a package name and returns installation request info
+// options returns how the request is installed
+func (req *installRequest) options(forceOverwrite bool) pkg.InstallOptions {
+	return pkg.InstallOptions{
+		InstallSlot:    req.InstallSlot,
+		Pinned:         req.IsPinned,
+		CustomName:     req.RequestedName,
+		Repository:     req.Package.Repo,
+		ForceOverwrite: forceOverwrite,
+	}
+}
+
+// parseInstallRequest parses a package name and returns installation request info.
+// The newest listed build is selected; for pinned names (php8.5.1-cli) the newest
+// build of that PHP patch version.
 func parseInstallRequest(name string, available []pkg.Package) *installRequest {
 	versionInfo := pkg.ParsePackageName(name)
 	if versionInfo == nil {
 		// Not a PHP package, use as-is
-		for _, p := range available {
-			if p.Name == name {
-				return &installRequest{
-					RequestedName: name,
-					CanonicalName: name,
-					InstallSlot:   "",
-					IsPinned:      false,
-					Package:       p,
-				}
+		if p := findListedBuild(available, name, "", "", 0); p != nil {
+			return &installRequest{
+				RequestedName: name,
+				CanonicalName: name,
+				InstallSlot:   "",
+				IsPinned:      false,
+				Package:       *p,
 			}
 		}
 		return nil
 	}
 
-	// Look up canonical package name in index
+	// Look up canonical package name in index; the index may list several builds
 	canonicalName := versionInfo.GetCanonicalName()
-	var foundPkg *pkg.Package
-	for i := range available {
-		if available[i].Name == canonicalName {
-			foundPkg = &available[i]
-			break
-		}
-	}
-
+	foundPkg := findListedBuild(available, canonicalName, versionInfo.PatchVersion, "", 0)
 	if foundPkg == nil {
 		return nil
 	}
 
-	// For pinned versions, verify the requested version matches available version
-	if versionInfo.IsPinned {
-		if foundPkg.Version != versionInfo.PatchVersion {
-			// Requested version doesn't match available version
-			return nil
-		}
-	}
-
 	return &installRequest{
 		RequestedName: name,
 		CanonicalName: canonicalName,
@@ -845,8 +1027,103 @@ func parseInstallRequest(name string, available []pkg.Package) *installRequest {
 	}
 }
 
+// splitVersionSpec splits name=version-revision (php8.5-cli=8.5.1-2) into its
+// parts. The version and revision are optional; revision 0 means any.
+func splitVersionSpec(spec string) (name, version string, revision int) {
+	name, version, found := strings.Cut(spec, "=")
+	if !found {
+		return spec, "", 0
+	}
+	if i := strings.LastIndex(version, "-"); i > 0 {
+		if rev, err := strconv.Atoi(version[i+1:]); err == nil && rev > 0 {
+			return name, version[:i], rev
+		}
+	}
+	return name, version, 0
+}
+
+// findListedBuild returns the newest listed build of a package. phpVersion
+// restricts it to builds for that PHP patch version, version and revision
+// (when set) to one build.
+func findListedBuild(available []pkg.Package, name, phpVersion, version string, revision int) *pkg.Package {
+	var found *pkg.Package
+	for i := range available {
+		p := &available[i]
+		if p.Name != name {
+			continue
+		}
+		if phpVersion != "" && p.Version != phpVersion && p.PHPVersion != phpVersion {
+			continue
+		}
+		if (version != "" && p.Version != version) || (revision != 0 && p.Revision != revision) {
+			continue
+		}
+		if found == nil || pkg.CompareBuilds(p, found) > 0 {
+			found = p
+		}
+	}
+	return found
+}
+
+// listedBuilds returns the listed builds of a package, newest first (8.5.2-1, 8.5.1-2, ...),
+// restricted to a PHP patch version when phpVersion is set
+func listedBuilds(available []pkg.Package, name, phpVersion string) []string {
+	var builds []*pkg.Package
+	for i := range available {
+		p := &available[i]
+		if p.Name == name && (phpVersion == "" || p.Version == phpVersion || p.PHPVersion == phpVersion) {
+			builds = append(builds, p)
+		}
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		return pkg.CompareBuilds(builds[i], builds[j]) > 0
+	})
+	result := make([]string, len(builds))
+	for i, p := range builds {
+		result[i] = fmt.Sprintf("%s-%d", p.Version, p.Revision)
+	}
+	return result
+}
+
+// parseVersionedRequest parses name=version[-revision] (php8.5-cli=8.5.1-2) and
+// selects that build from the index. Without a revision, the newest revision of
+// the version is selected. The build is installed into the slot of the name.
+func parseVersionedRequest(spec string, available []pkg.Package) (*installRequest, error) {
+	name, version, revision := splitVersionSpec(spec)
+	if version == "" {
+		return nil, fmt.Errorf("missing version in %s (use %s=<version>[-<revision>])", spec, name)
+	}
+
+	req := parseInstallRequest(name, available)
+	if req == nil {
+		if pkg.ParsePackageName(name) == nil || findListedBuild(available, canonicalPackageName(name), "", "", 0) == nil {
+			return nil, fmt.Errorf("package not found: %s", name)
+		}
+		// A pinned name whose PHP version is not listed at all
+		return nil, fmt.Errorf("%s is not in the index (available: %s)", name, strings.Join(listedBuilds(available, canonicalPackageName(name), ""), ", "))
+	}
+
+	phpVersion := ""
+	if req.IsPinned {
+		phpVersion = req.InstallSlot
+	}
+	build := findListedBuild(available, req.CanonicalName, phpVersion, version, revision)
+	if build == nil {
+		wanted := version
+		if revision != 0 {
+			wanted = fmt.Sprintf("%s-%d", version, revision)
+		}
+		if req.IsPinned {
+			return nil, fmt.Errorf("%s %s is not in the index for PHP %s (available: %s)", req.CanonicalName, wanted, phpVersion, strings.Join(listedBuilds(available, req.CanonicalName, phpVersion), ", "))
+		}
+		return nil, fmt.Errorf("%s %s is not in the index (available: %s)", req.CanonicalName, wanted, strings.Join(listedBuilds(available, req.CanonicalName, ""), ", "))
+	}
+	req.Package = *build
+	return req, nil
+}
+
 // printInstallSummary prints a nice summary after installation
-func printInstallSummary(installed []pkg.Package, upgraded []pkg.Package, versions map[string]bool, linker *pkg.Linker) {
+func printInstallSummary(installed []pkg.Package, upgraded []pkg.Package, downgraded []pkg.Package, versions map[string]bool, linker *pkg.Linker) {
 	fmt.Println()
 	separator := strings.Repeat("─", 50)
 	fmt.Printf("\033[1;32m%s\033[0m\n", separator)
@@ -865,7 +1142,15 @@ func printInstallSummary(installed []pkg.Package, upgraded []pkg.Package, versio
 	if len(upgraded) > 0 {
 		fmt.Printf("\n\033[1mUpgraded:\033[0m\n")
 		for _, p := range upgraded {
-			fmt.Printf("  \033[34m↑\033[0m %s (%s)\n", p.Name, p.Version)
+			fmt.Printf("  \033[34m↑\033[0m %s (%s-%d)\n", p.Name, p.Version, p.Revision)
+		}
+	}
+
+	// Downgraded packages
+	if len(downgraded) > 0 {
+		fmt.Printf("\n\033[1mDowngraded:\033[0m\n")
+		for _, p := range downgraded {
+			fmt.Printf("  \033[33m↓\033[0m %s (%s-%d)\n", p.Name, p.Version, p.Revision)
 		}
 	}
 
@@ -988,7 +1273,8 @@ func expandMetaPackages(packages []string, available []pkg.Package) []string {
 							break
 						}
 					}
-					if !isCore {
+					// The index may list several builds of an extension
+					if !isCore && !slices.Contains(fullPkgs, p.Name) {
 						fullPkgs = append(fullPkgs, p.Name)
 					}
 				}
@@ -1034,12 +1320,17 @@ func runRemove(packages []string) error {
 		return err
 	}
 
-	release, err := pkg.AcquireLock(cfg.InstallPrefix)
+	release, err := acquireLock()
 	if err != nil {
 		return err
 	}
 	defer release()
 
+	var plan *dryRunPlan
+	if cfg.DryRun {
+		plan = newDryRunPlan()
+	}
+
 	// Remove tools first
 	if len(toolsToRemove) > 0 {
 		toolsMgr := getToolsManager()
@@ -1050,6 +1341,14 @@ func runRemove(packages []string) error {
 		}
 
 		for _, name := range toolsToRemove {
+			if cfg.DryRun {
+				if installed := toolsMgr.GetInstalled(name); installed != nil {
+					plan.removed = append(plan.removed, installed.InstalledFiles...)
+				} else {
+					fmt.Printf("\033[31mError:\033[0m Failed to remove %s: tool %s is not installed\n", name, name)
+				}
+				continue
+			}
 			if err := toolsMgr.Remove(name); err != nil {
 				fmt.Printf("\033[31mError:\033[0m Failed to remove %s: %v\n", name, err)
 			}
@@ -1058,6 +1357,9 @@ func runRemove(packages []string) error {
 
 	// If no PHP packages to remove, we're done
 	if len(phpPackages) == 0 {
+		if cfg.DryRun {
+			plan.print(nil)
+		}
 		return nil
 	}
 
@@ -1065,17 +1367,23 @@ func runRemove(packages []string) error {
 	if err := mgr.LoadInstalled(); err != nil {
 		return fmt.Errorf("could not load installed packages: %w", err)
 	}
+	if err := checkPendingTransaction(mgr); err != nil {
+		return err
+	}
 
 	linker := getLinker()
 
+	// A dry run removes nothing, so packages removed earlier are tracked here
+	removed := make(map[string]bool)
+
 	for _, name := range phpPackages {
-		if !mgr.IsInstalled(name) {
+		if !mgr.IsInstalled(name) || removed[name] {
 			fmt.Printf("\033[33mWarning:\033[0m Package %s is not installed\n", name)
 			continue
 		}
 
 		// Check if other packages depend on this one
-		dependents := mgr.GetDependents(name)
+		dependents := slices.DeleteFunc(mgr.GetDependents(name), func(dep string) bool { return removed[dep] })
 		if len(dependents) > 0 {
 			fmt.Printf("\033[31mError:\033[0m Cannot remove %s, required by:\n", name)
 			for _, dep := range dependents {
@@ -1085,46 +1393,93 @@ func runRemove(packages []string) error {
 			continue
 		}
 
-		fmt.Printf("\033[34m==>\033[0m Removing %s...\n", name)
+		if cfg.DryRun {
+			files, err := mgr.PlanRemove(name)
+			if err != nil {
+				return err
+			}
+			plan.removed = append(plan.removed, files...)
+		} else {
+			fmt.Printf("\033[34m==>\033[0m Removing %s...\n", name)
 
-		if err := mgr.Remove(name); err != nil {
-			fmt.Printf("\033[31mError:\033[0m Failed to remove %s: %v\n", name, err)
-			continue
-		}
+			if err := removeInTransaction(mgr, name); err != nil {
+				fmt.Printf("\033[31mError:\033[0m Failed to remove %s: %v\n", name, err)
+				continue
+			}
 
-		fmt.Printf("\033[32m[OK]\033[0m %s removed\n", name)
+			fmt.Printf("\033[32m[OK]\033[0m %s removed\n", name)
+		}
+		removed[name] = true
 
 		// Check if this was the last package for a PHP version
 		phpVersion := extractPHPVersion(name)
 		if phpVersion != "" {
 			// Check if any packages for this version remain
-			remaining := mgr.GetInstalledByPrefix("php" + phpVersion)
+			var remaining []string
+			for _, p := range mgr.GetInstalledByPrefix("php" + phpVersion) {
+				if !removed[p.Name] {
+					remaining = append(remaining, p.Name)
+				}
+			}
 			if len(remaining) == 0 {
-				fmt.Printf("\033[34m==>\033[0m Removing symlinks for PHP %s...\n", phpVersion)
+				if !cfg.DryRun {
+					fmt.Printf("\033[34m==>\033[0m Removing symlinks for PHP %s...\n", phpVersion)
+				}
 				_ = linker.RemoveVersionLinks(phpVersion)
 
 				// If this was the default version, clear it
 				if linker.GetDefaultVersion() == phpVersion {
 					// Try to set another version as default
-					available := linker.GetAvailableVersions()
+					available := slices.DeleteFunc(linker.GetAvailableVersions(), func(v string) bool { return v == phpVersion })
 					if len(available) > 0 {
-						fmt.Printf("\033[34m==>\033[0m Setting PHP %s as new default...\n", available[0])
+						if !cfg.DryRun {
+							fmt.Printf("\033[34m==>\033[0m Setting PHP %s as new default...\n", available[0])
+						}
 						_ = linker.SetDefaultVersion(available[0])
 					}
 				}
+				if cfg.DryRun {
+					plan.addService(phpVersion, "stop")
+				}
+			} else if cfg.DryRun {
+				plan.addService(phpVersion, "restart")
 			}
 		}
 	}
 
+	if cfg.DryRun {
+		plan.addRecorded()
+		plan.print(nil)
+	}
 	return nil
 }
 
+// listOutput is the --output schema of phm list
+type listOutput struct {
+	Packages []pkg.PackageInfo `json:"packages"`
+	Tools    []pkg.PackageInfo `json:"tools"`
+}
+
+// toolInfo returns a tool with its state. Tools are not in the package index,
+// so only name, description and installed version are known.
+func toolInfo(toolsMgr *tools.Manager, name string) pkg.PackageInfo {
+	info := pkg.PackageInfo{Package: pkg.Package{Name: name}, State: pkg.StateNotInstalled}
+	if tool := tools.GetTool(name); tool != nil {
+		info.Package.Description = tool.Description
+	}
+	if installed := toolsMgr.GetInstalled(name); installed != nil {
+		info.State = pkg.StateInstalled
+		info.InstalledVersion = installed.Version
+	}
+	return info
+}
+
 func runList(pattern string, showAvailable, showInstalled bool) error {
 	mgr := getManager()
 	if err := mgr.LoadInstalled(); err != nil {
 		// Not fatal, just won't show installed status
 		if cfg.Debug {
-			fmt.Printf("\033[33mWarning:\033[0m Could not load installed packages: %v\n", err)
+			fmt.Fprintf(progressOut(), "\033[33mWarning:\033[0m Could not load installed packages: %v\n", err)
 		}
 	}
 
@@ -1132,7 +1487,7 @@ func runList(pattern string, showAvailable, showInstalled bool) error {
 	toolsMgr := getToolsManager()
 	if err := toolsMgr.LoadInstalled(); err != nil {
 		if cfg.Debug {
-			fmt.Printf("\033[33mWarning:\033[0m Could not load installed tools: %v\n", err)
+			fmt.Fprintf(progressOut(), "\033[33mWarning:\033[0m Could not load installed tools: %v\n", err)
 		}
 	}
 
@@ -1141,6 +1496,21 @@ func runList(pattern string, showAvailable, showInstalled bool) error {
 		installedPkgs := mgr.GetAllInstalled()
 		installedTools := toolsMgr.GetAllInstalled()
 
+		if structuredOutput() {
+			result := listOutput{Packages: []pkg.PackageInfo{}, Tools: []pkg.PackageInfo{}}
+			for _, p := range installedPkgs {
+				if pattern == "" || strings.Contains(p.Name, pattern) {
+					result.Packages = append(result.Packages, pkg.PackageInfo{Package: p.Package, State: pkg.StateInstalled, InstalledVersion: p.Version})
+				}
+			}
+			for _, t := range installedTools {
+				if pattern == "" || strings.Contains(t.Name, pattern) {
+					result.Tools = append(result.Tools, toolInfo(toolsMgr, t.Name))
+				}
+			}
+			return writeOutput(result)
+		}
+
 		if len(installedPkgs) == 0 && len(installedTools) == 0 {
 			fmt.Println("No packages or tools installed")
 			fmt.Println("\nUse: phm list -a  to show available packages and tools")
@@ -1198,6 +1568,26 @@ func runList(pattern string, showAvailable, showInstalled bool) error {
 
 	packages := r.GetPackages()
 
+	if structuredOutput() {
+		result := listOutput{Packages: []pkg.PackageInfo{}, Tools: []pkg.PackageInfo{}}
+		for _, p := range packages {
+			if pattern == "" || strings.Contains(p.Name, pattern) {
+				result.Packages = append(result.Packages, mgr.PackageInfo(p))
+			}
+		}
+		var toolNames []string
+		for name := range tools.GetAllTools() {
+			if pattern == "" || strings.Contains(name, pattern) {
+				toolNames = append(toolNames, name)
+			}
+		}
+		sort.Strings(toolNames)
+		for _, name := range toolNames {
+			result.Tools = append(result.Tools, toolInfo(toolsMgr, name))
+		}
+		return writeOutput(result)
+	}
+
 	// Show tools first
 	fmt.Printf("\n\033[1mDeveloper Tools:\033[0m\n")
 	fmt.Printf("%-20s %-12s %s\n", strings.Repeat("-", 20), strings.Repeat("-", 12), strings.Repeat("-", 35))
@@ -1237,7 +1627,7 @@ func runList(pattern string, showAvailable, showInstalled bool) error {
 				countInstalled++
 
 				// Highlight if upgrade available
-				if pkg.CompareVersions(p.Version, installedPkg.Version) > 0 {
+				if pkg.IsUpgrade(&p, &installedPkg.Package) {
 					installedVer = fmt.Sprintf("\033[33m%s\033[0m", installedPkg.Version)
 				} else {
 					installedVer = fmt.Sprintf("\033[32m%s\033[0m", installedPkg.Version)
@@ -1261,7 +1651,7 @@ func runList(pattern string, showAvailable, showInstalled bool) error {
 			upgradeCount := 0
 			for _, p := range packages {
 				if installedPkg := mgr.GetInstalled(p.Name); installedPkg != nil {
-					if pkg.CompareVersions(p.Version, installedPkg.Version) > 0 {
+					if pkg.IsUpgrade(&p, &installedPkg.Package) {
 						upgradeCount++
 					}
 				}
@@ -1285,6 +1675,15 @@ func runSearch(query string) error {
 	}
 
 	results := r.SearchPackages(query)
+	if structuredOutput() {
+		mgr := getManager()
+		_ = mgr.LoadInstalled() // Ignore error, packages are shown as not installed
+		infos := []pkg.PackageInfo{}
+		for _, p := range results {
+			infos = append(infos, mgr.PackageInfo(p))
+		}
+		return writeOutput(infos)
+	}
 	if len(results) == 0 {
 		fmt.Printf("No packages found matching '%s'\n", query)
 		return nil
@@ -1304,13 +1703,28 @@ func runSearch(query string) error {
 	return nil
 }
 
-func runUpgrade(packages []string) error {
+// removeInTransaction removes a package, restoring it if the removal fails midway
+func removeInTransaction(mgr *pkg.Manager, name string) error {
+	tx, err := mgr.Begin("remove", []pkg.TransactionAction{{Kind: pkg.ActionRemove, Name: name}})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Apply(0); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w; rollback failed: %v (run: phm recover)", err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func runUpgrade(packages []string, forceOverwrite bool) error {
 	// Prompt for sudo password upfront
 	if err := ensureSudo(); err != nil {
 		return err
 	}
 
-	release, err := pkg.AcquireLock(cfg.InstallPrefix)
+	release, err := acquireLock()
 	if err != nil {
 		return err
 	}
@@ -1348,6 +1762,15 @@ func runUpgrade(packages []string) error {
 	if len(toolsToUpgrade) > 0 {
 		fmt.Println("\033[34m==>\033[0m Checking for tool upgrades...")
 		for _, name := range toolsToUpgrade {
+			if cfg.DryRun {
+				current, latest, hasUpgrade, err := toolsMgr.CheckUpgrade(name)
+				if err != nil {
+					fmt.Printf("\033[31mError:\033[0m Failed to check %s: %v\n", name, err)
+				} else if hasUpgrade {
+					fmt.Printf("    %s will be upgraded: %s -> %s\n", name, current, latest)
+				}
+				continue
+			}
 			if err := toolsMgr.Upgrade(name); err != nil {
 				fmt.Printf("\033[31mError:\033[0m Failed to upgrade %s: %v\n", name, err)
 			}
@@ -1364,6 +1787,9 @@ func runUpgrade(packages []string) error {
 	if err := mgr.LoadInstalled(); err != nil {
 		return fmt.Errorf("could not load installed packages: %w", err)
 	}
+	if err := checkPendingTransaction(mgr); err != nil {
+		return err
+	}
 
 	// If no packages specified, check all installed packages
 	var toCheck []string
@@ -1406,13 +1832,19 @@ func runUpgrade(packages []string) error {
 			continue
 		}
 
-		if newVer := mgr.CheckUpgradeWithPHP(name, available.Version, available.PHPVersion); newVer != "" {
-			upgrades = append(upgrades, upgrade{
+		if newVer := mgr.CheckUpgradeWithPHP(name, available); newVer != "" {
+			u := upgrade{
 				installedName: name,
 				canonicalName: canonicalName,
 				oldVersion:    installed.Version,
 				newVersion:    newVer,
-			})
+			}
+			// A rebuild of the same version: show the revisions
+			if available.Version == installed.Version && available.Revision != installed.Revision {
+				u.oldVersion = fmt.Sprintf("%s-%d", installed.Version, installed.Revision)
+				u.newVersion = fmt.Sprintf("%s-%d", available.Version, available.Revision)
+			}
+			upgrades = append(upgrades, u)
 		}
 	}
 
@@ -1432,20 +1864,29 @@ func runUpgrade(packages []string) error {
 	linker := getLinker()
 	allAvailable := r.GetPackages()
 
+	// Resolve all upgrades together, so an upgraded dependency never breaks another
+	// package that is upgraded in the same run
+	var roots []pkg.Package
+	var rootUpgrades []upgrade
 	for _, u := range upgrades {
-		pkgToInstall := r.GetPackage(u.canonicalName)
-		if pkgToInstall == nil {
-			continue
+		if p := r.GetPackage(u.canonicalName); p != nil {
+			roots = append(roots, *p)
+			rootUpgrades = append(rootUpgrades, u)
 		}
+	}
+	resolved, err := mgr.Resolve(roots, allAvailable)
+	if err != nil {
+		fmt.Printf("\033[31mError:\033[0m Failed to resolve dependencies: %v\n", err)
+		return err
+	}
 
-		// Resolve dependencies
-		toInstall, err := mgr.ResolveDependencies(pkgToInstall, allAvailable)
-		if err != nil {
-			fmt.Printf("\033[31mError:\033[0m Failed to resolve dependencies for %s: %v\n", u.canonicalName, err)
-			continue
-		}
+	// Collect the packages that need upgrading (including dependencies)
+	var toUpgrade []pkg.Package
+	seen := make(map[string]bool)
+	slots := make(map[string]bool)
+	for i, toInstall := range resolved {
+		u := rootUpgrades[i]
 
-		// Install each package (including dependencies that need upgrade)
 		for _, p := range toInstall {
 			// Check if upgrade needed using normalized name
 			normalizedName := normalizePackageName(p.Name)
@@ -1459,60 +1900,120 @@ func runUpgrade(packages []string) error {
 					}
 				}
 			}
-			if installedPkg != nil {
-				// Compare extension version first
-				versionCmp := pkg.CompareVersions(p.Version, installedPkg.Version)
-				if versionCmp < 0 {
-					continue // Available version is older
-				}
-				if versionCmp == 0 {
-					// Same extension version - compare PHP version (e.g., 8.5.0 vs 8.5.1)
-					phpCmp := pkg.CompareVersions(p.PHPVersion, installedPkg.PHPVersion)
-					if phpCmp <= 0 {
-						continue // Same or older PHP version
-					}
-				}
+			if installedPkg != nil && !pkg.IsUpgrade(&p, &installedPkg.Package) {
+				continue // Same or older build
 			}
 
-			fmt.Printf("\033[34m==>\033[0m Upgrading %s to %s...\n", p.Name, p.Version)
-
-			// Download package
-			path, err := r.DownloadPackage(&p)
-			if err != nil {
-				fmt.Printf("\033[31mError:\033[0m Failed to download: %v\n", err)
-				continue
+			if !seen[p.Name] {
+				seen[p.Name] = true
+				toUpgrade = append(toUpgrade, p)
 			}
+		}
 
-			// Install package (overwrites existing)
-			_, err = mgr.Install(path)
-			if err != nil {
-				fmt.Printf("\033[31mError:\033[0m Failed to install: %v\n", err)
-				continue
+		if phpVersion := extractPHPVersion(u.installedName); phpVersion != "" {
+			slots[phpVersion] = true
+		}
+	}
+
+	if cfg.DryRun {
+		plan := newDryRunPlan()
+		for _, p := range toUpgrade {
+			if err := plan.addInstall(r, mgr, p, pkg.InstallOptions{Repository: p.Repo, ForceOverwrite: forceOverwrite}); err != nil {
+				return err
+			}
+		}
+		for phpVersion := range slots {
+			plan.addLinks(linker.VersionLinks(phpVersion))
+			if linker.GetDefaultVersion() == phpVersion {
+				plan.addLinks(linker.DefaultLinks(phpVersion))
 			}
+			plan.addService(phpVersion, "restart")
+		}
+		plan.print(r)
+		return nil
+	}
 
-			fmt.Printf("\033[32m[OK]\033[0m %s upgraded to %s\n", p.Name, p.Version)
+	// Download everything before touching the installation
+	var actions []pkg.TransactionAction
+	var upgraded []pkg.Package
+	var downloadErrors []string
+	for _, p := range toUpgrade {
+		path, err := r.DownloadPackage(&p)
+		if err != nil {
+			downloadErrors = append(downloadErrors, fmt.Sprintf("%s: %v", p.Filename(), err))
+			continue
+		}
+		actions = append(actions, pkg.TransactionAction{
+			Kind:    pkg.ActionInstall,
+			Name:    p.Name,
+			Path:    path,
+			Options: pkg.InstallOptions{Repository: p.Repo, ForceOverwrite: forceOverwrite},
+		})
+		upgraded = append(upgraded, p)
+	}
+	if len(downloadErrors) > 0 {
+		fmt.Printf("\033[31mError:\033[0m Failed to download packages:\n")
+		for _, e := range downloadErrors {
+			fmt.Printf("  - %s\n", e)
 		}
+		return fmt.Errorf("download failed, nothing was upgraded")
+	}
 
-		// Update symlinks if needed
-		phpVersion := extractPHPVersion(u.installedName)
-		if phpVersion != "" {
-			_ = linker.SetupVersionLinks(phpVersion)
-			if linker.GetDefaultVersion() == phpVersion {
-				_ = linker.SetDefaultVersion(phpVersion)
+	// Install all upgrades in one transaction (overwrites existing files)
+	tx, err := mgr.Begin("upgrade", actions)
+	if err != nil {
+		return err
+	}
+	for i, p := range upgraded {
+		fmt.Printf("\033[34m==>\033[0m Upgrading %s to %s...\n", p.Name, p.Version)
+		if _, err := tx.Apply(i); err != nil {
+			fmt.Printf("\033[31mError:\033[0m Failed to install: %v\n", err)
+			fmt.Printf("\033[31m==>\033[0m Upgrade failed, rolling back...\n")
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return fmt.Errorf("upgrade failed and could not be rolled back: %w (run: phm recover)", rbErr)
 			}
+			return fmt.Errorf("upgrade failed, changes rolled back")
+		}
+		fmt.Printf("\033[32m[OK]\033[0m %s upgraded to %s\n", p.Name, p.Version)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Update symlinks if needed
+	for phpVersion := range slots {
+		_ = linker.SetupVersionLinks(phpVersion)
+		if linker.GetDefaultVersion() == phpVersion {
+			_ = linker.SetDefaultVersion(phpVersion)
 		}
 	}
 
 	fmt.Println("\n\033[32m[OK]\033[0m Upgrade complete")
+	printPendingConffiles(mgr)
 	return nil
 }
 
+// infoOutput is the --output schema of phm info
+type infoOutput struct {
+	pkg.PackageInfo
+	InstalledFiles []string `json:"installed_files,omitempty"`
+	RequiredBy     []string `json:"required_by,omitempty"`
+}
+
 func runInfo(pkgName string) error {
 	// Check if it's a tool
 	if tool := tools.GetTool(pkgName); tool != nil {
 		toolsMgr := getToolsManager()
 		_ = toolsMgr.LoadInstalled()
 
+		if structuredOutput() {
+			result := infoOutput{PackageInfo: toolInfo(toolsMgr, pkgName)}
+			if installed := toolsMgr.GetInstalled(pkgName); installed != nil {
+				result.InstalledFiles = installed.InstalledFiles
+			}
+			return writeOutput(result)
+		}
+
 		fmt.Printf("\n\033[1mTool: %s\033[0m\n\n", tool.Name)
 		fmt.Printf("  Description:  %s\n", tool.Description)
 		fmt.Printf("  Type:         %s\n", tool.Type)
@@ -1567,11 +2068,25 @@ func runInfo(pkgName string) error {
 		p = &installedPkg.Package
 	}
 
+	if structuredOutput() {
+		var result infoOutput
+		if availablePkg != nil {
+			result.PackageInfo = mgr.PackageInfo(*availablePkg)
+		} else {
+			result.PackageInfo = pkg.PackageInfo{Package: *p, State: pkg.StateInstalled, InstalledVersion: installedPkg.Version}
+		}
+		if installedPkg != nil {
+			result.InstalledFiles = installedPkg.InstalledFiles
+			result.RequiredBy = mgr.GetDependents(pkgName)
+		}
+		return writeOutput(result)
+	}
+
 	fmt.Printf("\n\033[1mPackage: %s\033[0m\n\n", p.Name)
 	fmt.Printf("  Available:    %s\n", p.Version)
 
 	if installedPkg != nil {
-		if availablePkg != nil && pkg.CompareVersions(availablePkg.Version, installedPkg.Version) > 0 {
+		if availablePkg != nil && pkg.IsUpgrade(availablePkg, &installedPkg.Package) {
 			fmt.Printf("  Installed:    \033[33m%s\033[0m (upgrade available)\n", installedPkg.Version)
 		} else {
 			fmt.Printf("  Installed:    \033[32m%s\033[0m\n", installedPkg.Version)
@@ -1583,6 +2098,14 @@ func runInfo(pkgName string) error {
 	fmt.Printf("  Revision:     %d\n", p.Revision)
 	fmt.Printf("  Description:  %s\n", p.Description)
 	fmt.Printf("  Platform:     %s\n", p.Platform)
+	if availablePkg != nil {
+		fmt.Printf("  Repository:   %s\n", describeRepository(r, p.Repo))
+		for _, other := range r.GetPackageInAllSources(pkgName) {
+			if other.Repo != p.Repo {
+				fmt.Printf("                also in %s (%s-%d)\n", other.Repo, other.Version, other.Revision)
+			}
+		}
+	}
 
 	if len(p.Depends) > 0 {
 		fmt.Printf("  Dependencies: %s\n", strings.Join(p.Depends, ", "))
@@ -1592,6 +2115,14 @@ func runInfo(pkgName string) error {
 		fmt.Printf("  Provides:     %s\n", strings.Join(p.Provides, ", "))
 	}
 
+	if len(p.Conflicts) > 0 {
+		fmt.Printf("  Conflicts:    %s\n", strings.Join(p.Conflicts, ", "))
+	}
+
+	if len(p.Replaces) > 0 {
+		fmt.Printf("  Replaces:     %s\n", strings.Join(p.Replaces, ", "))
+	}
+
 	if p.Size > 0 {
 		fmt.Printf("  Size:         %.2f KB\n", float64(p.Size)/1024)
 	}
@@ -1602,7 +2133,7 @@ func runInfo(pkgName string) error {
 		maxFiles := 10
 		for i, f := range installedPkg.InstalledFiles {
 			if i >= maxFiles {
-				fmt.Printf("    ... and %d more files\n", len(installedPkg.InstalledFiles)-maxFiles)
+				fmt.Printf("    ... and %d more files (run: phm files %s)\n", len(installedPkg.InstalledFiles)-maxFiles, pkgName)
 				break
 			}
 			fmt.Printf("    %s\n", f)
@@ -1621,21 +2152,145 @@ func runInfo(pkgName string) error {
 	return nil
 }
 
+// describeRepository formats a repository name with its location for display
+func describeRepository(r *repo.Repository, name string) string {
+	for _, src := range r.Sources() {
+		if src.Name == name {
+			return fmt.Sprintf("%s (%s)", src.Name, src.Location())
+		}
+	}
+	return name
+}
+
+func runUpdate() error {
+	if cfg.RepoPath != "" {
+		cfg.Offline = true
+	}
+
+	r := repo.New(cfg)
+
+	if cfg.Offline {
+		if err := r.LoadIndex(); err != nil {
+			return fmt.Errorf("failed to load index: %w", err)
+		}
+		fmt.Printf("\033[32m[OK]\033[0m Offline mode: using local index %s (%d packages)\n", cfg.GetIndexPath(), len(r.GetPackages()))
+		return nil
+	}
+
+	for _, src := range r.Sources() {
+		fmt.Printf("\033[34m==>\033[0m Updating package index from %s...\n", src.Location())
+	}
+	updated, err := r.FetchIndex()
+	if err != nil {
+		return err
+	}
+
+	if updated {
+		fmt.Printf("\033[32m[OK]\033[0m Package index updated (%d packages)\n", len(r.GetPackages()))
+	} else {
+		fmt.Printf("\033[32m[OK]\033[0m Package index is up to date (%d packages)\n", len(r.GetPackages()))
+	}
+	return nil
+}
+
+// configOutput is the --output schema of phm config
+type configOutput struct {
+	Mode                   string             `json:"mode"`
+	Repositories           []configRepository `json:"repositories"`
+	Root                   string             `json:"root,omitempty"`
+	UserMode               bool               `json:"user_mode"`
+	InstallPrefix          string             `json:"install_prefix"`
+	ToolsPrefix            string             `json:"tools_prefix"`
+	CacheDir               string             `json:"cache_dir"`
+	DataDir                string             `json:"data_dir"`
+	ToolsDataDir           string             `json:"tools_data_dir"`
+	Platform               string             `json:"platform"`
+	CacheExpiry            string             `json:"cache_expiry"`
+	IndexFetched           *time.Time         `json:"index_fetched,omitempty"`
+	TrustedKeys            int                `json:"trusted_keys"`
+	VerifyPackageSignature bool               `json:"verify_package_signature"`
+}
+
+// configRepository is a configured repository in configOutput
+type configRepository struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	Priority int    `json:"priority"`
+}
+
 func runConfig() error {
 	mode := "online"
 	if cfg.Offline || cfg.RepoPath != "" {
 		mode = "offline"
 	}
 
+	if structuredOutput() {
+		result := configOutput{
+			Mode:                   mode,
+			Repositories:           []configRepository{},
+			Root:                   cfg.Root,
+			UserMode:               cfg.UserMode,
+			InstallPrefix:          cfg.InstallPrefix,
+			ToolsPrefix:            cfg.ToolsPrefix,
+			CacheDir:               cfg.CacheDir,
+			DataDir:                cfg.DataDir,
+			ToolsDataDir:           cfg.ToolsDataDir,
+			Platform:               cfg.Platform(),
+			CacheExpiry:            cfg.CacheExpiry.String(),
+			TrustedKeys:            len(cfg.TrustedKeys),
+			VerifyPackageSignature: cfg.VerifyPackageSignature,
+		}
+		for _, src := range cfg.Repositories() {
+			result.Repositories = append(result.Repositories, configRepository{Name: src.Name, Location: src.Location(), Priority: src.Priority})
+		}
+		if mode == "online" {
+			if age := repo.New(cfg).CacheAge(); age > 0 {
+				fetched := time.Now().Add(-age).Round(time.Second)
+				result.IndexFetched = &fetched
+			}
+		}
+		return writeOutput(result)
+	}
+
 	fmt.Printf("\n\033[1mPHM Configuration\033[0m\n\n")
 	fmt.Printf("  Mode:           %s\n", mode)
-	fmt.Printf("  Repository:     %s\n", cfg.GetRepoURL())
+	sources := cfg.Repositories()
+	if len(sources) == 1 {
+		fmt.Printf("  Repository:     %s\n", sources[0].Location())
+	} else {
+		fmt.Printf("  Repositories:\n")
+		for _, src := range sources {
+			fmt.Printf("    %-12s %s (priority %d)\n", src.Name, src.Location(), src.Priority)
+		}
+	}
+	if cfg.Root != "" {
+		fmt.Printf("  Root:           %s\n", cfg.Root)
+	}
+	if cfg.UserMode {
+		fmt.Printf("  User mode:      enabled (no sudo)\n")
+	}
 	fmt.Printf("  Install prefix: %s\n", cfg.InstallPrefix)
 	fmt.Printf("  Tools prefix:   %s\n", cfg.ToolsPrefix)
 	fmt.Printf("  Cache dir:      %s\n", cfg.CacheDir)
 	fmt.Printf("  Data dir:       %s\n", cfg.DataDir)
 	fmt.Printf("  Tools data:     %s\n", cfg.ToolsDataDir)
 	fmt.Printf("  Platform:       %s\n", cfg.Platform())
+	fmt.Printf("  Cache expiry:   %s\n", cfg.CacheExpiry)
+	if !cfg.Offline && cfg.RepoPath == "" {
+		if age := repo.New(cfg).CacheAge(); age > 0 {
+			fmt.Printf("  Index fetched:  %s ago\n", age.Round(time.Second))
+		} else {
+			fmt.Printf("  Index fetched:  never (run: phm update)\n")
+		}
+	}
+	if len(cfg.TrustedKeys) > 0 {
+		fmt.Printf("  Trusted keys:   %d (index signatures required)\n", len(cfg.TrustedKeys))
+	} else {
+		fmt.Printf("  Trusted keys:   none (signatures not verified)\n")
+	}
+	if cfg.VerifyPackageSignature {
+		fmt.Printf("  Package sigs:   required\n")
+	}
 	fmt.Println()
 	return nil
 }
@@ -1663,6 +2318,19 @@ func runUse(version string, system bool) error {
 		return nil
 	}
 
+	if cfg.DryRun {
+		if err := linker.SetDefaultVersion(version); err != nil {
+			return fmt.Errorf("failed to set default version: %w", err)
+		}
+		if system {
+			if err := linker.SetSystemDefault(version); err != nil {
+				return fmt.Errorf("failed to create system symlinks: %w", err)
+			}
+		}
+		printRecordedDryRun("")
+		return nil
+	}
+
 	fmt.Printf("\033[34m==>\033[0m Setting PHP %s as default...\n", version)
 
 	if err := linker.SetDefaultVersion(version); err != nil {
@@ -1671,10 +2339,11 @@ func runUse(version string, system bool) error {
 
 	fmt.Printf("\033[32m[OK]\033[0m PHP %s is now the default version\n", version)
 	fmt.Printf("\nSymlinks created in %s:\n", linker.GetPHMBinDir())
-	fmt.Printf("  php      -> /opt/php/%s/bin/php\n", version)
-	fmt.Printf("  php%s   -> /opt/php/%s/bin/php\n", version, version)
-	fmt.Printf("  phpize   -> /opt/php/%s/bin/phpize\n", version)
-	fmt.Printf("  php-fpm  -> /opt/php/%s/sbin/php-fpm\n", version)
+	slotDir := filepath.Join(cfg.InstallPrefix, version)
+	fmt.Printf("  php      -> %s/bin/php\n", slotDir)
+	fmt.Printf("  php%s   -> %s/bin/php\n", version, slotDir)
+	fmt.Printf("  phpize   -> %s/bin/phpize\n", slotDir)
+	fmt.Printf("  php-fpm  -> %s/sbin/php-fpm\n", slotDir)
 
 	// Handle --system flag
 	if system {
@@ -1697,13 +2366,24 @@ func runUse(version string, system bool) error {
 		fmt.Printf("\033[32m[OK]\033[0m System symlinks created in /usr/local/bin\n")
 	} else {
 		fmt.Printf("\n\033[33mNote:\033[0m Add to your shell profile (.zshrc or .bash_profile):\n")
-		fmt.Printf("  export PATH=\"/opt/php/bin:$PATH\"\n")
-		fmt.Printf("\nOr use --system to create symlinks in /usr/local/bin\n")
+		fmt.Printf("  export PATH=\"%s:$PATH\"\n", filepath.Join(cfg.InstallPrefix, "bin"))
+		if linker.GetSystemBinDir() != "" {
+			fmt.Printf("\nOr use --system to create symlinks in /usr/local/bin\n")
+		}
 	}
 
 	return nil
 }
 
+// useOutput is the --output schema of phm use (without a version)
+type useOutput struct {
+	Default      string   `json:"default"`
+	Versions     []string `json:"versions"`
+	BinDir       string   `json:"bin_dir"`
+	SystemBinDir string   `json:"system_bin_dir,omitempty"` // empty in user mode
+	SystemLinked bool     `json:"system_linked"`
+}
+
 func runUseList() error {
 	linker := getLinker()
 
@@ -1711,6 +2391,19 @@ func runUseList() error {
 	available := linker.GetAvailableVersions()
 	systemLinked := linker.IsSystemLinked()
 
+	if structuredOutput() {
+		if available == nil {
+			available = []string{}
+		}
+		return writeOutput(useOutput{
+			Default:      current,
+			Versions:     available,
+			BinDir:       linker.GetPHMBinDir(),
+			SystemBinDir: linker.GetSystemBinDir(),
+			SystemLinked: systemLinked,
+		})
+	}
+
 	fmt.Printf("\n\033[1mPHP Versions\033[0m\n\n")
 
 	if len(available) == 0 {
@@ -1733,7 +2426,9 @@ func runUseList() error {
 
 	fmt.Printf("\n\033[1mPaths:\033[0m\n")
 	fmt.Printf("  PHM bin:    %s\n", linker.GetPHMBinDir())
-	if systemLinked {
+	if linker.GetSystemBinDir() == "" {
+		fmt.Printf("  System:     \033[33m(not available in user mode)\033[0m\n")
+	} else if systemLinked {
 		fmt.Printf("  System:     /usr/local/bin \033[32m(linked)\033[0m\n")
 	} else {
 		fmt.Printf("  System:     /usr/local/bin \033[33m(not linked)\033[0m\n")
@@ -1741,10 +2436,12 @@ func runUseList() error {
 
 	fmt.Printf("\n\033[1mUsage:\033[0m\n")
 	fmt.Printf("  phm use <version>          Switch default version\n")
-	fmt.Printf("  phm use <version> --system Also link to /usr/local/bin\n")
+	if linker.GetSystemBinDir() != "" {
+		fmt.Printf("  phm use <version> --system Also link to /usr/local/bin\n")
+	}
 
 	if !systemLinked && current != "" {
-		fmt.Printf("\n\033[33mTip:\033[0m Add to your PATH: export PATH=\"/opt/php/bin:$PATH\"\n")
+		fmt.Printf("\n\033[33mTip:\033[0m Add to your PATH: export PATH=\"%s:$PATH\"\n", filepath.Join(cfg.InstallPrefix, "bin"))
 	}
 
 	return nil
@@ -1752,13 +2449,22 @@ func runUseList() error {
 
 // getFpmManager returns an FPM manager instance
 func getFpmManager() *pkg.FPMManager {
-	return pkg.NewFPMManager(cfg.InstallPrefix)
+	fpm := pkg.NewFPMManager(cfg.InstallPrefix, getLayout())
+	fpm.SetFS(getFS())
+	return fpm
 }
 
 func runFpmStatus() error {
 	fpm := getFpmManager()
 	statuses := fpm.GetAllStatus()
 
+	if structuredOutput() {
+		if statuses == nil {
+			statuses = []*pkg.FPMStatus{}
+		}
+		return writeOutput(statuses)
+	}
+
 	fmt.Printf("\n\033[1mPHP-FPM Status\033[0m\n\n")
 
 	if len(statuses) == 0 {
@@ -1902,7 +2608,9 @@ Examples:
 
 // getExtManager returns an extension manager instance
 func getExtManager() *pkg.ExtensionManager {
-	return pkg.NewExtensionManager(cfg.InstallPrefix)
+	extMgr := pkg.NewExtensionManager(cfg.InstallPrefix)
+	extMgr.SetFS(getFS())
+	return extMgr
 }
 
 func runExt(action, extension, sapi, version string) error {
@@ -1943,12 +2651,25 @@ func runExt(action, extension, sapi, version string) error {
 	}
 }
 
+// extListOutput is the --output schema of phm ext list
+type extListOutput struct {
+	Version    string                `json:"version"`
+	Extensions []pkg.ExtensionStatus `json:"extensions"`
+}
+
 func runExtList(extMgr *pkg.ExtensionManager, version string) error {
 	extensions, err := extMgr.ListExtensions(version)
 	if err != nil {
 		return err
 	}
 
+	if structuredOutput() {
+		if extensions == nil {
+			extensions = []pkg.ExtensionStatus{}
+		}
+		return writeOutput(extListOutput{Version: version, Extensions: extensions})
+	}
+
 	fmt.Printf("\n\033[1mPHP %s Extensions\033[0m\n\n", version)
 
 	if len(extensions) == 0 {
@@ -1975,6 +2696,14 @@ func runExtList(extMgr *pkg.ExtensionManager, version string) error {
 }
 
 func runExtEnable(extMgr *pkg.ExtensionManager, version, extension, sapi string) error {
+	if cfg.DryRun {
+		if err := extMgr.Enable(version, extension, sapi); err != nil {
+			return err
+		}
+		printRecordedDryRun(version)
+		return nil
+	}
+
 	fmt.Printf("\033[34m==>\033[0m Enabling %s (PHP %s)...\n", extension, version)
 
 	if err := extMgr.Enable(version, extension, sapi); err != nil {
@@ -1988,6 +2717,14 @@ func runExtEnable(extMgr *pkg.ExtensionManager, version, extension, sapi string)
 }
 
 func runExtDisable(extMgr *pkg.ExtensionManager, version, extension, sapi string) error {
+	if cfg.DryRun {
+		if err := extMgr.Disable(version, extension, sapi); err != nil {
+			return err
+		}
+		printRecordedDryRun(version)
+		return nil
+	}
+
 	fmt.Printf("\033[34m==>\033[0m Disabling %s (PHP %s)...\n", extension, version)
 
 	if err := extMgr.Disable(version, extension, sapi); err != nil {
@@ -2012,7 +2749,7 @@ This command will:
   - Stop all PHP-FPM services
   - Remove all PHP installations from /opt/php/
   - Remove PHM symlinks from /opt/php/bin and /usr/local/bin
-  - Remove LaunchDaemons for PHP-FPM
+  - Remove LaunchDaemons (LaunchAgents in user mode) for PHP-FPM
   - Remove cache (~/.cache/phm)
   - Remove installed packages database (~/.local/share/phm)
   - Remove configuration (~/.config/phm)
@@ -2032,7 +2769,9 @@ Use --force to skip confirmation.`,
 }
 
 func runDestruct(force bool) error {
-	homeDir, _ := os.UserHomeDir()
+	fpm := getFpmManager()
+	linker := getLinker()
+	fs := getFS()
 
 	// Paths to clean
 	paths := struct {
@@ -2046,13 +2785,13 @@ func runDestruct(force bool) error {
 		fpmLogPattern string
 	}{
 		installPrefix: cfg.InstallPrefix,
-		phmBinDir:     "/opt/php/bin",
-		cacheDir:      filepath.Join(homeDir, ".cache", "phm"),
-		dataDir:       filepath.Join(homeDir, ".local", "share", "phm"),
-		configDir:     filepath.Join(homeDir, ".config", "phm"),
-		launchDaemons: "/Library/LaunchDaemons",
-		fpmRunDir:     "/var/run/php",
-		fpmLogPattern: "/var/log/php*-fpm*",
+		phmBinDir:     linker.GetPHMBinDir(),
+		cacheDir:      cfg.CacheDir,
+		dataDir:       cfg.DataDir,
+		configDir:     cfg.ConfigDir,
+		launchDaemons: fpm.GetLaunchDir(),
+		fpmRunDir:     fpm.GetRunDir(),
+		fpmLogPattern: filepath.Join(fpm.GetLogDir(), "php*-fpm*"),
 	}
 
 	fmt.Printf("\n\033[1;31m⚠️  WARNING: This will completely remove all PHM-managed PHP installations!\033[0m\n\n")
@@ -2062,12 +2801,12 @@ func runDestruct(force bool) error {
 	fmt.Printf("  • Cache:                %s\n", paths.cacheDir)
 	fmt.Printf("  • Data:                 %s\n", paths.dataDir)
 	fmt.Printf("  • Config:               %s\n", paths.configDir)
-	fmt.Printf("  • LaunchDaemons:        %s/com.phm.php*\n", paths.launchDaemons)
+	fmt.Printf("  • FPM services:         %s/com.phm.php*\n", paths.launchDaemons)
 	fmt.Printf("  • FPM sockets:          %s\n", paths.fpmRunDir)
 	fmt.Printf("  • FPM logs:             %s\n", paths.fpmLogPattern)
 	fmt.Println()
 
-	if !force {
+	if !force && !cfg.DryRun {
 		fmt.Print("\033[1mType 'yes' to confirm: \033[0m")
 		var confirm string
 		_, _ = fmt.Scanln(&confirm)
@@ -2082,53 +2821,62 @@ func runDestruct(force bool) error {
 		return err
 	}
 
-	fmt.Println()
+	// Data directories are the user's own; a dry run only records every change
+	progress := fmt.Printf
+	var userFS privfs.FS = privfs.Direct{}
+	if cfg.DryRun {
+		progress = func(string, ...any) (int, error) { return 0, nil }
+		userFS = fs
+	} else {
+		fmt.Println()
+	}
 
 	// 1. Stop all PHP-FPM services
-	fmt.Printf("\033[34m==>\033[0m Stopping PHP-FPM services...\n")
-	fpm := getFpmManager()
+	progress("\033[34m==>\033[0m Stopping PHP-FPM services...\n")
 	statuses := fpm.GetAllStatus()
 	for _, s := range statuses {
 		if s.Running {
-			fmt.Printf("    Stopping PHP-FPM %s...\n", s.Version)
+			progress("    Stopping PHP-FPM %s...\n", s.Version)
 			_ = fpm.Stop(s.Version)
 		}
 		if s.Enabled {
-			fmt.Printf("    Disabling PHP-FPM %s...\n", s.Version)
+			progress("    Disabling PHP-FPM %s...\n", s.Version)
 			_ = fpm.Disable(s.Version)
 		}
 	}
 
 	// 2. Remove LaunchDaemons
-	fmt.Printf("\033[34m==>\033[0m Removing LaunchDaemons...\n")
+	progress("\033[34m==>\033[0m Removing FPM services...\n")
 	launchDaemonFiles, _ := filepath.Glob(filepath.Join(paths.launchDaemons, "com.phm.php*.plist"))
 	for _, f := range launchDaemonFiles {
-		fmt.Printf("    Removing %s\n", f)
-		_ = runSudo("rm", "-f", f)
-	}
-
-	// 3. Remove symlinks from /usr/local/bin (only phm-created ones)
-	fmt.Printf("\033[34m==>\033[0m Removing symlinks from /usr/local/bin...\n")
-	phpBinaries := []string{"php", "phpize", "php-config", "php-cgi", "php-fpm", "pecl", "pear", "phpdbg"}
-	for _, bin := range phpBinaries {
-		symlink := filepath.Join("/usr/local/bin", bin)
-		if target, err := os.Readlink(symlink); err == nil {
-			if strings.Contains(target, "/opt/php") {
-				fmt.Printf("    Removing %s -> %s\n", symlink, target)
-				_ = runSudo("rm", "-f", symlink)
+		progress("    Removing %s\n", f)
+		removePath(fs, f)
+	}
+
+	// 3. Remove symlinks from /usr/local/bin (only phm-created ones, none in user mode)
+	if systemBinDir := linker.GetSystemBinDir(); systemBinDir != "" {
+		progress("\033[34m==>\033[0m Removing symlinks from %s...\n", systemBinDir)
+		phpBinaries := []string{"php", "phpize", "php-config", "php-cgi", "php-fpm", "pecl", "pear", "phpdbg"}
+		for _, bin := range phpBinaries {
+			symlink := filepath.Join(systemBinDir, bin)
+			if target, err := linker.ReadLink(symlink); err == nil {
+				if strings.Contains(target, cfg.InstallPrefix) {
+					progress("    Removing %s -> %s\n", symlink, target)
+					removePath(fs, symlink)
+				}
 			}
 		}
 	}
 
 	// 4. Remove /opt/php/bin (PHM symlink directory)
-	fmt.Printf("\033[34m==>\033[0m Removing PHM bin directory...\n")
+	progress("\033[34m==>\033[0m Removing PHM bin directory...\n")
 	if _, err := os.Stat(paths.phmBinDir); err == nil {
-		fmt.Printf("    Removing %s\n", paths.phmBinDir)
-		_ = runSudo("rm", "-rf", paths.phmBinDir)
+		progress("    Removing %s\n", paths.phmBinDir)
+		removeAll(fs, paths.phmBinDir)
 	}
 
 	// 5. Remove all PHP installations
-	fmt.Printf("\033[34m==>\033[0m Removing PHP installations...\n")
+	progress("\033[34m==>\033[0m Removing PHP installations...\n")
 	phpDirs, _ := filepath.Glob(filepath.Join(paths.installPrefix, "*"))
 	versionPattern := regexp.MustCompile(`^\d+\.\d+$`)
 	for _, dir := range phpDirs {
@@ -2138,42 +2886,47 @@ func runDestruct(force bool) error {
 		// Only remove version directories (8.3, 8.4, 8.5, etc.)
 		base := filepath.Base(dir)
 		if versionPattern.MatchString(base) {
-			fmt.Printf("    Removing %s\n", dir)
-			_ = runSudo("rm", "-rf", dir)
+			progress("    Removing %s\n", dir)
+			removeAll(fs, dir)
 		}
 	}
 
 	// 6. Remove FPM run directory
-	fmt.Printf("\033[34m==>\033[0m Removing FPM sockets...\n")
+	progress("\033[34m==>\033[0m Removing FPM sockets...\n")
 	if _, err := os.Stat(paths.fpmRunDir); err == nil {
-		fmt.Printf("    Removing %s\n", paths.fpmRunDir)
-		_ = runSudo("rm", "-rf", paths.fpmRunDir)
+		progress("    Removing %s\n", paths.fpmRunDir)
+		removeAll(fs, paths.fpmRunDir)
 	}
 
 	// 7. Remove FPM logs
-	fmt.Printf("\033[34m==>\033[0m Removing FPM logs...\n")
+	progress("\033[34m==>\033[0m Removing FPM logs...\n")
 	fpmLogs, _ := filepath.Glob(paths.fpmLogPattern)
 	for _, f := range fpmLogs {
-		fmt.Printf("    Removing %s\n", f)
-		_ = runSudo("rm", "-f", f)
+		progress("    Removing %s\n", f)
+		removePath(fs, f)
 	}
 
 	// 8. Remove user directories (no sudo needed)
-	fmt.Printf("\033[34m==>\033[0m Removing PHM data directories...\n")
+	progress("\033[34m==>\033[0m Removing PHM data directories...\n")
 
 	if _, err := os.Stat(paths.cacheDir); err == nil {
-		fmt.Printf("    Removing %s\n", paths.cacheDir)
-		os.RemoveAll(paths.cacheDir)
+		progress("    Removing %s\n", paths.cacheDir)
+		_ = userFS.RemoveAll(paths.cacheDir)
 	}
 
 	if _, err := os.Stat(paths.dataDir); err == nil {
-		fmt.Printf("    Removing %s\n", paths.dataDir)
-		os.RemoveAll(paths.dataDir)
+		progress("    Removing %s\n", paths.dataDir)
+		_ = userFS.RemoveAll(paths.dataDir)
 	}
 
 	if _, err := os.Stat(paths.configDir); err == nil {
-		fmt.Printf("    Removing %s\n", paths.configDir)
-		os.RemoveAll(paths.configDir)
+		progress("    Removing %s\n", paths.configDir)
+		_ = userFS.RemoveAll(paths.configDir)
+	}
+
+	if cfg.DryRun {
+		printRecordedDryRun("")
+		return nil
 	}
 
 	fmt.Println()
@@ -2189,13 +2942,18 @@ func runDestruct(force bool) error {
 	return nil
 }
 
-// runSudo executes a command with sudo
-func runSudo(command string, args ...string) error {
-	allArgs := append([]string{command}, args...)
-	cmd := exec.Command("sudo", allArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// removePath removes a file for destruct, warning when that fails
+func removePath(fs privfs.FS, path string) {
+	if err := fs.Remove(path); err != nil {
+		fmt.Printf("\033[33mWarning:\033[0m %v\n", err)
+	}
+}
+
+// removeAll removes a directory tree for destruct, warning when that fails
+func removeAll(fs privfs.FS, path string) {
+	if err := fs.RemoveAll(path); err != nil {
+		fmt.Printf("\033[33mWarning:\033[0m %v\n", err)
+	}
 }
 
 func newSelfUpdateCmd() *cobra.Command {
@@ -2356,17 +3114,15 @@ func runSelfUpdate(force bool) error {
 
 	// Atomic binary replacement: stage to temp file in same directory, then rename
 	tmpBinary := currentBinary + ".new"
-	_ = exec.Command("sudo", "rm", "-f", tmpBinary).Run()
+	fs := getFS()
+	_ = fs.Remove(tmpBinary)
 
-	if err := runSudo("cp", binaryPath, tmpBinary); err != nil {
+	if err := fs.CopyFile(binaryPath, tmpBinary, 0755); err != nil {
+		_ = fs.Remove(tmpBinary)
 		return fmt.Errorf("failed to stage binary: %w", err)
 	}
-	if err := runSudo("chmod", "0755", tmpBinary); err != nil {
-		_ = exec.Command("sudo", "rm", "-f", tmpBinary).Run()
-		return fmt.Errorf("failed to set permissions: %w", err)
-	}
-	if err := runSudo("mv", tmpBinary, currentBinary); err != nil {
-		_ = exec.Command("sudo", "rm", "-f", tmpBinary).Run()
+	if err := fs.Rename(tmpBinary, currentBinary); err != nil {
+		_ = fs.Remove(tmpBinary)
 		return fmt.Errorf("failed to install binary: %w", err)
 	}
 
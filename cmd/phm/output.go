@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Output formats of --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// structuredOutput reports whether results are printed as JSON or YAML
+// instead of text (--output). Progress messages then go to stderr.
+func structuredOutput() bool {
+	return cfg.Output == outputJSON || cfg.Output == outputYAML
+}
+
+// progressOut is where progress messages go, so stdout stays parseable
+func progressOut() io.Writer {
+	if structuredOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// writeOutput prints a result in the --output format (JSON unless YAML)
+func writeOutput(v any) error {
+	if cfg.Output == outputYAML {
+		return writeYAML(os.Stdout, v)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// yamlField is a key of a YAML mapping, in the order of the JSON encoding
+type yamlField struct {
+	key   string
+	value any
+}
+
+// writeYAML prints v as YAML. The value is encoded as JSON first, so JSON
+// field names, omitempty and MarshalText apply, and keys keep their order.
+func writeYAML(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := decodeOrdered(dec)
+	if err != nil {
+		return err
+	}
+
+	// Scalars and empty collections are written on one line
+	var b strings.Builder
+	emitYAML(&b, node, 0)
+	if b.Len() == 0 {
+		b.WriteString(yamlScalar(node) + "\n")
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// decodeOrdered decodes the next JSON value, keeping the order of object keys
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+
+	switch delim {
+	case '{':
+		fields := []yamlField{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, yamlField{key: fmt.Sprint(key), value: value})
+		}
+		_, err = dec.Token()
+		return fields, err
+	case '[':
+		items := []any{}
+		for dec.More() {
+			item, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err = dec.Token()
+		return items, err
+	}
+	return nil, fmt.Errorf("unexpected JSON delimiter %v", delim)
+}
+
+// emitYAML writes a mapping or sequence in block style (nothing for scalars)
+func emitYAML(b *strings.Builder, node any, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch n := node.(type) {
+	case []yamlField:
+		for _, f := range n {
+			b.WriteString(pad + yamlScalar(f.key) + ":")
+			emitValue(b, f.value, indent+2, false)
+		}
+	case []any:
+		for _, item := range n {
+			b.WriteString(pad + "-")
+			emitValue(b, item, indent+2, true)
+		}
+	}
+}
+
+// emitValue writes the value of a mapping key or sequence item. Nested
+// mappings in sequences start on the line of the dash.
+func emitValue(b *strings.Builder, value any, indent int, inSequence bool) {
+	if emptyNode(value) {
+		b.WriteString(" " + yamlScalar(value) + "\n")
+		return
+	}
+	switch value.(type) {
+	case []yamlField, []any:
+		if !inSequence {
+			b.WriteString("\n")
+			emitYAML(b, value, indent)
+			return
+		}
+		var nested strings.Builder
+		emitYAML(&nested, value, indent)
+		b.WriteString(" " + strings.TrimPrefix(nested.String(), strings.Repeat(" ", indent)))
+	default:
+		b.WriteString(" " + yamlScalar(value) + "\n")
+	}
+}
+
+// emptyNode reports whether node is an empty mapping or sequence
+func emptyNode(node any) bool {
+	switch n := node.(type) {
+	case []yamlField:
+		return len(n) == 0
+	case []any:
+		return len(n) == 0
+	}
+	return false
+}
+
+// yamlPlain matches strings that need no quotes in YAML
+var yamlPlain = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./+-]*$`)
+
+// yamlScalar formats a JSON scalar (or empty collection) as YAML. Strings that
+// could be read as another type (8.5, true, null) are quoted.
+func yamlScalar(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return fmt.Sprint(v)
+	case json.Number:
+		return v.String()
+	case []yamlField:
+		return "{}"
+	case []any:
+		return "[]"
+	case string:
+		switch strings.ToLower(v) {
+		case "true", "false", "yes", "no", "on", "off", "null", "y", "n":
+		default:
+			if yamlPlain.MatchString(v) {
+				return v
+			}
+		}
+		quoted, _ := json.Marshal(v)
+		return string(quoted)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/phm-dev/phm/internal/tools"
+	"github.com/spf13/cobra"
+)
+
+func newOwnsCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "owns <paths...>",
+		Short: "Show which package installed a file",
+		Long: `Show which installed package or tool a file belongs to.
+
+Symlinks created by PHM (like /opt/php/bin/php or /usr/local/bin/php) are
+resolved to the file they point to. Exits with an error if a path is not owned
+by any package.
+
+Examples:
+  phm owns /opt/php/8.5/lib/php/extensions/no-debug-non-zts-20250925/redis.so
+  phm owns /opt/php/bin/php
+  phm owns --json /opt/php/8.5/etc/php.ini`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runOwns(args, jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print results as JSON")
+	return cmd
+}
+
+func newFilesCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "files <package>",
+		Short: "List files installed by a package",
+		Long: `List all files installed by a package or tool.
+
+With --json, the SHA256, size and mode recorded at install time are included
+(packages installed by older PHM versions only have paths).
+
+Examples:
+  phm files php8.5-redis
+  phm files composer
+  phm files --json php8.5-cli`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFiles(args[0], jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print results as JSON")
+	return cmd
+}
+
+// fileOwnership is the result of an ownership query for one path
+type fileOwnership struct {
+	Path     string   `json:"path"`
+	Resolved string   `json:"resolved,omitempty"` // file a symlink points to
+	Owners   []string `json:"owners"`
+}
+
+func runOwns(paths []string, jsonOutput bool) error {
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+	toolsMgr := getToolsManager()
+	_ = toolsMgr.LoadInstalled()
+	linker := getLinker()
+
+	// Owners of a path: PHP packages first, then tools
+	lookup := func(path string) []string {
+		owners := mgr.Owners(path)
+		for _, t := range toolsMgr.GetAllInstalled() {
+			for _, f := range t.InstalledFiles {
+				if filepath.Clean(f) == path {
+					owners = append(owners, t.Name)
+					break
+				}
+			}
+		}
+		return owners
+	}
+
+	var results []fileOwnership
+	unowned := 0
+	for _, arg := range paths {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return err
+		}
+
+		result := fileOwnership{Path: path, Owners: lookup(path)}
+		if len(result.Owners) == 0 {
+			if target := linker.ResolveLink(path); target != "" {
+				result.Resolved = target
+				result.Owners = lookup(target)
+			}
+		}
+		if result.Owners == nil {
+			result.Owners = []string{}
+			unowned++
+		}
+		results = append(results, result)
+	}
+
+	if jsonOutput || structuredOutput() {
+		if err := writeOutput(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			path := r.Path
+			if r.Resolved != "" {
+				path = fmt.Sprintf("%s -> %s", r.Path, r.Resolved)
+			}
+			if len(r.Owners) == 0 {
+				fmt.Printf("\033[33mnot owned\033[0m: %s\n", path)
+				continue
+			}
+			for _, owner := range r.Owners {
+				fmt.Printf("%s: %s\n", owner, path)
+			}
+		}
+	}
+
+	if unowned > 0 {
+		return fmt.Errorf("%d path(s) not owned by any package", unowned)
+	}
+	return nil
+}
+
+func runFiles(name string, jsonOutput bool) error {
+	var files []pkg.InstalledFile
+
+	if tools.GetTool(name) != nil {
+		toolsMgr := getToolsManager()
+		_ = toolsMgr.LoadInstalled()
+		installed := toolsMgr.GetInstalled(name)
+		if installed == nil {
+			return fmt.Errorf("tool not installed: %s", name)
+		}
+		for _, f := range installed.InstalledFiles {
+			files = append(files, pkg.InstalledFile{Path: f})
+		}
+	} else {
+		mgr := getManager()
+		if err := mgr.LoadInstalled(); err != nil {
+			return fmt.Errorf("could not load installed packages: %w", err)
+		}
+		installed := mgr.GetInstalled(name)
+		if installed == nil {
+			return fmt.Errorf("package not installed: %s", name)
+		}
+		files = installed.Files
+		if len(files) == 0 {
+			for _, f := range installed.InstalledFiles {
+				files = append(files, pkg.InstalledFile{Path: f})
+			}
+		}
+	}
+
+	if jsonOutput || structuredOutput() {
+		if files == nil {
+			files = []pkg.InstalledFile{}
+		}
+		return writeOutput(files)
+	}
+
+	for _, f := range files {
+		fmt.Println(f.Path)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+func newPackCmd() *cobra.Command {
+	var info pkg.Package
+	var output, scripts string
+
+	cmd := &cobra.Command{
+		Use:   "pack <stagedir>",
+		Short: "Create a package from a staged directory",
+		Long: `Create a name_version-rev_platform.tar.zst package from a staged directory.
+
+The staged directory mirrors the install locations, like a DESTDIR:
+  stage/opt/php/8.5/lib/php/extensions/foo.so
+  stage/opt/php/8.5/etc/conf.d/foo.ini
+
+Lifecycle scripts (preinst, postinst, prerm, postrm) are taken from the
+directory given with --scripts. They run with /bin/sh and get PHM_ACTION,
+PHM_PACKAGE, PHM_VERSION, PHM_OLD_VERSION, PHM_SLOT, PHM_PREFIX and
+PHM_SLOT_DIR in their environment.
+
+The package can be dropped into a --repo directory (run: phm repo build <dir>).
+
+Examples:
+  phm pack --name php8.5-foo --version 1.2.0 --php-version 8.5.0 \
+      --depends "php8.5-common (>= 8.5.0)" ./stage
+  phm pack --name php8.5-foo --version 1.2.0 --revision 2 -o ./dist ./stage
+  phm pack --name php8.5-foo --version 1.2.0 --scripts ./scripts ./stage`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPack(info, args[0], scripts, output)
+		},
+	}
+
+	cmd.Flags().StringVar(&info.Name, "name", "", "Package name (required)")
+	cmd.Flags().StringVar(&info.Version, "version", "", "Package version (required)")
+	cmd.Flags().IntVar(&info.Revision, "revision", 1, "Package revision")
+	cmd.Flags().StringVar(&info.PHPVersion, "php-version", "", "PHP version the package is built for (extensions)")
+	cmd.Flags().StringVar(&info.Description, "description", "", "Package description")
+	cmd.Flags().StringVar(&info.Platform, "platform", cfg.Platform(), "Target platform")
+	cmd.Flags().StringVar(&info.Maintainer, "maintainer", "", "Package maintainer")
+	cmd.Flags().StringArrayVar(&info.Depends, "depends", nil, "Dependency, e.g. \"php8.5-common (>= 8.5.0)\" (repeatable)")
+	cmd.Flags().StringArrayVar(&info.Conflicts, "conflicts", nil, "Conflicting package (repeatable)")
+	cmd.Flags().StringArrayVar(&info.Replaces, "replaces", nil, "Package whose files this package may take over (repeatable)")
+	cmd.Flags().StringArrayVar(&info.Provides, "provides", nil, "Provided virtual package (repeatable)")
+	cmd.Flags().StringVar(&scripts, "scripts", "", "Directory with lifecycle scripts (preinst, postinst, prerm, postrm)")
+	cmd.Flags().StringVarP(&output, "output", "o", ".", "Output directory")
+	_ = cmd.MarkFlagRequired("name")
+	_ = cmd.MarkFlagRequired("version")
+
+	return cmd
+}
+
+func runPack(info pkg.Package, stageDir, scriptsDir, output string) error {
+	fmt.Printf("\033[34m==>\033[0m Packing %s %s-%d (%s)...\n", info.Name, info.Version, info.Revision, info.Platform)
+
+	// User mode installs packages built for the standard prefix under the user prefix
+	prefix := cfg.UnrootPath(cfg.InstallPrefix)
+	if cfg.UserMode {
+		prefix = pkg.PackagePrefix
+	}
+	result, err := pkg.Pack(info, stageDir, scriptsDir, output, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range result.Warnings {
+		fmt.Printf("\033[33mWarning:\033[0m %s\n", w)
+	}
+
+	fmt.Printf("\033[32m[OK]\033[0m Created %s (%d files)\n", result.Path, result.Files)
+	return nil
+}
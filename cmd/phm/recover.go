@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+func newRecoverCmd() *cobra.Command {
+	var rollback, rollForward bool
+
+	cmd := &cobra.Command{
+		Use:   "recover",
+		Short: "Finish or undo an interrupted install, upgrade or remove",
+		Long: `Recover from an interrupted transaction.
+
+Install, upgrade and remove record every change in a journal before making
+it. If PHM is interrupted (crash, power loss, Ctrl-C), the journal is left
+behind and other commands refuse to run until it is resolved:
+
+  --rollback      restore every file and database entry to the state before
+                  the transaction started
+  --roll-forward  complete the remaining steps using the downloaded packages
+
+Without a flag, the transaction is shown and you are asked what to do.
+
+Examples:
+  phm recover
+  phm recover --rollback`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRecover(rollback, rollForward)
+		},
+	}
+
+	cmd.Flags().BoolVar(&rollback, "rollback", false, "Undo the interrupted transaction")
+	cmd.Flags().BoolVar(&rollForward, "roll-forward", false, "Complete the interrupted transaction")
+	cmd.MarkFlagsMutuallyExclusive("rollback", "roll-forward")
+
+	return cmd
+}
+
+// checkPendingTransaction refuses to start when an interrupted transaction must be recovered first
+func checkPendingTransaction(mgr *pkg.Manager) error {
+	info, err := mgr.PendingTransaction()
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return nil
+	}
+	fmt.Printf("\033[31mError:\033[0m An interrupted %s was found (started %s)\n", info.Operation, info.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Run 'phm recover' to roll it back or complete it.\n")
+	return fmt.Errorf("interrupted transaction must be recovered first")
+}
+
+func runRecover(rollback, rollForward bool) error {
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+
+	info, err := mgr.PendingTransaction()
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		fmt.Println("\033[32m[OK]\033[0m No interrupted transaction")
+		return nil
+	}
+
+	// Restoring files may need root privileges
+	if err := ensureSudo(); err != nil {
+		return err
+	}
+
+	release, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	fmt.Printf("\n\033[1mInterrupted %s\033[0m (started %s)\n\n", info.Operation, info.StartedAt.Format("2006-01-02 15:04:05"))
+	for _, a := range info.Actions {
+		status := "\033[33mpending\033[0m"
+		if a.Done {
+			status = "\033[32mdone\033[0m"
+		}
+		fmt.Printf("  %s %s: %s\n", a.Kind, a.Name, status)
+	}
+	fmt.Printf("\n%d of %d step(s) done, %d file(s) and database entries changed.\n\n", len(info.Actions)-info.Pending(), len(info.Actions), info.Changes)
+
+	if !rollback && !rollForward {
+		fmt.Printf("Roll back (b) or roll forward (f)? [b/f/N]: ")
+		var answer string
+		_, _ = fmt.Scanln(&answer)
+		switch answer {
+		case "b", "B":
+			rollback = true
+		case "f", "F":
+			rollForward = true
+		default:
+			fmt.Println("Nothing changed.")
+			return nil
+		}
+	}
+
+	tx, err := mgr.ResumeTransaction()
+	if err != nil {
+		return err
+	}
+
+	if rollback {
+		fmt.Printf("\033[34m==>\033[0m Rolling back...\n")
+		if err := tx.Rollback(); err != nil {
+			return fmt.Errorf("rollback incomplete: %w", err)
+		}
+		fmt.Printf("\033[32m[OK]\033[0m Rolled back %d change(s)\n", info.Changes)
+		return nil
+	}
+
+	fmt.Printf("\033[34m==>\033[0m Completing %d remaining step(s)...\n", info.Pending())
+	if err := tx.RollForward(); err != nil {
+		return fmt.Errorf("roll forward failed: %w", err)
+	}
+	fmt.Printf("\033[32m[OK]\033[0m Transaction completed\n")
+	return nil
+}
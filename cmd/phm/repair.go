@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+func newRepairCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repair <packages...>",
+		Short: "Restore missing or damaged files of installed packages",
+		Long: `Restore missing or damaged files from the package tarball.
+
+Every file of the installed build is compared with the tarball in the package
+cache (downloaded again if it was evicted). Only files that are missing or
+differ are extracted again; intact files are left untouched and other packages
+of the same PHP version are not reinstalled. Config files that exist are kept,
+even when they were edited.
+
+Examples:
+  phm verify                 # find damaged packages
+  phm repair php8.5-cli`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRepair(args)
+		},
+	}
+	return cmd
+}
+
+// canonicalPackageName returns the index name of an installed package
+// (php8.5.1-cli and old-style php8.5.0-cli map to php8.5-cli)
+func canonicalPackageName(name string) string {
+	name = normalizePackageName(name)
+	if info := pkg.ParsePackageName(name); info != nil && info.IsPinned {
+		return info.GetCanonicalName()
+	}
+	return name
+}
+
+func runRepair(packages []string) error {
+	if err := ensureSudo(); err != nil {
+		return err
+	}
+
+	release, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+	if err := checkPendingTransaction(mgr); err != nil {
+		return err
+	}
+
+	r, err := getRepo()
+	if err != nil {
+		return err
+	}
+
+	// Fetch the tarball of each installed build
+	var actions []pkg.TransactionAction
+	for _, name := range packages {
+		installed := mgr.GetInstalled(name)
+		if installed == nil {
+			return fmt.Errorf("package not installed: %s", name)
+		}
+
+		build := r.FindBuild(canonicalPackageName(name), installed.Version, installed.Revision, installed.Repo)
+		if build == nil {
+			return fmt.Errorf("%s %s-%d is no longer available in any repository; reinstall it instead", name, installed.Version, installed.Revision)
+		}
+
+		path, err := r.DownloadPackage(build)
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", name, err)
+		}
+		actions = append(actions, pkg.TransactionAction{Kind: pkg.ActionRepair, Name: name, Path: path})
+	}
+
+	tx, err := mgr.Begin("repair", actions)
+	if err != nil {
+		return err
+	}
+
+	for i, a := range actions {
+		fmt.Printf("\033[34m==>\033[0m Repairing %s...\n", a.Name)
+		result, err := tx.Repair(i)
+		if err != nil {
+			fmt.Printf("\033[31mError:\033[0m Failed to repair %s: %v\n", a.Name, err)
+			if rbErr := tx.Rollback(); rbErr != nil {
+				return fmt.Errorf("repair failed and could not be rolled back: %w (run: phm recover)", rbErr)
+			}
+			return fmt.Errorf("repair failed, changes rolled back")
+		}
+
+		for _, f := range result.Restored {
+			fmt.Printf("  restored  %s\n", f)
+		}
+		for _, f := range result.Modes {
+			fmt.Printf("  mode      %s\n", f)
+		}
+		for _, f := range result.Kept {
+			fmt.Printf("  \033[33mkept\033[0m      %s (locally modified config)\n", f)
+		}
+		if len(result.Restored)+len(result.Modes) == 0 {
+			fmt.Printf("\033[32m[OK]\033[0m %s: nothing to repair\n", a.Name)
+		} else {
+			fmt.Printf("\033[32m[OK]\033[0m %s: %d file(s) restored, %d mode(s) reset\n", a.Name, len(result.Restored), len(result.Modes))
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/phm-dev/phm/internal/minisign"
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/phm-dev/phm/internal/repo"
+	"github.com/spf13/cobra"
+)
+
+func newRepoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repo <command>",
+		Short: "Build, sign and verify package repositories",
+		Long: `Tools for maintaining a package repository (a directory of .tar.zst
+packages plus index.json) that can be used with --repo, PHM_REPO_URL
+or sources.d.
+
+Examples:
+  phm repo keygen -o ~/phm-signing          # Create phm-signing.key / .pub
+  phm repo build ./dist --key signing.key   # Generate and sign index.json
+  phm repo sign ./dist --key signing.key    # Re-sign an existing index
+  phm repo verify ./dist                    # Lint index and check tarballs`,
+	}
+
+	cmd.AddCommand(
+		newRepoBuildCmd(),
+		newRepoSignCmd(),
+		newRepoVerifyCmd(),
+		newRepoKeygenCmd(),
+	)
+
+	return cmd
+}
+
+func newRepoBuildCmd() *cobra.Command {
+	var keyFile string
+	var signPackages bool
+
+	cmd := &cobra.Command{
+		Use:   "build <dir>",
+		Short: "Generate index.json from the packages in a directory",
+		Long: `Scan <dir> for name_version-rev_platform.tar.zst packages, validate their
+pkginfo.json, compute SHA256 and size, and write <dir>/index.json.
+
+With --key the index is signed (index.json.minisig); add --sign-packages
+to also write a .minisig next to every package.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRepoBuild(args[0], keyFile, signPackages)
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyFile, "key", "k", "", "Secret key file used to sign the index")
+	cmd.Flags().BoolVar(&signPackages, "sign-packages", false, "Also sign every package tarball (requires --key)")
+
+	return cmd
+}
+
+func newRepoSignCmd() *cobra.Command {
+	var keyFile string
+	var signPackages bool
+
+	cmd := &cobra.Command{
+		Use:   "sign <dir>",
+		Short: "Sign index.json (and optionally packages) in a repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keyFile == "" {
+				return fmt.Errorf("--key is required")
+			}
+			return runRepoSign(args[0], keyFile, signPackages)
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyFile, "key", "k", "", "Secret key file")
+	cmd.Flags().BoolVar(&signPackages, "sign-packages", false, "Also sign every package tarball")
+
+	return cmd
+}
+
+func newRepoVerifyCmd() *cobra.Command {
+	var keys []string
+	var externalDeps bool
+
+	cmd := &cobra.Command{
+		Use:   "verify <dir>",
+		Short: "Check index.json, package checksums and signatures",
+		Long: `Lint <dir>/index.json: duplicate versions, dependencies that no package
+satisfies, conflicts naming unknown packages, and tarballs that are missing
+or don't match the recorded size and SHA256.
+
+Signatures are checked against --pubkey, or PHM_TRUSTED_KEYS if none is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRepoVerify(args[0], keys, externalDeps)
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&keys, "pubkey", "p", nil, "Public key or .pub file to verify signatures with (repeatable)")
+	cmd.Flags().BoolVar(&externalDeps, "external-deps", false, "Treat missing dependencies as warnings (repository layered on another one)")
+
+	return cmd
+}
+
+func newRepoKeygenCmd() *cobra.Command {
+	var output string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a signing key pair",
+		Long: `Generate an ed25519 key pair: <output>.key (secret, keep it private) and
+<output>.pub (minisign public key, add it to PHM_TRUSTED_KEYS or
+~/.config/phm/trusted-keys/ on clients).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRepoKeygen(output, force)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "phm", "Output path without extension")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite existing key files")
+
+	return cmd
+}
+
+func runRepoBuild(dir, keyFile string, signPackages bool) error {
+	if signPackages && keyFile == "" {
+		return fmt.Errorf("--sign-packages requires --key")
+	}
+
+	fmt.Printf("\033[34m==>\033[0m Scanning %s...\n", dir)
+	index, err := repo.BuildIndex(dir)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for name, platform := range index.Platforms {
+		fmt.Printf("    %s: %d packages\n", name, len(platform.Packages))
+		count += len(platform.Packages)
+	}
+
+	// Missing dependencies are expected in repositories layered on top of main
+	indexPath := filepath.Join(dir, "index.json")
+	errors := 0
+	for _, p := range repo.LintIndex(index, repo.LintOptions{ExternalDeps: true}) {
+		if p.Fatal {
+			errors++
+			fmt.Printf("\033[31mError:\033[0m %s\n", p)
+		} else {
+			fmt.Printf("\033[33mWarning:\033[0m %s\n", p)
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("repository has %d error(s), %s was not written", errors, indexPath)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(indexPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	fmt.Printf("\033[32m[OK]\033[0m Wrote %s (%d packages)\n", indexPath, count)
+
+	if keyFile != "" {
+		return runRepoSign(dir, keyFile, signPackages)
+	}
+	return nil
+}
+
+func runRepoSign(dir, keyFile string, signPackages bool) error {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	key, err := minisign.ParseSecretKey(string(data))
+	if err != nil {
+		return err
+	}
+
+	files := []string{filepath.Join(dir, "index.json")}
+	if signPackages {
+		packages, err := filepath.Glob(filepath.Join(dir, "*.tar.zst"))
+		if err != nil {
+			return err
+		}
+		files = append(files, packages...)
+	}
+
+	for _, file := range files {
+		message, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		comment := fmt.Sprintf("file:%s", filepath.Base(file))
+		if err := os.WriteFile(file+".minisig", minisign.Sign(key, message, comment), 0644); err != nil {
+			return fmt.Errorf("failed to write signature: %w", err)
+		}
+	}
+
+	fmt.Printf("\033[32m[OK]\033[0m Signed %d file(s) with key %s\n", len(files), key.Public().KeyID())
+	return nil
+}
+
+func runRepoVerify(dir string, keyArgs []string, externalDeps bool) error {
+	indexPath := filepath.Join(dir, "index.json")
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	var index pkg.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("failed to parse index: %w", err)
+	}
+
+	fmt.Printf("\033[34m==>\033[0m Verifying %s...\n", indexPath)
+
+	errors := 0
+	for _, p := range repo.LintIndex(&index, repo.LintOptions{Dir: dir, ExternalDeps: externalDeps}) {
+		if p.Fatal {
+			errors++
+			fmt.Printf("\033[31mError:\033[0m %s\n", p)
+		} else {
+			fmt.Printf("\033[33mWarning:\033[0m %s\n", p)
+		}
+	}
+
+	// Signatures
+	if len(keyArgs) == 0 {
+		keyArgs = cfg.TrustedKeys
+	}
+	keys, err := parsePublicKeys(keyArgs)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		files := []string{indexPath}
+		packages, _ := filepath.Glob(filepath.Join(dir, "*.tar.zst"))
+		for _, file := range packages {
+			if _, err := os.Stat(file + ".minisig"); err == nil {
+				files = append(files, file)
+			}
+		}
+
+		for _, file := range files {
+			if err := verifyFileSignature(keys, file); err != nil {
+				errors++
+				fmt.Printf("\033[31mError:\033[0m %s: %v\n", filepath.Base(file), err)
+			}
+		}
+		fmt.Printf("    Checked %d signature(s)\n", len(files))
+	} else {
+		fmt.Printf("    No public keys given, signatures not checked\n")
+	}
+
+	if errors > 0 {
+		return fmt.Errorf("repository has %d error(s)", errors)
+	}
+
+	fmt.Printf("\033[32m[OK]\033[0m Repository is valid\n")
+	return nil
+}
+
+func runRepoKeygen(output string, force bool) error {
+	secretPath := output + ".key"
+	publicPath := output + ".pub"
+
+	if !force {
+		for _, path := range []string{secretPath, publicPath} {
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+			}
+		}
+	}
+
+	pub, secret, err := minisign.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	secretData, _ := secret.MarshalText()
+	if err := os.WriteFile(secretPath, secretData, 0600); err != nil {
+		return fmt.Errorf("failed to write secret key: %w", err)
+	}
+	publicData, _ := pub.MarshalText()
+	if err := os.WriteFile(publicPath, publicData, 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+
+	fmt.Printf("\033[32m[OK]\033[0m Generated key %s\n", pub.KeyID())
+	fmt.Printf("    Secret key: %s (keep it private)\n", secretPath)
+	fmt.Printf("    Public key: %s\n", publicPath)
+	fmt.Printf("\nTrust it on clients with:\n")
+	fmt.Printf("    PHM_TRUSTED_KEYS=\"%s\"\n", pub.String())
+	return nil
+}
+
+// parsePublicKeys parses public keys given as base64 lines or paths to .pub files
+func parsePublicKeys(args []string) ([]minisign.PublicKey, error) {
+	var keys []minisign.PublicKey
+	for _, arg := range args {
+		if data, err := os.ReadFile(arg); err == nil {
+			arg = string(data)
+		}
+		key, err := minisign.ParsePublicKey(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// verifyFileSignature checks file against file.minisig
+func verifyFileSignature(keys []minisign.PublicKey, file string) error {
+	sigData, err := os.ReadFile(file + ".minisig")
+	if err != nil {
+		return fmt.Errorf("missing signature: %w", err)
+	}
+	message, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return minisign.Verify(keys, message, sigData)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/phm-dev/phm/internal/repo"
+	"github.com/spf13/cobra"
+)
+
+func newServeCmd() *cobra.Command {
+	var listen, tlsCert, tlsKey string
+	var noFetch bool
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a package repository over HTTP(S)",
+		Long: `Serve index.json, signatures and package tarballs so other PHM clients
+can use this machine as their repository (PHM_REPO_URL or sources.d).
+
+With --repo <dir> the given repository directory is served. Otherwise the
+local cache of the main repository is served as a pull-through cache: the
+index is refreshed when stale and missing packages are downloaded on demand.
+
+Clients only accept HTTPS repositories, so pass --tls-cert/--tls-key unless
+a TLS-terminating proxy sits in front of the server.
+
+Examples:
+  phm serve --listen :8443 --tls-cert server.crt --tls-key server.key
+  phm serve --repo ./dist --listen 127.0.0.1:8080`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe(listen, tlsCert, tlsKey, !noFetch)
+		},
+	}
+
+	cmd.Flags().StringVarP(&listen, "listen", "l", ":8443", "Address to listen on")
+	cmd.Flags().StringVar(&tlsCert, "tls-cert", "", "TLS certificate file (PEM)")
+	cmd.Flags().StringVar(&tlsKey, "tls-key", "", "TLS private key file (PEM)")
+	cmd.Flags().BoolVar(&noFetch, "no-fetch", false, "Only serve what is already cached (cache mode)")
+
+	return cmd
+}
+
+func runServe(listen, tlsCert, tlsKey string, fetch bool) error {
+	if (tlsCert == "") != (tlsKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+
+	var handler *repo.Server
+	var source string
+	if cfg.Offline || cfg.RepoPath != "" {
+		path := cfg.RepoPath
+		if path == "" {
+			path = "./dist"
+		}
+		dir, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(dir, "index.json")); err != nil {
+			return fmt.Errorf("no index.json in %s (run: phm repo build %s)", dir, path)
+		}
+		handler = repo.NewDirServer(dir)
+		source = dir
+	} else {
+		r, err := getRepo()
+		if err != nil {
+			return err
+		}
+		handler, err = r.NewCacheServer(fetch)
+		if err != nil {
+			return err
+		}
+		source = fmt.Sprintf("cache of %s", cfg.RepoURL)
+	}
+
+	handler.Log = func(format string, args ...any) {
+		fmt.Printf("%s "+format+"\n", append([]any{time.Now().Format("2006-01-02 15:04:05")}, args...)...)
+	}
+
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	scheme := "http"
+	if tlsCert != "" {
+		scheme = "https"
+	}
+	fmt.Printf("\033[34m==>\033[0m Serving %s on %s://%s\n", source, scheme, listen)
+	if tlsCert == "" {
+		fmt.Printf("\033[33mWarning:\033[0m serving plain HTTP; PHM clients require HTTPS\n")
+		return server.ListenAndServe()
+	}
+	return server.ListenAndServeTLS(tlsCert, tlsKey)
+}
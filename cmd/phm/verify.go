@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/spf13/cobra"
+)
+
+func newVerifyCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "verify [packages...]",
+		Short: "Check installed files against the package database",
+		Long: `Check that installed files still match what PHM installed.
+
+Every file is compared with the SHA256, size and mode recorded at install
+time. Missing, modified and permission-changed files are reported. Modified
+config files (under etc/) are expected after local edits and are listed
+separately; they do not make the command fail.
+
+Without arguments, all installed packages are verified. Packages installed
+by older PHM versions have no checksums; only their presence is checked.
+
+Examples:
+  phm verify
+  phm verify php8.5-cli php8.5-redis
+  phm verify --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerify(args, jsonOutput)
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print results as JSON")
+	return cmd
+}
+
+func runVerify(packages []string, jsonOutput bool) error {
+	mgr := getManager()
+	if err := mgr.LoadInstalled(); err != nil {
+		return fmt.Errorf("could not load installed packages: %w", err)
+	}
+
+	if len(packages) == 0 {
+		for _, p := range mgr.GetAllInstalled() {
+			packages = append(packages, p.Name)
+		}
+		sort.Strings(packages)
+	}
+
+	var results []*pkg.VerifyResult
+	damaged := 0
+	for _, name := range packages {
+		result, err := mgr.Verify(name)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+		if result.Damaged() {
+			damaged++
+		}
+	}
+
+	if jsonOutput || structuredOutput() {
+		if err := writeOutput(results); err != nil {
+			return err
+		}
+	} else {
+		printVerifyResults(results)
+	}
+
+	if damaged > 0 {
+		return fmt.Errorf("%d package(s) have missing or modified files (run: phm repair <package>)", damaged)
+	}
+	return nil
+}
+
+func printVerifyResults(results []*pkg.VerifyResult) {
+	for _, r := range results {
+		if len(r.Issues) == 0 {
+			note := ""
+			if r.Unverified {
+				note = " \033[33m(no checksums recorded, presence only)\033[0m"
+			}
+			fmt.Printf("\033[32m[OK]\033[0m %s %s (%d files)%s\n", r.Package, r.Version, r.Files, note)
+			continue
+		}
+
+		if r.Damaged() {
+			fmt.Printf("\033[31m[FAIL]\033[0m %s %s\n", r.Package, r.Version)
+		} else {
+			fmt.Printf("\033[33m[CONFIG]\033[0m %s %s\n", r.Package, r.Version)
+		}
+		for _, issue := range r.Issues {
+			kind := issue.Issue
+			if issue.Config && issue.Issue != pkg.IssueMissing {
+				kind = "config " + kind
+			}
+			detail := ""
+			if issue.Issue == pkg.IssueMode || issue.Issue == pkg.IssueUnreadable {
+				detail = fmt.Sprintf(" (%s)", issue.Actual)
+				if issue.Expected != "" {
+					detail = fmt.Sprintf(" (%s, expected %s)", issue.Actual, issue.Expected)
+				}
+			}
+			fmt.Printf("  %-16s %s%s\n", kind, issue.Path, detail)
+		}
+	}
+}
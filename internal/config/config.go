@@ -1,28 +1,89 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"time"
 )
 
+// MainSourceName is the name of the repository configured by RepoURL
+const MainSourceName = "main"
+
+// LocalSourceName is the name of the repository configured by --repo / offline mode
+const LocalSourceName = "local"
+
+// DefaultPriority is the priority of repositories that don't set one
+const DefaultPriority = 100
+
+// Source describes a package repository
+type Source struct {
+	Name        string   // Unique repository name (e.g., "main", "internal")
+	URL         string   // Remote base URL (https://...)
+	Path        string   // Local repository directory (used instead of URL)
+	Mirrors     []string // Alternative base URLs for package downloads, tried in order
+	Priority    int      // Higher priority repositories take precedence
+	TrustedKeys []string // Repository-specific signing keys (default: global TrustedKeys)
+}
+
+// IsLocal reports whether the source is a local directory
+func (s Source) IsLocal() bool {
+	return s.Path != ""
+}
+
+// Location returns the URL or path of the source for display
+func (s Source) Location() string {
+	if s.IsLocal() {
+		return "file://" + s.Path
+	}
+	return s.URL
+}
+
 // Config holds PHM configuration
 type Config struct {
 	// Mode flags
-	Offline bool
-	Debug   bool
+	Offline  bool
+	Debug    bool
+	DryRun   bool   // Show what would change without changing anything (--dry-run)
+	Output   string // Output format of queries: text, json or yaml (--output)
+	UserMode bool   // Rootless per-user installation (PHM_USER_MODE), never uses sudo
 
 	// Paths
+	Root          string // Directory every path below is relocated into (--root / PHM_ROOT), "" for /
 	RepoURL       string // Online repository URL
 	RepoPath      string // Local repository path (offline mode)
 	InstallPrefix string
 	CacheDir      string
 	DataDir       string
 	ConfigDir     string
+	HomeDir       string // Home directory of the user (inside Root)
 
 	// Tools paths
 	ToolsPrefix  string // /opt/phm/bin - where tools are installed
 	ToolsDataDir string // ~/.local/share/phm/tools - tools metadata
+
+	// Additional repositories (from sources.d)
+	Sources []Source
+
+	// Index cache
+	CacheExpiry time.Duration // How long a fetched index is used without revalidation
+	AutoUpdate  bool          // Refresh a stale index automatically before commands
+
+	// Package downloads
+	Mirrors             []string      // Mirror base URLs for the main repository
+	DownloadRetries     int           // Retries per URL on 5xx responses and network errors
+	DownloadIdleTimeout time.Duration // Abort a transfer when no data arrives for this long
+
+	// Package lifecycle scripts
+	ScriptTimeout time.Duration // Kill preinst/postinst/prerm/postrm scripts after this long
+
+	// Signature verification
+	TrustedKeys            []string // minisign public keys allowed to sign index.json
+	VerifyPackageSignature bool     // also require a detached .minisig for each package
+	TrustedKeysErr         error    // why the trusted keys could not be loaded; indexes are refused then
 }
 
 // New creates a new Config with default values
@@ -38,13 +99,62 @@ func New() *Config {
 		CacheDir:      filepath.Join(homeDir, ".cache", "phm"),
 		DataDir:       filepath.Join(homeDir, ".local", "share", "phm"),
 		ConfigDir:     filepath.Join(homeDir, ".config", "phm"),
+		HomeDir:       homeDir,
 		ToolsPrefix:   "/opt/phm/bin",
 		ToolsDataDir:  filepath.Join(homeDir, ".local", "share", "phm", "tools"),
+		CacheExpiry:   time.Hour,
+		AutoUpdate:    true,
+
+		DownloadRetries:     3,
+		DownloadIdleTimeout: 30 * time.Second,
+		ScriptTimeout:       5 * time.Minute,
 	}
 
 	return cfg
 }
 
+// SetRoot relocates all paths PHM writes to (install and tools prefixes, cache,
+// data and config directories, and the system locations returned by RootPath)
+// into root. Must be called before Load, so the configuration is read from the
+// relocated config directory.
+func (c *Config) SetRoot(root string) error {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("invalid root %q: %w", root, err)
+	}
+	if abs == "/" {
+		return nil
+	}
+
+	c.Root = abs
+	for _, p := range []*string{&c.InstallPrefix, &c.ToolsPrefix, &c.CacheDir, &c.DataDir, &c.ConfigDir, &c.HomeDir, &c.ToolsDataDir} {
+		*p = filepath.Join(abs, *p)
+	}
+	return nil
+}
+
+// UserDir returns the directory of the rootless per-user installation
+// (~/.local/phm), holding the php and bin prefixes in user mode
+func (c *Config) UserDir() string {
+	return filepath.Join(c.HomeDir, ".local", "phm")
+}
+
+// RootPath returns an absolute system path (e.g. /usr/local/bin) inside Root
+func (c *Config) RootPath(path string) string {
+	if c.Root == "" {
+		return path
+	}
+	return filepath.Join(c.Root, path)
+}
+
+// UnrootPath returns path as seen from inside Root (the inverse of RootPath)
+func (c *Config) UnrootPath(path string) string {
+	if c.Root == "" {
+		return path
+	}
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(path, c.Root), "/")
+}
+
 // GetRepoURL returns the repository URL based on mode
 func (c *Config) GetRepoURL() string {
 	if c.Offline || c.RepoPath != "" {
@@ -56,6 +166,46 @@ func (c *Config) GetRepoURL() string {
 	return c.RepoURL
 }
 
+// Repositories returns the effective list of repositories ordered by precedence
+// (highest priority first). In offline mode only the local repository is used.
+func (c *Config) Repositories() []Source {
+	if c.Offline || c.RepoPath != "" {
+		path := c.RepoPath
+		if path == "" {
+			path = "./dist"
+		}
+		return []Source{{Name: LocalSourceName, Path: path, Priority: DefaultPriority}}
+	}
+
+	sources := []Source{{Name: MainSourceName, URL: c.RepoURL, Mirrors: c.Mirrors, Priority: DefaultPriority}}
+	sources = append(sources, c.Sources...)
+
+	// Stable sort keeps the main repository first among equal priorities
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Priority > sources[j].Priority
+	})
+	return sources
+}
+
+// SourceCacheDir returns the cache directory for a repository's index and packages.
+// The main repository uses CacheDir directly for backward compatibility.
+func (c *Config) SourceCacheDir(name string) string {
+	if name == MainSourceName || name == "" {
+		return c.CacheDir
+	}
+	return filepath.Join(c.CacheDir, "sources", name)
+}
+
+// SourcesDir returns the directory with additional repository definitions
+func (c *Config) SourcesDir() string {
+	return filepath.Join(c.ConfigDir, "sources.d")
+}
+
+// trimFileURL converts file:// URLs to plain paths
+func trimFileURL(url string) string {
+	return strings.TrimPrefix(url, "file://")
+}
+
 // GetIndexPath returns the path to index.json
 func (c *Config) GetIndexPath() string {
 	if c.Offline || c.RepoPath != "" {
@@ -116,6 +266,16 @@ func (c *Config) EnsureDirs() error {
 	return nil
 }
 
+// ConfigFilePath returns the path to phm.conf
+func (c *Config) ConfigFilePath() string {
+	return filepath.Join(c.ConfigDir, "phm.conf")
+}
+
+// TrustedKeysDir returns the directory scanned for trusted public keys (*.pub)
+func (c *Config) TrustedKeysDir() string {
+	return filepath.Join(c.ConfigDir, "trusted-keys")
+}
+
 // InstalledDBPath returns path to installed packages database
 func (c *Config) InstalledDBPath() string {
 	return filepath.Join(c.DataDir, "installed")
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sourceNameRegex validates repository names (used in cache paths)
+var sourceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// Load reads phm.conf, the trusted keys directory and sources.d, overriding defaults.
+// Missing files are not an error. Every file is read even when an earlier one is
+// invalid, so the trusted keys are always loaded; if they cannot be,
+// TrustedKeysErr is set.
+func (c *Config) Load() error {
+	var errs []error
+	if err := c.LoadFile(c.ConfigFilePath()); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.loadTrustedKeysDir(); err != nil {
+		c.TrustedKeysErr = err
+		errs = append(errs, err)
+	}
+	if err := c.loadSourcesDir(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// LoadFile reads a shell-style KEY="value" configuration file. Invalid values
+// are reported together after every valid one has been applied.
+func (c *Config) LoadFile(path string) error {
+	values, err := parseConfFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		// The file may hold PHM_TRUSTED_KEYS
+		c.TrustedKeysErr = fmt.Errorf("failed to read %s: %w", path, err)
+		return c.TrustedKeysErr
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		value := values[key]
+		switch key {
+		case "PHM_REPO_URL":
+			c.RepoURL = strings.TrimSuffix(value, "/")
+		case "PHM_INSTALL_PREFIX":
+			c.InstallPrefix = c.RootPath(value)
+		case "PHM_USER_MODE":
+			c.UserMode = parseBool(value)
+		case "PHM_CACHE_EXPIRY":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				errs = append(errs, fmt.Errorf("invalid PHM_CACHE_EXPIRY %q: must be a number of seconds", value))
+				continue
+			}
+			c.CacheExpiry = time.Duration(seconds) * time.Second
+		case "PHM_AUTO_UPDATE":
+			c.AutoUpdate = parseBool(value)
+		case "PHM_MIRRORS":
+			c.Mirrors = trimURLs(strings.Fields(value))
+		case "PHM_DOWNLOAD_RETRIES":
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				errs = append(errs, fmt.Errorf("invalid PHM_DOWNLOAD_RETRIES %q: must be a non-negative number", value))
+				continue
+			}
+			c.DownloadRetries = retries
+		case "PHM_DOWNLOAD_IDLE_TIMEOUT":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				errs = append(errs, fmt.Errorf("invalid PHM_DOWNLOAD_IDLE_TIMEOUT %q: must be a positive number of seconds", value))
+				continue
+			}
+			c.DownloadIdleTimeout = time.Duration(seconds) * time.Second
+		case "PHM_SCRIPT_TIMEOUT":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				errs = append(errs, fmt.Errorf("invalid PHM_SCRIPT_TIMEOUT %q: must be a positive number of seconds", value))
+				continue
+			}
+			c.ScriptTimeout = time.Duration(seconds) * time.Second
+		case "PHM_TRUSTED_KEYS":
+			c.TrustedKeys = append(c.TrustedKeys, strings.Fields(value)...)
+		case "PHM_VERIFY_PACKAGE_SIGNATURES":
+			c.VerifyPackageSignature = parseBool(value)
+		}
+	}
+
+	// User mode installs into the home directory unless a prefix is configured
+	if c.UserMode {
+		if _, ok := values["PHM_INSTALL_PREFIX"]; !ok {
+			c.InstallPrefix = filepath.Join(c.UserDir(), "php")
+		}
+		c.ToolsPrefix = filepath.Join(c.UserDir(), "bin")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %w", path, errors.Join(errs...))
+	}
+	return nil
+}
+
+// loadTrustedKeysDir appends the contents of every *.pub file in TrustedKeysDir
+func (c *Config) loadTrustedKeysDir() error {
+	files, err := filepath.Glob(filepath.Join(c.TrustedKeysDir(), "*.pub"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read trusted key %s: %w", file, err)
+		}
+		c.TrustedKeys = append(c.TrustedKeys, string(data))
+	}
+
+	return nil
+}
+
+// loadSourcesDir reads additional repositories from sources.d/*.conf.
+// Each file defines one repository:
+//
+//	NAME=internal                          (default: file name without .conf)
+//	URL=https://packages.example.com/phm   (or a local directory / file:// URL)
+//	MIRRORS="https://mirror.example.com/phm" (optional, space-separated)
+//	PRIORITY=200                           (default: 100, higher wins)
+//	TRUSTED_KEYS="RWQ..."                  (optional, overrides PHM_TRUSTED_KEYS)
+//	ENABLED=false                          (optional)
+func (c *Config) loadSourcesDir() error {
+	files, err := filepath.Glob(filepath.Join(c.SourcesDir(), "*.conf"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	seen := map[string]bool{MainSourceName: true, LocalSourceName: true}
+	for _, file := range files {
+		values, err := parseConfFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if enabled, ok := values["ENABLED"]; ok && !parseBool(enabled) {
+			continue
+		}
+
+		src := Source{
+			Name:     strings.TrimSuffix(filepath.Base(file), ".conf"),
+			Priority: DefaultPriority,
+		}
+		if name := values["NAME"]; name != "" {
+			src.Name = name
+		}
+		if !sourceNameRegex.MatchString(src.Name) {
+			return fmt.Errorf("%s: invalid repository name %q", file, src.Name)
+		}
+		if seen[src.Name] {
+			return fmt.Errorf("%s: duplicate or reserved repository name %q", file, src.Name)
+		}
+		seen[src.Name] = true
+
+		url := strings.TrimSuffix(values["URL"], "/")
+		switch {
+		case url == "":
+			return fmt.Errorf("%s: URL is required", file)
+		case strings.HasPrefix(url, "file://") || strings.HasPrefix(url, "/"):
+			src.Path = trimFileURL(url)
+		default:
+			src.URL = url
+		}
+
+		if p := values["PRIORITY"]; p != "" {
+			priority, err := strconv.Atoi(p)
+			if err != nil {
+				return fmt.Errorf("%s: invalid PRIORITY %q", file, p)
+			}
+			src.Priority = priority
+		}
+		src.Mirrors = trimURLs(strings.Fields(values["MIRRORS"]))
+		src.TrustedKeys = strings.Fields(values["TRUSTED_KEYS"])
+
+		c.Sources = append(c.Sources, src)
+	}
+
+	return nil
+}
+
+// parseConfFile parses KEY=value lines, ignoring comments and blank lines.
+// Values may be wrapped in single or double quotes.
+func parseConfFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// trimURLs removes trailing slashes from base URLs
+func trimURLs(urls []string) []string {
+	for i, u := range urls {
+		urls[i] = strings.TrimSuffix(u, "/")
+	}
+	return urls
+}
+
+// parseBool interprets common truthy values from config files
+func parseBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
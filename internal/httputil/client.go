@@ -6,3 +6,13 @@ import (
 )
 
 var Client = &http.Client{Timeout: 60 * time.Second}
+
+// DownloadClient has no overall timeout so large packages can take as long as they need.
+// Stalled transfers are detected by an idle timeout on the response body instead.
+var DownloadClient = &http.Client{Transport: downloadTransport()}
+
+func downloadTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = 30 * time.Second
+	return t
+}
@@ -0,0 +1,81 @@
+package lockfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultFile is the lockfile phm lock writes when no file is given
+const DefaultFile = "phm.lock"
+
+// Version is the lockfile schema version written by phm lock
+const Version = 1
+
+// Lockfile freezes the installed packages and tools of a machine
+type Lockfile struct {
+	LockVersion int       `json:"lock_version"`
+	Platform    string    `json:"platform"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Packages    []Package `json:"packages"`
+	Tools       []Tool    `json:"tools"`
+}
+
+// Package is an exact build of an installed package
+type Package struct {
+	Name        string `json:"name"`     // Installed name (php8.5-cli, or php8.5.1-cli when pinned)
+	Package     string `json:"package"`  // Name in the index (php8.5-cli)
+	Version     string `json:"version"`  // Package version
+	Revision    int    `json:"revision"` // Package revision
+	PHPVersion  string `json:"php_version,omitempty"`
+	InstallSlot string `json:"install_slot,omitempty"` // Directory slot (8.5 or 8.5.1)
+	Pinned      bool   `json:"pinned,omitempty"`
+	Repo        string `json:"repo,omitempty"` // Repository the build was installed from
+	Filename    string `json:"filename"`
+	SHA256      string `json:"sha256"`
+}
+
+// Tool is an exact version of an installed tool
+type Tool struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	SourceURL string `json:"source_url,omitempty"`
+	SHA256    string `json:"sha256,omitempty"` // Checksum of the binary or phar
+}
+
+// Read reads and validates a lockfile
+func Read(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var l Lockfile
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %w", path, err)
+	}
+	if l.LockVersion != Version {
+		return nil, fmt.Errorf("unsupported lockfile version %d in %s (expected %d)", l.LockVersion, path, Version)
+	}
+	for _, p := range l.Packages {
+		if p.Name == "" || p.Package == "" || p.Version == "" || p.SHA256 == "" {
+			return nil, fmt.Errorf("invalid lockfile %s: package entries need name, package, version and sha256", path)
+		}
+	}
+	for _, t := range l.Tools {
+		if t.Name == "" || t.Version == "" {
+			return nil, fmt.Errorf("invalid lockfile %s: tool entries need name and version", path)
+		}
+	}
+	return &l, nil
+}
+
+// Write writes the lockfile as indented JSON
+func (l *Lockfile) Write(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,283 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultFile is the manifest phm apply reads when no file is given
+const DefaultFile = "phm.toml"
+
+// slotRegex matches PHP slots: minor versions (8.5) or pinned patch versions (8.5.1)
+var slotRegex = regexp.MustCompile(`^\d+\.\d+(\.\d+)?$`)
+
+// nameRegex matches package suffixes, extension, tool and SAPI names
+var nameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._+-]*$`)
+
+// iniKeyRegex matches php.ini directive names (memory_limit, opcache.enable, ...)
+var iniKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Manifest describes the desired PHP environment of a machine
+type Manifest struct {
+	Default string   // Default PHP version (phm use)
+	Tools   []string // Developer tools (composer, symfony, ...)
+	Slots   []Slot   // PHP installations, sorted by version
+}
+
+// Slot is the desired state of one PHP installation
+type Slot struct {
+	Version    string   // Minor version (8.5) tracking the latest release, or pinned patch version (8.5.1)
+	Packages   []string // Core packages by suffix (cli, fpm, ...), default cli
+	Extensions []string // Extension packages by suffix (redis, xdebug, ...)
+	Enabled    []string // Extensions enabled for all SAPIs
+	// SAPIEnabled lists extensions enabled for one SAPI only (cli, fpm)
+	SAPIEnabled map[string][]string
+	Disabled    []string          // Extensions that must be disabled
+	INI         map[string]string // php.ini overrides
+	FPM         *bool             // Start PHP-FPM at boot (nil: leave as is)
+}
+
+// PackageNames returns the packages the slot needs (php8.5-cli, php8.5-redis, ...)
+func (s *Slot) PackageNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(suffix string) {
+		name := "php" + s.Version + "-" + suffix
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, p := range s.Packages {
+		add(p)
+	}
+	if s.FPM != nil && *s.FPM {
+		add("fpm")
+	}
+	for _, ext := range s.Extensions {
+		add(ext)
+	}
+	return names
+}
+
+// Slot returns the slot of a PHP version, nil if the manifest does not list it
+func (m *Manifest) Slot(version string) *Slot {
+	for i := range m.Slots {
+		if m.Slots[i].Version == version {
+			return &m.Slots[i]
+		}
+	}
+	return nil
+}
+
+// Load reads and validates a manifest file
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Parse parses a manifest in TOML:
+//
+//	default = "8.5"
+//	tools = ["composer"]
+//
+//	[php."8.5"]
+//	packages = ["cli", "fpm"]
+//	extensions = ["redis", "xdebug"]
+//	enabled = ["opcache", "redis"]
+//	disabled = []
+//	fpm = true
+//
+//	[php."8.5".sapi.cli]
+//	enabled = ["xdebug"]
+//
+//	[php."8.5".ini]
+//	memory_limit = "512M"
+//
+// Only the TOML needed for manifests is supported: tables, strings, booleans,
+// integers and arrays of those. Unknown keys are rejected.
+func Parse(data string) (*Manifest, error) {
+	doc, err := parseTOML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	for key, value := range doc {
+		switch key {
+		case "default":
+			if m.Default, err = stringValue(key, value); err != nil {
+				return nil, err
+			}
+			if !slotRegex.MatchString(m.Default) {
+				return nil, fmt.Errorf("default: invalid PHP version %q", m.Default)
+			}
+		case "tools":
+			if m.Tools, err = nameList(key, value); err != nil {
+				return nil, err
+			}
+		case "php":
+			versions, ok := value.(table)
+			if !ok {
+				return nil, fmt.Errorf("php: must be a table of PHP versions ([php.\"8.5\"])")
+			}
+			for version, value := range versions {
+				slot, err := parseSlot(version, value)
+				if err != nil {
+					return nil, err
+				}
+				m.Slots = append(m.Slots, *slot)
+			}
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	sort.Slice(m.Slots, func(i, j int) bool {
+		return m.Slots[i].Version < m.Slots[j].Version
+	})
+	if m.Default != "" && m.Slot(m.Default) == nil {
+		return nil, fmt.Errorf("default: PHP %s is not listed (add a [php.%q] table)", m.Default, m.Default)
+	}
+	return m, nil
+}
+
+// parseSlot decodes a [php."X.Y"] table
+func parseSlot(version string, value any) (*Slot, error) {
+	if !slotRegex.MatchString(version) {
+		return nil, fmt.Errorf("php.%s: invalid PHP version (use 8.5 or a pinned 8.5.1)", version)
+	}
+	t, ok := value.(table)
+	if !ok {
+		return nil, fmt.Errorf("php.%s: must be a table", version)
+	}
+
+	slot := &Slot{
+		Version:     version,
+		Packages:    []string{"cli"},
+		SAPIEnabled: make(map[string][]string),
+		INI:         make(map[string]string),
+	}
+	prefix := "php." + version + "."
+	var err error
+	for key, value := range t {
+		switch key {
+		case "packages":
+			slot.Packages, err = nameList(prefix+key, value)
+		case "extensions":
+			slot.Extensions, err = nameList(prefix+key, value)
+		case "enabled":
+			slot.Enabled, err = nameList(prefix+key, value)
+		case "disabled":
+			slot.Disabled, err = nameList(prefix+key, value)
+		case "fpm":
+			enabled, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s%s: must be true or false", prefix, key)
+			}
+			slot.FPM = &enabled
+		case "sapi":
+			sapis, ok := value.(table)
+			if !ok {
+				return nil, fmt.Errorf("%s%s: must be a table ([%s%s.cli])", prefix, key, prefix, key)
+			}
+			for sapi, v := range sapis {
+				if sapi != "cli" && sapi != "fpm" {
+					return nil, fmt.Errorf("%s%s: unknown SAPI %q (use cli or fpm)", prefix, key, sapi)
+				}
+				settings, ok := v.(table)
+				if !ok {
+					return nil, fmt.Errorf("%s%s.%s: must be a table", prefix, key, sapi)
+				}
+				for name, v := range settings {
+					if name != "enabled" {
+						return nil, fmt.Errorf("unknown key %q", prefix+key+"."+sapi+"."+name)
+					}
+					if slot.SAPIEnabled[sapi], err = nameList(prefix+key+"."+sapi+"."+name, v); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case "ini":
+			settings, ok := value.(table)
+			if !ok {
+				return nil, fmt.Errorf("%s%s: must be a table", prefix, key)
+			}
+			for name, v := range settings {
+				if !iniKeyRegex.MatchString(name) {
+					return nil, fmt.Errorf("%s%s: invalid directive %q", prefix, key, name)
+				}
+				if _, nested := v.(table); nested {
+					return nil, fmt.Errorf("%s%s.%s: must be a value", prefix, key, name)
+				}
+				if _, list := v.([]any); list {
+					return nil, fmt.Errorf("%s%s.%s: must be a value", prefix, key, name)
+				}
+				slot.INI[name] = iniValue(v)
+			}
+		default:
+			err = fmt.Errorf("unknown key %q", prefix+key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return slot, nil
+}
+
+// stringValue returns a string value
+func stringValue(key string, value any) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: must be a string", key)
+	}
+	return s, nil
+}
+
+// nameList returns an array of package, extension or tool names
+func nameList(key string, value any) ([]string, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be an array of strings", key)
+	}
+	names := []string{}
+	for _, item := range items {
+		name, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: must be an array of strings", key)
+		}
+		if !nameRegex.MatchString(name) {
+			return nil, fmt.Errorf("%s: invalid name %q", key, name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// iniValue formats a value for php.ini. Strings are written as given, so
+// "512M" and "E_ALL & ~E_DEPRECATED" keep their meaning.
+func iniValue(value any) string {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "On"
+		}
+		return "Off"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return strings.TrimSpace(v)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,105 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const full = `
+default = "8.5"
+tools = ["composer", "symfony"] # developer tools
+
+[php."8.5"]
+packages = ["cli", "fpm"]
+extensions = [
+  "redis",
+  "xdebug",
+]
+enabled = ["opcache", "redis"]
+disabled = []
+fpm = true
+sapi.cli.enabled = ["xdebug"]
+
+[php."8.5".ini]
+memory_limit = "512M"
+error_reporting = "E_ALL & ~E_DEPRECATED # not a comment"
+display_errors = false
+max_execution_time = 30
+"opcache.enable" = true
+
+[php."8.4.3"]
+`
+	enabled := true
+	want := &Manifest{
+		Default: "8.5",
+		Tools:   []string{"composer", "symfony"},
+		Slots: []Slot{
+			{
+				Version:     "8.4.3",
+				Packages:    []string{"cli"},
+				SAPIEnabled: map[string][]string{},
+				INI:         map[string]string{},
+			},
+			{
+				Version:     "8.5",
+				Packages:    []string{"cli", "fpm"},
+				Extensions:  []string{"redis", "xdebug"},
+				Enabled:     []string{"opcache", "redis"},
+				SAPIEnabled: map[string][]string{"cli": {"xdebug"}},
+				Disabled:    []string{},
+				INI: map[string]string{
+					"memory_limit":       "512M",
+					"error_reporting":    "E_ALL & ~E_DEPRECATED # not a comment",
+					"display_errors":     "Off",
+					"max_execution_time": "30",
+					"opcache.enable":     "On",
+				},
+				FPM: &enabled,
+			},
+		},
+	}
+
+	got, err := Parse(full)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %+v, want %+v", got, want)
+	}
+	if names := got.Slot("8.5").PackageNames(); !reflect.DeepEqual(names, []string{"php8.5-cli", "php8.5-fpm", "php8.5-redis", "php8.5-xdebug"}) {
+		t.Errorf("PackageNames = %v", names)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "unknown top-level key", data: `defualt = "8.5"`, wantErr: `unknown key "defualt"`},
+		{name: "unknown slot key", data: "[php.\"8.5\"]\nextension = [\"redis\"]", wantErr: `unknown key "php.8.5.extension"`},
+		{name: "unknown sapi key", data: "[php.\"8.5\".sapi.cli]\ndisabled = []", wantErr: `unknown key "php.8.5.sapi.cli.disabled"`},
+		{name: "unknown sapi", data: "[php.\"8.5\".sapi.cgi]\nenabled = []", wantErr: `unknown SAPI "cgi"`},
+		{name: "duplicate key", data: "[php.\"8.5\"]\nfpm = true\nfpm = false", wantErr: `duplicate key "fpm"`},
+		{name: "unquoted version", data: "[php.8.5]\nfpm = true", wantErr: "php.8: invalid PHP version"},
+		{name: "invalid version", data: "[php.\"eight\"]", wantErr: "php.eight: invalid PHP version"},
+		{name: "default not listed", data: "default = \"8.4\"\n[php.\"8.5\"]", wantErr: "default: PHP 8.4 is not listed"},
+		{name: "default not a string", data: "default = 8", wantErr: "default: must be a string"},
+		{name: "tools not an array", data: `tools = "composer"`, wantErr: "tools: must be an array of strings"},
+		{name: "invalid name", data: `tools = ["Composer X"]`, wantErr: `tools: invalid name "Composer X"`},
+		{name: "fpm not a bool", data: "[php.\"8.5\"]\nfpm = \"yes\"", wantErr: "php.8.5.fpm: must be true or false"},
+		{name: "ini array", data: "[php.\"8.5\".ini]\nmemory_limit = [1]", wantErr: "php.8.5.ini.memory_limit: must be a value"},
+		{name: "invalid ini directive", data: "[php.\"8.5\".ini]\n\"a b\" = 1", wantErr: `php.8.5.ini: invalid directive "a b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Parse = %+v, %v; want error containing %q", m, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// table is a parsed TOML table. Values are string, bool, int64, []any or table.
+type table map[string]any
+
+// parseTOML parses the subset of TOML used by manifests: [table] headers with
+// dotted and quoted keys, key = value pairs, basic and literal strings,
+// booleans, integers and (multi-line) arrays. Array tables, inline tables,
+// floats and dates are not supported.
+func parseTOML(data string) (table, error) {
+	root := table{}
+	current := root
+	lines := strings.Split(data, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: arrays of tables are not supported", lineNo)
+			}
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated table header", lineNo)
+			}
+			keys, err := parseKey(strings.TrimSpace(line[1 : len(line)-1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if current, err = subTable(root, keys); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			continue
+		}
+
+		eq := keyEnd(line)
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		keys, err := parseKey(strings.TrimSpace(line[:eq]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		raw := strings.TrimSpace(line[eq+1:])
+
+		// Arrays may continue on the following lines
+		for strings.HasPrefix(raw, "[") && !balanced(raw) && i+1 < len(lines) {
+			i++
+			raw += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+
+		value, rest, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("line %d: unexpected %q after value", lineNo, strings.TrimSpace(rest))
+		}
+
+		parent, err := subTable(current, keys[:len(keys)-1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		name := keys[len(keys)-1]
+		if _, exists := parent[name]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNo, strings.Join(keys, "."))
+		}
+		parent[name] = value
+	}
+	return root, nil
+}
+
+// subTable returns the table at keys below t, creating missing tables
+func subTable(t table, keys []string) (table, error) {
+	for _, key := range keys {
+		next, exists := t[key]
+		if !exists {
+			next = table{}
+			t[key] = next
+		}
+		sub, ok := next.(table)
+		if !ok {
+			return nil, fmt.Errorf("key %q is not a table", key)
+		}
+		t = sub
+	}
+	return t, nil
+}
+
+// stripComment removes a # comment outside of strings
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// keyEnd returns the index of the = separating key and value, -1 if there is none
+func keyEnd(line string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '=':
+			return i
+		}
+	}
+	return -1
+}
+
+// balanced reports whether all brackets opened outside of strings are closed
+func balanced(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// parseKey splits a dotted key (php."8.5".ini) into its parts
+func parseKey(s string) ([]string, error) {
+	var keys []string
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, fmt.Errorf("empty key")
+		}
+
+		var key string
+		if s[0] == '"' || s[0] == '\'' {
+			value, rest, err := parseString(s)
+			if err != nil {
+				return nil, err
+			}
+			key, s = value, rest
+		} else {
+			end := strings.IndexFunc(s, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-')
+			})
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid key %q", s)
+			}
+			key, s = s[:end], s[end:]
+		}
+		keys = append(keys, key)
+
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return keys, nil
+		}
+		if s[0] != '.' {
+			return nil, fmt.Errorf("invalid key: unexpected %q", s)
+		}
+		s = s[1:]
+	}
+}
+
+// parseValue parses the value at the start of s and returns the rest
+func parseValue(s string) (any, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+
+	switch {
+	case s[0] == '"' || s[0] == '\'':
+		return parseString(s)
+	case s[0] == '[':
+		return parseArray(s[1:])
+	case s[0] == '{':
+		return nil, "", fmt.Errorf("inline tables are not supported")
+	}
+
+	end := strings.IndexAny(s, ",] \t")
+	if end < 0 {
+		end = len(s)
+	}
+	word, rest := s[:end], s[end:]
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(word, "_", ""), 0, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported value %q (quote strings)", word)
+	}
+	return n, rest, nil
+}
+
+// parseArray parses array elements after the opening bracket
+func parseArray(s string) (any, string, error) {
+	items := []any{}
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, "", fmt.Errorf("unterminated array")
+		}
+		if s[0] == ']' {
+			return items, s[1:], nil
+		}
+
+		item, rest, err := parseValue(s)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, item)
+
+		s = strings.TrimSpace(rest)
+		if s == "" {
+			return nil, "", fmt.Errorf("unterminated array")
+		}
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+// parseString parses a basic ("...") or literal ('...') string at the start of s
+func parseString(s string) (string, string, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			if quote == '\'' {
+				return s[1:i], s[i+1:], nil
+			}
+			value, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+			}
+			return value, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
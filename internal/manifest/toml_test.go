@@ -0,0 +1,78 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    table
+		wantErr string // substring of the error
+	}{
+		{
+			name: "values",
+			data: "s = \"a\"\nl = 'C:\\dir'\nb = true\nn = 1_000\nh = 0x10\na = [\"x\", 1, false]\ne = []",
+			want: table{"s": "a", "l": `C:\dir`, "b": true, "n": int64(1000), "h": int64(16), "a": []any{"x", int64(1), false}, "e": []any{}},
+		},
+		{
+			name: "tables and dotted keys",
+			data: "top = 1\n[php.\"8.5\"]\nfpm = true\nini.memory_limit = \"512M\"\n[php.'8.4'.sapi.cli]\nenabled = []",
+			want: table{
+				"top": int64(1),
+				"php": table{
+					"8.5": table{"fpm": true, "ini": table{"memory_limit": "512M"}},
+					"8.4": table{"sapi": table{"cli": table{"enabled": []any{}}}},
+				},
+			},
+		},
+		{
+			name: "quoted keys",
+			data: "\"a.b\" = 1\n'c d' = 2\n\"e\\\"f\" = 3\n\"g=h\" = 4",
+			want: table{"a.b": int64(1), "c d": int64(2), `e"f`: int64(3), "g=h": int64(4)},
+		},
+		{
+			name: "multi-line arrays",
+			data: "a = [\n  \"x\", # first\n  \"y\",\n]\nb = [[1, 2],\n  [3]]\nc = 1",
+			want: table{"a": []any{"x", "y"}, "b": []any{[]any{int64(1), int64(2)}, []any{int64(3)}}, "c": int64(1)},
+		},
+		{
+			name: "comments",
+			data: "# header\na = \"x # y\" # trailing\nb = 'p#q'\nc = \"e\\\"#f\"\nd = [\"]\", \"#\"] # done",
+			want: table{"a": "x # y", "b": "p#q", "c": `e"#f`, "d": []any{"]", "#"}},
+		},
+		{name: "duplicate key", data: "a = 1\na = 2", wantErr: `line 2: duplicate key "a"`},
+		{name: "duplicate dotted key", data: "[x]\ny.z = 1\n[x.y]\nz = 2", wantErr: `line 4: duplicate key "z"`},
+		{name: "value is not a table", data: "a = 1\n[a]", wantErr: `line 2: key "a" is not a table`},
+		{name: "missing value", data: "a =", wantErr: "line 1: missing value"},
+		{name: "missing equals", data: "a 1", wantErr: "line 1: expected key = value"},
+		{name: "unquoted string", data: "a = yes", wantErr: "unsupported value"},
+		{name: "trailing garbage", data: "a = \"x\" y", wantErr: "unexpected"},
+		{name: "unterminated string", data: "a = \"x", wantErr: "unterminated string"},
+		{name: "unterminated array", data: "a = [1,\n2", wantErr: "unterminated array"},
+		{name: "unterminated header", data: "[a", wantErr: "unterminated table header"},
+		{name: "array of tables", data: "[[a]]", wantErr: "arrays of tables are not supported"},
+		{name: "inline table", data: "a = {b = 1}", wantErr: "inline tables are not supported"},
+		{name: "empty key", data: "[a.]", wantErr: "empty key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTOML = %v, %v; want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTOML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
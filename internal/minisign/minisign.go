@@ -0,0 +1,142 @@
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Only the pure ed25519 algorithm ("Ed") is supported. Pre-hashed BLAKE2b
+// signatures ("ED", the minisign default since 0.10) must be created with
+// `minisign -S -l` instead.
+const algEd25519 = "Ed"
+
+const (
+	untrustedPrefix = "untrusted comment:"
+	trustedPrefix   = "trusted comment:"
+)
+
+// PublicKey is a minisign-compatible ed25519 public key
+type PublicKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// KeyID returns the key ID in the hex format minisign prints
+func (k PublicKey) KeyID() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.ID[:]))
+}
+
+// Signature is a parsed detached minisign signature (.minisig file)
+type Signature struct {
+	Algorithm       string
+	KeyID           [8]byte
+	Signature       []byte
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// ParsePublicKey parses a public key from either the bare base64 line
+// (as printed by `minisign -G`) or the full contents of a .pub file.
+func ParsePublicKey(s string) (PublicKey, error) {
+	var pk PublicKey
+
+	line := ""
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, untrustedPrefix) {
+			continue
+		}
+		line = l
+		break
+	}
+	if line == "" {
+		return pk, fmt.Errorf("empty public key")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return pk, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	if len(raw) != 2+8+ed25519.PublicKeySize {
+		return pk, fmt.Errorf("invalid public key length: %d bytes", len(raw))
+	}
+	if string(raw[:2]) != algEd25519 {
+		return pk, fmt.Errorf("unsupported public key algorithm %q", raw[:2])
+	}
+
+	copy(pk.ID[:], raw[2:10])
+	pk.Key = ed25519.PublicKey(raw[10:])
+	return pk, nil
+}
+
+// ParseSignature parses the contents of a .minisig file
+func ParseSignature(data []byte) (*Signature, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) < 4 {
+		return nil, fmt.Errorf("invalid signature: expected 4 lines, got %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], untrustedPrefix) {
+		return nil, fmt.Errorf("invalid signature: missing untrusted comment")
+	}
+	if !strings.HasPrefix(lines[2], trustedPrefix) {
+		return nil, fmt.Errorf("invalid signature: missing trusted comment")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if len(raw) != 2+8+ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid signature length: %d bytes", len(raw))
+	}
+
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid global signature encoding: %w", err)
+	}
+	if len(global) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid global signature length: %d bytes", len(global))
+	}
+
+	sig := &Signature{
+		Algorithm:       string(raw[:2]),
+		Signature:       raw[10:],
+		TrustedComment:  strings.TrimPrefix(strings.TrimPrefix(lines[2], trustedPrefix), " "),
+		GlobalSignature: global,
+	}
+	copy(sig.KeyID[:], raw[2:10])
+	return sig, nil
+}
+
+// Verify checks that sigData is a valid signature of message made by one of
+// the trusted keys. Both the message signature and the global signature over
+// the trusted comment are verified.
+func Verify(keys []PublicKey, message, sigData []byte) error {
+	sig, err := ParseSignature(sigData)
+	if err != nil {
+		return err
+	}
+	if sig.Algorithm != algEd25519 {
+		return fmt.Errorf("unsupported signature algorithm %q (re-sign with `minisign -S -l` or `phm repo sign`)", sig.Algorithm)
+	}
+
+	for _, key := range keys {
+		if !bytes.Equal(key.ID[:], sig.KeyID[:]) {
+			continue
+		}
+		if !ed25519.Verify(key.Key, message, sig.Signature) {
+			return fmt.Errorf("signature verification failed for key %s", key.KeyID())
+		}
+		global := append(append([]byte{}, sig.Signature...), []byte(sig.TrustedComment)...)
+		if !ed25519.Verify(key.Key, global, sig.GlobalSignature) {
+			return fmt.Errorf("trusted comment verification failed for key %s", key.KeyID())
+		}
+		return nil
+	}
+
+	return fmt.Errorf("signature made by untrusted key %016X", binary.LittleEndian.Uint64(sig.KeyID[:]))
+}
@@ -0,0 +1,172 @@
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// Fixed vector in the format of `minisign -S -l` (legacy, non-prehashed "Ed"
+// signature): ed25519 seed 00 01 .. 1f, key ID 0123456789abcdef, signed with
+// trusted comment "timestamp:1700000000\tfile:index.json". It was computed with
+// an independent RFC 8032 implementation, not with this package.
+const (
+	vectorPublicKey = "untrusted comment: minisign public key EFCDAB8967452301\n" +
+		"RWQBI0VniavN7wOhB7/zzhC+HXDdGOdLwJln5NYwm6UNXx3chmQSVTG4\n"
+	vectorMessage   = "{\"version\":1,\"platforms\":{}}\n"
+	vectorSignature = "untrusted comment: signature from minisign secret key\n" +
+		"RWQBI0VniavN74KcVNbgpEtDxIoXdh+Joplgsr8uFBZCNeLlRABcJZeJ6a4XBCnStOJDx1kDIACZx+fSwsuZHnO05q3TMmI2TQ8=\n" +
+		"trusted comment: timestamp:1700000000\tfile:index.json\n" +
+		"W+fUKHxuhmFezM7JccHZnrPB2vSqp+YYrfpCaEjJB08Pd/Z8RlTJCuyqgzNMBBTr3ltRCcEi7QJRAZj1wokYBA==\n"
+)
+
+// vectorSeed is the ed25519 seed of the vector key
+func vectorSeed() []byte {
+	seed := make([]byte, ed25519.SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	return seed
+}
+
+func vectorKey(t *testing.T) PublicKey {
+	t.Helper()
+	key, err := ParsePublicKey(vectorPublicKey)
+	if err != nil {
+		t.Fatalf("ParsePublicKey: %v", err)
+	}
+	return key
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key := vectorKey(t)
+	if got := key.KeyID(); got != "EFCDAB8967452301" {
+		t.Errorf("KeyID = %s, want EFCDAB8967452301", got)
+	}
+	if !bytes.Equal(key.Key, ed25519.NewKeyFromSeed(vectorSeed()).Public().(ed25519.PublicKey)) {
+		t.Error("public key does not match the seed")
+	}
+
+	// The bare base64 line, as in PHM_TRUSTED_KEYS
+	bare, err := ParsePublicKey(strings.Split(vectorPublicKey, "\n")[1])
+	if err != nil || bare.KeyID() != key.KeyID() || !bytes.Equal(bare.Key, key.Key) {
+		t.Errorf("ParsePublicKey(bare) = %v, %v", bare, err)
+	}
+	if text, _ := key.MarshalText(); string(text) != vectorPublicKey {
+		t.Errorf("MarshalText =\n%s\nwant\n%s", text, vectorPublicKey)
+	}
+
+	for _, bad := range []string{"", "untrusted comment: only\n", "not base64!", "RWQBI0Vn"} {
+		if _, err := ParsePublicKey(bad); err == nil {
+			t.Errorf("ParsePublicKey(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := vectorKey(t)
+	lines := strings.Split(vectorSignature, "\n")
+	sigBlob, _ := base64.StdEncoding.DecodeString(lines[1])
+
+	// withBlob replaces the algorithm, key ID and signature line
+	withBlob := func(blob []byte) string {
+		l := append([]string{}, lines...)
+		l[1] = base64.StdEncoding.EncodeToString(blob)
+		return strings.Join(l, "\n")
+	}
+	prehashed := append([]byte("ED"), sigBlob[2:]...)
+	otherID := append([]byte{}, sigBlob...)
+	otherID[2] ^= 0xff
+
+	otherKey := PublicKey{ID: key.ID, Key: ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public().(ed25519.PublicKey)}
+
+	tests := []struct {
+		name      string
+		keys      []PublicKey
+		message   string
+		signature string
+		wantErr   string // substring of the error, "" if valid
+	}{
+		{name: "valid", keys: []PublicKey{key}, message: vectorMessage, signature: vectorSignature},
+		{name: "valid with other keys trusted", keys: []PublicKey{{ID: [8]byte{1}}, key}, message: vectorMessage, signature: vectorSignature},
+		{name: "CRLF line endings", keys: []PublicKey{key}, message: vectorMessage, signature: strings.ReplaceAll(vectorSignature, "\n", "\r\n")},
+		{
+			name: "tampered message", keys: []PublicKey{key}, message: strings.Replace(vectorMessage, "1", "2", 1), signature: vectorSignature,
+			wantErr: "signature verification failed for key EFCDAB8967452301",
+		},
+		{
+			name: "tampered trusted comment", keys: []PublicKey{key}, message: vectorMessage,
+			signature: strings.Replace(vectorSignature, "file:index.json", "file:other.json", 1),
+			wantErr:   "trusted comment verification failed",
+		},
+		{
+			name: "wrong key ID", keys: []PublicKey{key}, message: vectorMessage, signature: withBlob(otherID),
+			wantErr: "signature made by untrusted key",
+		},
+		{
+			name: "other key with the same ID", keys: []PublicKey{otherKey}, message: vectorMessage, signature: vectorSignature,
+			wantErr: "signature verification failed",
+		},
+		{name: "no trusted keys", message: vectorMessage, signature: vectorSignature, wantErr: "untrusted key EFCDAB8967452301"},
+		{
+			name: "prehashed", keys: []PublicKey{key}, message: vectorMessage, signature: withBlob(prehashed),
+			wantErr: `unsupported signature algorithm "ED"`,
+		},
+		{name: "truncated", keys: []PublicKey{key}, message: vectorMessage, signature: strings.Join(lines[:2], "\n"), wantErr: "expected 4 lines"},
+		{
+			name: "missing trusted comment", keys: []PublicKey{key}, message: vectorMessage,
+			signature: strings.Replace(vectorSignature, "\ntrusted comment: ", "\ncomment: ", 1),
+			wantErr:   "missing trusted comment",
+		},
+		{name: "short signature", keys: []PublicKey{key}, message: vectorMessage, signature: withBlob(sigBlob[:40]), wantErr: "invalid signature length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.keys, []byte(tt.message), []byte(tt.signature))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Verify = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	key := vectorKey(t)
+	secret := SecretKey{ID: key.ID, Key: ed25519.NewKeyFromSeed(vectorSeed())}
+
+	// ed25519 is deterministic: Sign reproduces the vector, apart from the
+	// untrusted comment
+	sig := Sign(secret, []byte(vectorMessage), "timestamp:1700000000\tfile:index.json")
+	got := strings.SplitN(string(sig), "\n", 2)
+	want := strings.SplitN(vectorSignature, "\n", 2)
+	if got[1] != want[1] {
+		t.Errorf("Sign =\n%s\nwant\n%s", got[1], want[1])
+	}
+
+	// The secret key file round-trips
+	text, _ := secret.MarshalText()
+	parsed, err := ParseSecretKey(string(text))
+	if err != nil {
+		t.Fatalf("ParseSecretKey: %v", err)
+	}
+	if parsed.ID != secret.ID || !bytes.Equal(parsed.Key, secret.Key) {
+		t.Error("secret key changed in the round trip")
+	}
+
+	// A default trusted comment is a timestamp
+	sig = Sign(secret, []byte("other"), "")
+	if err := Verify([]PublicKey{key}, []byte("other"), sig); err != nil {
+		t.Errorf("Verify(Sign): %v", err)
+	}
+	if parsedSig, err := ParseSignature(sig); err != nil || !strings.HasPrefix(parsedSig.TrustedComment, "timestamp:") {
+		t.Errorf("trusted comment = %v, %v; want timestamp", parsedSig, err)
+	}
+}
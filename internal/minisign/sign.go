@@ -0,0 +1,114 @@
+package minisign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SecretKey is an unencrypted ed25519 signing key.
+//
+// minisign's own secret key files are scrypt-encrypted with a BLAKE2b checksum,
+// which the standard library can't read, so PHM stores keys as
+// base64("Ed" || key id || ed25519 private key). Public keys and signatures are
+// fully minisign-compatible and can be checked with `minisign -V`.
+type SecretKey struct {
+	ID  [8]byte
+	Key ed25519.PrivateKey
+}
+
+// GenerateKey creates a new random key pair
+func GenerateKey() (PublicKey, SecretKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return PublicKey{}, SecretKey{}, err
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return PublicKey{}, SecretKey{}, err
+	}
+
+	return PublicKey{ID: id, Key: pub}, SecretKey{ID: id, Key: priv}, nil
+}
+
+// Public returns the public half of the key
+func (k SecretKey) Public() PublicKey {
+	return PublicKey{ID: k.ID, Key: k.Key.Public().(ed25519.PublicKey)}
+}
+
+// String encodes the public key as the base64 line used in .pub files and PHM_TRUSTED_KEYS
+func (k PublicKey) String() string {
+	raw := append([]byte(algEd25519), k.ID[:]...)
+	raw = append(raw, k.Key...)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// MarshalText returns the contents of a minisign .pub file
+func (k PublicKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s minisign public key %s\n%s\n", untrustedPrefix, k.KeyID(), k.String())), nil
+}
+
+// MarshalText returns the contents of a PHM secret key file
+func (k SecretKey) MarshalText() ([]byte, error) {
+	raw := append([]byte(algEd25519), k.ID[:]...)
+	raw = append(raw, k.Key...)
+	return []byte(fmt.Sprintf("%s phm secret key %s\n%s\n", untrustedPrefix, k.Public().KeyID(), base64.StdEncoding.EncodeToString(raw))), nil
+}
+
+// ParseSecretKey parses a key file written by SecretKey.MarshalText
+func ParseSecretKey(s string) (SecretKey, error) {
+	var sk SecretKey
+
+	line := ""
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, untrustedPrefix) {
+			continue
+		}
+		line = l
+		break
+	}
+	if line == "" {
+		return sk, fmt.Errorf("empty secret key")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return sk, fmt.Errorf("invalid secret key encoding: %w", err)
+	}
+	if len(raw) != 2+8+ed25519.PrivateKeySize {
+		return sk, fmt.Errorf("unsupported secret key (encrypted minisign keys are not supported, create one with `phm repo keygen`)")
+	}
+	if string(raw[:2]) != algEd25519 {
+		return sk, fmt.Errorf("unsupported secret key algorithm %q", raw[:2])
+	}
+
+	copy(sk.ID[:], raw[2:10])
+	sk.Key = ed25519.PrivateKey(raw[10:])
+	return sk, nil
+}
+
+// Sign creates a detached signature (.minisig contents) for message.
+// An empty trusted comment defaults to the current timestamp, like minisign.
+func Sign(key SecretKey, message []byte, trustedComment string) []byte {
+	if trustedComment == "" {
+		trustedComment = fmt.Sprintf("timestamp:%d", time.Now().Unix())
+	}
+
+	sig := ed25519.Sign(key.Key, message)
+	global := ed25519.Sign(key.Key, append(append([]byte{}, sig...), []byte(trustedComment)...))
+
+	raw := append([]byte(algEd25519), key.ID[:]...)
+	raw = append(raw, sig...)
+
+	return []byte(fmt.Sprintf("%s signature from phm secret key\n%s\n%s %s\n%s\n",
+		untrustedPrefix,
+		base64.StdEncoding.EncodeToString(raw),
+		trustedPrefix, trustedComment,
+		base64.StdEncoding.EncodeToString(global),
+	))
+}
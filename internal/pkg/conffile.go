@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+// conffileSuffix marks a new upstream version of a locally modified config file
+const conffileSuffix = ".phmnew"
+
+// conffileTarget decides where a shipped config file is written, Debian style.
+// newSum is the checksum of the new version, oldSum the pristine checksum of the
+// version shipped by the installed package ("" if unknown). Returns path to
+// install it, path+".phmnew" to keep local changes next to the new version, or
+// "" when nothing needs to be written.
+func conffileTarget(path, newSum, oldSum string) string {
+	current, err := copyAndHash(io.Discard, path)
+	switch {
+	case os.IsNotExist(err):
+		return path
+	case err == nil && current == newSum:
+		return "" // already up to date
+	case err == nil && oldSum != "" && current == oldSum:
+		return path // not modified locally: upgrade it
+	case oldSum != "" && newSum == oldSum:
+		return "" // unchanged upstream: keep local changes
+	default:
+		return path + conffileSuffix
+	}
+}
+
+// Conffile is a locally modified config file with a pending new version
+type Conffile struct {
+	Package string `json:"package"`
+	Path    string `json:"path"`
+	New     string `json:"new"` // the new upstream version (path + ".phmnew")
+}
+
+// PendingConffiles returns config files of installed packages that have a
+// .phmnew version waiting to be reviewed, sorted by path
+func (m *Manager) PendingConffiles() []Conffile {
+	var result []Conffile
+	for _, p := range m.installed {
+		for _, f := range p.Files {
+			if !f.Config {
+				continue
+			}
+			if newFile := f.Path + conffileSuffix; fileExists(newFile) {
+				result = append(result, Conffile{Package: p.Name, Path: f.Path, New: newFile})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// ResolveConffile resolves a pending config file: with useNew the new upstream
+// version replaces the local file, otherwise the local file is kept. Either way
+// the .phmnew file is gone afterwards.
+func (m *Manager) ResolveConffile(c Conffile, useNew bool) error {
+	if !useNew {
+		return m.fs.Remove(c.New)
+	}
+	return m.fs.Rename(c.New, c.Path)
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
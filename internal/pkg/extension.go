@@ -3,31 +3,43 @@ package pkg
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"github.com/phm-dev/phm/internal/privfs"
 )
 
 // ExtensionManager handles PHP extension management
 type ExtensionManager struct {
 	installPrefix string
+	fs            privfs.FS
 }
 
 // ExtensionStatus represents the status of an extension
 type ExtensionStatus struct {
-	Name    string
-	Enabled bool
-	IniFile string
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	IniFile string `json:"ini_file,omitempty"`
 }
 
+// overridesIni is the conf.d file holding php.ini settings of phm apply. It is
+// read last, so its settings win, and is not an extension.
+const overridesIni = "99-phm-overrides.ini"
+
 // NewExtensionManager creates a new extension manager
 func NewExtensionManager(installPrefix string) *ExtensionManager {
 	return &ExtensionManager{
 		installPrefix: installPrefix,
+		fs:            privfs.Sudo{},
 	}
 }
 
+// SetFS sets how ini files are changed (default: directly, falling back to sudo)
+func (e *ExtensionManager) SetFS(fs privfs.FS) {
+	e.fs = fs
+}
+
 // getConfDir returns the conf.d directory for a PHP version
 // PHP scans this directory for additional ini files
 func (e *ExtensionManager) getConfDir(version string) string {
@@ -69,7 +81,7 @@ func (e *ExtensionManager) ListExtensions(version string) ([]ExtensionStatus, er
 	enabledExts := make(map[string]string) // extension name -> ini file
 	if entries, err := os.ReadDir(confDir); err == nil {
 		for _, entry := range entries {
-			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".ini") {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".ini") && entry.Name() != overridesIni {
 				extName := e.extractExtensionName(entry.Name())
 				enabledExts[extName] = entry.Name()
 			}
@@ -140,11 +152,8 @@ func (e *ExtensionManager) Enable(version, extension, sapi string) error {
 	}
 
 	// Ensure conf.d directory exists
-	if err := os.MkdirAll(confDir, 0755); err != nil {
-		cmd := exec.Command("sudo", "mkdir", "-p", confDir)
-		if runErr := cmd.Run(); runErr != nil {
-			return fmt.Errorf("failed to create config dir: %w", runErr)
-		}
+	if err := e.fs.MkdirAll(confDir); err != nil {
+		return fmt.Errorf("failed to create config dir: %w", err)
 	}
 
 	// Check if already enabled
@@ -162,18 +171,8 @@ func (e *ExtensionManager) Enable(version, extension, sapi string) error {
 		content = fmt.Sprintf("extension=%s.so\n", extension)
 	}
 
-	if err := os.WriteFile(iniPath, []byte(content), 0644); err != nil {
-		// Try with sudo
-		tmpFile := filepath.Join(os.TempDir(), iniFile)
-		if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
-			return fmt.Errorf("failed to create ini file: %w", err)
-		}
-		cmd := exec.Command("sudo", "cp", tmpFile, iniPath)
-		if err := cmd.Run(); err != nil {
-			os.Remove(tmpFile)
-			return fmt.Errorf("failed to enable %s: %w", extension, err)
-		}
-		os.Remove(tmpFile)
+	if err := e.fs.WriteFile(iniPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", extension, err)
 	}
 
 	return nil
@@ -209,13 +208,69 @@ func (e *ExtensionManager) Disable(version, extension, sapi string) error {
 	}
 
 	// Remove ini file
-	if err := os.Remove(iniPath); err != nil {
-		cmd := exec.Command("sudo", "rm", "-f", iniPath)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to disable %s: %w", extension, err)
+	if err := e.fs.Remove(iniPath); err != nil {
+		return fmt.Errorf("failed to disable %s: %w", extension, err)
+	}
+
+	return nil
+}
+
+// IniOverrides returns the php.ini settings written by SetIniOverrides
+func (e *ExtensionManager) IniOverrides(version string) (map[string]string, error) {
+	settings := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(e.getConfDir(version), overridesIni))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
 		}
+		return nil, err
 	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return settings, nil
+}
+
+// SetIniOverrides writes php.ini settings for all SAPIs of a PHP version.
+// Without settings the overrides file is removed.
+func (e *ExtensionManager) SetIniOverrides(version string, settings map[string]string) error {
+	confDir := e.getConfDir(version)
+	iniPath := filepath.Join(confDir, overridesIni)
 
+	if len(settings) == 0 {
+		if _, err := os.Stat(iniPath); os.IsNotExist(err) {
+			return nil
+		}
+		if err := e.fs.Remove(iniPath); err != nil {
+			return fmt.Errorf("failed to remove php.ini overrides: %w", err)
+		}
+		return nil
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("; Managed by phm apply, changes are overwritten\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", key, settings[key])
+	}
+
+	if err := e.fs.MkdirAll(confDir); err != nil {
+		return fmt.Errorf("failed to create config dir: %w", err)
+	}
+	if err := e.fs.WriteFile(iniPath, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write php.ini overrides: %w", err)
+	}
 	return nil
 }
 
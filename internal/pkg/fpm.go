@@ -1,38 +1,93 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/phm-dev/phm/internal/privfs"
 )
 
 // FPMManager manages PHP-FPM services
 type FPMManager struct {
 	installPrefix string
+	layout        Layout    // service, socket and log locations (see SystemLayout and UserLayout)
+	fs            privfs.FS // runs launchctl and changes service files
 }
 
 // FPMStatus represents the status of a PHP-FPM service
 type FPMStatus struct {
-	Version string
-	Running bool
-	PID     int
-	Socket  string
-	Enabled bool
+	Version string `json:"version"`
+	Running bool   `json:"running"`
+	PID     int    `json:"pid,omitempty"`
+	Socket  string `json:"socket"`
+	Enabled bool   `json:"enabled"`
 }
 
-// NewFPMManager creates a new FPM manager
-func NewFPMManager(installPrefix string) *FPMManager {
+// NewFPMManager creates a new FPM manager. Service files, sockets and PID
+// files are located by layout. In user mode services are launchd user agents
+// controlled without sudo.
+func NewFPMManager(installPrefix string, layout Layout) *FPMManager {
 	return &FPMManager{
 		installPrefix: installPrefix,
+		layout:        layout,
+		fs:            privfs.Sudo{},
+	}
+}
+
+// SetFS sets how services are controlled (default: through sudo)
+func (f *FPMManager) SetFS(fs privfs.FS) {
+	f.fs = fs
+}
+
+// checkHost refuses to control launchd services for a relocated root
+func (f *FPMManager) checkHost() error {
+	if f.layout.Root != "" {
+		return fmt.Errorf("PHP-FPM services cannot be controlled inside a relocated root (%s)", f.layout.Root)
+	}
+	return nil
+}
+
+// run runs a command controlling services with the privileges of the FS
+func (f *FPMManager) run(name string, args ...string) error {
+	return f.fs.Command(context.Background(), nil, name, args...).Run()
+}
+
+// launchDomain returns the launchd domain services are bootstrapped into
+func (f *FPMManager) launchDomain() string {
+	if f.layout.User {
+		return fmt.Sprintf("gui/%d", os.Getuid())
 	}
+	return "system"
+}
+
+// GetRunDir returns the directory for sockets and PID files
+func (f *FPMManager) GetRunDir() string {
+	return f.layout.RunDir
+}
+
+// GetLogDir returns the directory for PHP-FPM logs
+func (f *FPMManager) GetLogDir() string {
+	return f.layout.LogDir
+}
+
+// GetLaunchDir returns the directory with launchd service definitions
+// (LaunchDaemons, or LaunchAgents in user mode)
+func (f *FPMManager) GetLaunchDir() string {
+	return f.layout.LaunchDir
 }
 
 // EnsureSudo prompts for sudo password if needed and caches credentials
-// Returns true if sudo is available, false otherwise
+// Returns true if sudo is available (or not needed, see SetFS), false otherwise
 func (f *FPMManager) EnsureSudo() bool {
+	if _, ok := f.fs.(privfs.Sudo); !ok {
+		return true
+	}
+
 	// Clear terminal and show message
 	fmt.Print("\033[2J\033[H") // Clear screen and move cursor to top
 	fmt.Println("PHP-FPM Management requires administrator privileges.")
@@ -61,17 +116,17 @@ func (f *FPMManager) GetServiceName(version string) string {
 
 // GetPlistPath returns the path to the launchd plist
 func (f *FPMManager) GetPlistPath(version string) string {
-	return fmt.Sprintf("/Library/LaunchDaemons/com.phm.php%s-fpm.plist", version)
+	return filepath.Join(f.GetLaunchDir(), f.GetServiceName(version)+".plist")
 }
 
 // GetSocketPath returns the socket path for a PHP version
 func (f *FPMManager) GetSocketPath(version string) string {
-	return fmt.Sprintf("/var/run/php/php%s-fpm.sock", version)
+	return filepath.Join(f.GetRunDir(), fmt.Sprintf("php%s-fpm.sock", version))
 }
 
 // GetPIDPath returns the PID file path for a PHP version
 func (f *FPMManager) GetPIDPath(version string) string {
-	return fmt.Sprintf("/var/run/php/php%s-fpm.pid", version)
+	return filepath.Join(f.GetRunDir(), fmt.Sprintf("php%s-fpm.pid", version))
 }
 
 // IsInstalled checks if PHP-FPM is installed for a version
@@ -137,6 +192,9 @@ func (f *FPMManager) IsEnabled(version string) bool {
 
 // Start starts PHP-FPM for a version
 func (f *FPMManager) Start(version string) error {
+	if err := f.checkHost(); err != nil {
+		return err
+	}
 	if !f.IsInstalled(version) {
 		return fmt.Errorf("PHP-FPM %s is not installed", version)
 	}
@@ -146,28 +204,25 @@ func (f *FPMManager) Start(version string) error {
 	}
 
 	// Ensure run directory exists
-	runDir := "/var/run/php"
-	cmd := exec.Command("sudo", "mkdir", "-p", runDir)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to create run dir %s: %w", runDir, err)
+	for _, dir := range []string{f.GetRunDir(), f.GetLogDir()} {
+		if err := f.fs.MkdirAll(dir); err != nil {
+			return err
+		}
 	}
-	cmd = exec.Command("sudo", "chmod", "755", runDir)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to set permissions on %s: %w", runDir, err)
+	if err := f.fs.Chmod(f.GetRunDir(), 0755); err != nil {
+		return err
 	}
 
 	// Load and start the service
 	plistPath := f.GetPlistPath(version)
 	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
-		return fmt.Errorf("LaunchDaemon plist not found: %s", plistPath)
+		return fmt.Errorf("launchd plist not found: %s", plistPath)
 	}
 
 	// Bootstrap the service (macOS 10.10+)
-	cmd = exec.Command("sudo", "launchctl", "bootstrap", "system", plistPath)
-	if err := cmd.Run(); err != nil {
+	if err := f.run("launchctl", "bootstrap", f.launchDomain(), plistPath); err != nil {
 		// Try legacy load command
-		cmd = exec.Command("sudo", "launchctl", "load", plistPath)
-		if err := cmd.Run(); err != nil {
+		if err := f.run("launchctl", "load", plistPath); err != nil {
 			return fmt.Errorf("failed to start service: %w", err)
 		}
 	}
@@ -177,6 +232,9 @@ func (f *FPMManager) Start(version string) error {
 
 // Stop stops PHP-FPM for a version
 func (f *FPMManager) Stop(version string) error {
+	if err := f.checkHost(); err != nil {
+		return err
+	}
 	if !f.IsRunning(version) {
 		return fmt.Errorf("PHP-FPM %s is not running", version)
 	}
@@ -185,11 +243,9 @@ func (f *FPMManager) Stop(version string) error {
 	plistPath := f.GetPlistPath(version)
 
 	// Bootout the service (macOS 10.10+)
-	cmd := exec.Command("sudo", "launchctl", "bootout", "system/"+serviceName)
-	if err := cmd.Run(); err != nil {
+	if err := f.run("launchctl", "bootout", f.launchDomain()+"/"+serviceName); err != nil {
 		// Try legacy unload command
-		cmd = exec.Command("sudo", "launchctl", "unload", plistPath)
-		if err := cmd.Run(); err != nil {
+		if err := f.run("launchctl", "unload", plistPath); err != nil {
 			return fmt.Errorf("failed to stop service: %w", err)
 		}
 	}
@@ -209,6 +265,9 @@ func (f *FPMManager) Restart(version string) error {
 
 // Reload reloads PHP-FPM configuration
 func (f *FPMManager) Reload(version string) error {
+	if err := f.checkHost(); err != nil {
+		return err
+	}
 	if !f.IsRunning(version) {
 		return fmt.Errorf("PHP-FPM %s is not running", version)
 	}
@@ -218,8 +277,7 @@ func (f *FPMManager) Reload(version string) error {
 		return fmt.Errorf("could not find PHP-FPM PID")
 	}
 
-	cmd := exec.Command("sudo", "kill", "-USR2", fmt.Sprintf("%d", pid))
-	return cmd.Run()
+	return f.run("kill", "-USR2", fmt.Sprintf("%d", pid))
 }
 
 // Enable enables PHP-FPM to start at boot
@@ -227,12 +285,11 @@ func (f *FPMManager) Enable(version string) error {
 	plistPath := f.GetPlistPath(version)
 
 	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
-		return fmt.Errorf("LaunchDaemon plist not found: %s", plistPath)
+		return fmt.Errorf("launchd plist not found: %s", plistPath)
 	}
 
 	// Modify plist to set RunAtLoad to true
-	cmd := exec.Command("sudo", "/usr/libexec/PlistBuddy", "-c", "Set :RunAtLoad true", plistPath)
-	if err := cmd.Run(); err != nil {
+	if err := f.run("/usr/libexec/PlistBuddy", "-c", "Set :RunAtLoad true", plistPath); err != nil {
 		return fmt.Errorf("failed to enable service: %w", err)
 	}
 
@@ -244,12 +301,11 @@ func (f *FPMManager) Disable(version string) error {
 	plistPath := f.GetPlistPath(version)
 
 	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
-		return fmt.Errorf("LaunchDaemon plist not found: %s", plistPath)
+		return fmt.Errorf("launchd plist not found: %s", plistPath)
 	}
 
 	// Modify plist to set RunAtLoad to false
-	cmd := exec.Command("sudo", "/usr/libexec/PlistBuddy", "-c", "Set :RunAtLoad false", plistPath)
-	if err := cmd.Run(); err != nil {
+	if err := f.run("/usr/libexec/PlistBuddy", "-c", "Set :RunAtLoad false", plistPath); err != nil {
 		return fmt.Errorf("failed to disable service: %w", err)
 	}
 
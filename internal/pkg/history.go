@@ -0,0 +1,211 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// historyFileName is the file under DataDir that logs every transaction
+const historyFileName = "history.jsonl"
+
+// Transaction results recorded in the history
+const (
+	HistoryOK         = "ok"          // committed
+	HistoryRecovered  = "recovered"   // interrupted, then rolled forward
+	HistoryRolledBack = "rolled back" // failed or interrupted, then rolled back
+)
+
+// HistoryVersion is the installed build of a package before or after a transaction
+type HistoryVersion struct {
+	Version     string `json:"version"`
+	Revision    int    `json:"revision"`
+	Repo        string `json:"repo,omitempty"`
+	InstallSlot string `json:"install_slot,omitempty"`
+	Pinned      bool   `json:"pinned,omitempty"`
+	SHA256      string `json:"sha256,omitempty"` // checksum of the package tarball, when known
+}
+
+// String returns version-revision
+func (v *HistoryVersion) String() string {
+	return fmt.Sprintf("%s-%d", v.Version, v.Revision)
+}
+
+// Same reports whether v and other are the same build (both nil: not installed)
+func (v *HistoryVersion) Same(other *HistoryVersion) bool {
+	if v == nil || other == nil {
+		return v == nil && other == nil
+	}
+	return v.Version == other.Version && v.Revision == other.Revision
+}
+
+// HistoryChange is what a transaction did to one package. Old is nil for new
+// installs, New is nil for removals.
+type HistoryChange struct {
+	Name string          `json:"name"`
+	Old  *HistoryVersion `json:"old,omitempty"`
+	New  *HistoryVersion `json:"new,omitempty"`
+}
+
+// HistoryEntry records one transaction
+type HistoryEntry struct {
+	ID        int             `json:"id"`
+	Time      time.Time       `json:"time"`
+	Command   string          `json:"command,omitempty"`
+	User      string          `json:"user,omitempty"`
+	Operation string          `json:"operation"`
+	Changes   []HistoryChange `json:"changes"`
+	Result    string          `json:"result"`
+}
+
+// Undoable reports whether the transaction changed anything that can be reversed
+func (e *HistoryEntry) Undoable() bool {
+	if e.Result != HistoryOK && e.Result != HistoryRecovered {
+		return false
+	}
+	for _, c := range e.Changes {
+		if !c.Old.Same(c.New) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCommand sets the command line recorded in the history of the following transactions
+func (m *Manager) SetCommand(command string) {
+	m.command = command
+}
+
+// historyPath returns the path of the history log
+func (m *Manager) historyPath() string {
+	return filepath.Join(m.dataDir, historyFileName)
+}
+
+// History returns the logged transactions, oldest first
+func (m *Manager) History() ([]HistoryEntry, error) {
+	f, err := os.Open(m.historyPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A torn line (crash while appending) is skipped
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return entries, nil
+}
+
+// HistoryEntry returns the transaction with the given ID
+func (m *Manager) HistoryEntry(id int) (*HistoryEntry, error) {
+	entries, err := m.History()
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].ID == id {
+			return &entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no transaction %d in the history", id)
+}
+
+// historyVersion returns the installed build of a package, nil if it is not installed
+func (m *Manager) historyVersion(name string) *HistoryVersion {
+	p := m.installed[name]
+	if p == nil {
+		return nil
+	}
+	return &HistoryVersion{
+		Version:     p.Version,
+		Revision:    p.Revision,
+		Repo:        p.Repo,
+		InstallSlot: p.InstallSlot,
+		Pinned:      p.Pinned,
+		SHA256:      p.SHA256,
+	}
+}
+
+// snapshot returns the installed builds of the packages actions will change
+func (m *Manager) snapshot(actions []TransactionAction) map[string]*HistoryVersion {
+	previous := make(map[string]*HistoryVersion)
+	for _, a := range actions {
+		if v := m.historyVersion(a.Name); v != nil {
+			previous[a.Name] = v
+		}
+	}
+	return previous
+}
+
+// recordHistory appends a finished transaction to the history log. The history
+// is informational: failing to write it does not undo the transaction.
+func (m *Manager) recordHistory(info TransactionInfo, result string) {
+	if len(info.Actions) == 0 {
+		return
+	}
+
+	entry := HistoryEntry{
+		Time:      info.StartedAt,
+		Command:   info.Command,
+		User:      info.User,
+		Operation: info.Operation,
+		Changes:   []HistoryChange{},
+		Result:    result,
+	}
+	seen := make(map[string]bool)
+	for _, a := range info.Actions {
+		if seen[a.Name] {
+			continue
+		}
+		seen[a.Name] = true
+
+		change := HistoryChange{Name: a.Name, Old: info.Previous[a.Name], New: m.historyVersion(a.Name)}
+		if change.Old == nil && change.New == nil {
+			continue
+		}
+		// Keep the tarball checksum, so undo can verify a cached copy later
+		if change.New != nil && a.Kind == ActionInstall && a.Path != "" {
+			if sum, err := copyAndHash(io.Discard, a.Path); err == nil {
+				change.New.SHA256 = sum
+			}
+		}
+		entry.Changes = append(entry.Changes, change)
+	}
+
+	entries, err := m.History()
+	if err != nil {
+		return
+	}
+	entry.ID = 1
+	if len(entries) > 0 {
+		entry.ID = entries[len(entries)-1].ID + 1
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(m.historyPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	_, _ = f.Write(append(line, '\n'))
+	f.Close()
+}
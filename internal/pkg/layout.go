@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// PackagePrefix is the install prefix package tarballs are built for
+const PackagePrefix = "/opt/php"
+
+// packageLaunchDir is where packages ship launchd service definitions
+const packageLaunchDir = "/Library/LaunchDaemons"
+
+// Layout describes where PHM installs and links files outside the install
+// prefix. Package paths under PackagePrefix are installed to the install prefix
+// and launchd definitions to LaunchDir; other paths are relocated into Root.
+type Layout struct {
+	Root           string // relocated root ("" for /, see --root)
+	User           bool   // rootless per-user mode: no sudo, launchd user agents
+	SystemBinDir   string // shared bin directory for `phm use --system` ("" if not available)
+	SymfonyBinDir  string // MacPorts-style links for Symfony CLI ("" to skip)
+	SymfonySbinDir string
+	LaunchDir      string // LaunchDaemons, or LaunchAgents in user mode
+	RunDir         string // PHP-FPM sockets and PID files
+	LogDir         string // PHP-FPM logs
+}
+
+// SystemLayout returns the default system-wide layout, relocated into root
+func SystemLayout(root string) Layout {
+	rooted := func(path string) string {
+		return filepath.Join("/", root, path)
+	}
+	return Layout{
+		Root:           root,
+		SystemBinDir:   rooted("/usr/local/bin"),
+		SymfonyBinDir:  rooted("/opt/local/bin"),
+		SymfonySbinDir: rooted("/opt/local/sbin"),
+		LaunchDir:      rooted(packageLaunchDir),
+		RunDir:         rooted("/var/run/php"),
+		LogDir:         rooted("/var/log"),
+	}
+}
+
+// UserLayout returns the rootless layout for a user. home must already be
+// relocated into root. Nothing is linked into shared directories.
+func UserLayout(root, home string) Layout {
+	return Layout{
+		Root:      root,
+		User:      true,
+		LaunchDir: filepath.Join(home, "Library", "LaunchAgents"),
+		RunDir:    filepath.Join(home, ".local", "phm", "run"),
+		LogDir:    filepath.Join(home, ".local", "phm", "log"),
+	}
+}
+
+// unroot returns path as seen from inside Root
+func (l Layout) unroot(path string) string {
+	if l.Root == "" {
+		return path
+	}
+	return filepath.Join("/", strings.TrimPrefix(path, l.Root))
+}
+
+// reroot returns an absolute path seen from inside Root as a path on the host
+// (the inverse of unroot). Relative paths are returned unchanged.
+func (l Layout) reroot(path string) string {
+	if l.Root == "" || !filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(l.Root, path)
+}
+
+// SetLayout sets where package files outside the install prefix go, and how
+// package paths are relocated (see Layout)
+func (m *Manager) SetLayout(layout Layout) {
+	m.layout = layout
+}
+
+// hasPathPrefix reports whether path is dir or inside it
+func hasPathPrefix(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// installPath maps a path from a package tarball to where it is installed
+func (m *Manager) installPath(path string) string {
+	path = filepath.Join("/", path)
+	switch {
+	case hasPathPrefix(path, PackagePrefix):
+		return filepath.Join(m.installPrefix, strings.TrimPrefix(path, PackagePrefix))
+	case hasPathPrefix(path, packageLaunchDir) && m.layout.LaunchDir != "":
+		return filepath.Join(m.layout.LaunchDir, strings.TrimPrefix(path, packageLaunchDir))
+	}
+	return filepath.Join("/", m.layout.Root, path)
+}
@@ -3,18 +3,21 @@ package pkg
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/phm-dev/phm/internal/privfs"
 )
 
 // Linker handles PHP version linking and symlinks
 type Linker struct {
 	installPrefix  string // /opt/php
 	phmBinDir      string // /opt/php/bin (PHM-managed, safe)
-	systemBinDir   string // /usr/local/bin (shared with Homebrew)
-	symfonyBinDir  string // /opt/local/bin (Symfony CLI MacPorts detection)
-	symfonySbinDir string // /opt/local/sbin (Symfony CLI MacPorts detection)
+	systemBinDir   string // /usr/local/bin (shared with Homebrew), "" in user mode
+	symfonyBinDir  string // /opt/local/bin (Symfony CLI MacPorts detection), "" to skip
+	symfonySbinDir string // /opt/local/sbin (Symfony CLI MacPorts detection), "" to skip
+	layout         Layout // link targets are written as seen from inside layout.Root
+	fs             privfs.FS
 }
 
 // HomebrewConflict represents a detected Homebrew conflict
@@ -24,17 +27,25 @@ type HomebrewConflict struct {
 	Target string // e.g., "/usr/local/opt/php@8.3/bin/php"
 }
 
-// NewLinker creates a new linker
-func NewLinker(installPrefix string) *Linker {
+// NewLinker creates a new linker. Shared directories are taken from layout
+// (see SystemLayout and UserLayout).
+func NewLinker(installPrefix string, layout Layout) *Linker {
 	return &Linker{
 		installPrefix:  installPrefix,
 		phmBinDir:      filepath.Join(installPrefix, "bin"),
-		systemBinDir:   "/usr/local/bin",
-		symfonyBinDir:  "/opt/local/bin",
-		symfonySbinDir: "/opt/local/sbin",
+		systemBinDir:   layout.SystemBinDir,
+		symfonyBinDir:  layout.SymfonyBinDir,
+		symfonySbinDir: layout.SymfonySbinDir,
+		layout:         layout,
+		fs:             privfs.Sudo{},
 	}
 }
 
+// SetFS sets how links are created (default: directly, falling back to sudo)
+func (l *Linker) SetFS(fs privfs.FS) {
+	l.fs = fs
+}
+
 // GetPHMBinDir returns the PHM bin directory path
 func (l *Linker) GetPHMBinDir() string {
 	return l.phmBinDir
@@ -64,11 +75,8 @@ func (l *Linker) SetupVersionLinks(version string) error {
 	}
 
 	// Ensure PHM bin directory exists
-	if err := os.MkdirAll(l.phmBinDir, 0755); err != nil {
-		cmd := exec.Command("sudo", "mkdir", "-p", l.phmBinDir)
-		if runErr := cmd.Run(); runErr != nil {
-			return fmt.Errorf("failed to create PHM bin dir %s: %v: %w", l.phmBinDir, err, runErr)
-		}
+	if err := l.fs.MkdirAll(l.phmBinDir); err != nil {
+		return err
 	}
 
 	// Create version-specific symlinks in /opt/php/bin (e.g., php8.5, phpize8.5)
@@ -93,18 +101,44 @@ func (l *Linker) SetupVersionLinks(version string) error {
 	return nil
 }
 
+// VersionLinks returns the links SetupVersionLinks manages for a version (link ->
+// source), whether the sources exist yet or not
+func (l *Linker) VersionLinks(version string) map[string]string {
+	links := make(map[string]string)
+	for _, binary := range l.getBinaries() {
+		links[filepath.Join(l.phmBinDir, binary+version)] = l.getSourcePath(version, binary)
+	}
+	if l.symfonyBinDir != "" && l.symfonySbinDir != "" {
+		macportsVersion := strings.ReplaceAll(version, ".", "")
+		links[filepath.Join(l.symfonyBinDir, "php"+macportsVersion)] = l.getSourcePath(version, "php")
+		links[filepath.Join(l.symfonySbinDir, "php-fpm"+macportsVersion)] = l.getSourcePath(version, "php-fpm")
+	}
+	return links
+}
+
+// DefaultLinks returns the links SetDefaultVersion manages for a version (link ->
+// source), whether the sources exist yet or not
+func (l *Linker) DefaultLinks(version string) map[string]string {
+	links := make(map[string]string)
+	for _, binary := range l.getBinaries() {
+		links[filepath.Join(l.phmBinDir, binary)] = l.getSourcePath(version, binary)
+	}
+	return links
+}
+
 // setupSymfonyLinksInternal creates symlinks in /opt/local for Symfony CLI MacPorts-style detection
 func (l *Linker) setupSymfonyLinksInternal(version string) error {
+	if l.symfonyBinDir == "" || l.symfonySbinDir == "" {
+		return nil
+	}
+
 	// Convert version "8.5" to MacPorts style "85"
 	macportsVersion := strings.ReplaceAll(version, ".", "")
 
 	// Ensure directories exist
 	for _, dir := range []string{l.symfonyBinDir, l.symfonySbinDir} {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			cmd := exec.Command("sudo", "mkdir", "-p", dir)
-			if runErr := cmd.Run(); runErr != nil {
-				return fmt.Errorf("failed to create Symfony dir %s: %v: %w", dir, err, runErr)
-			}
+		if err := l.fs.MkdirAll(dir); err != nil {
+			return err
 		}
 	}
 
@@ -131,6 +165,10 @@ func (l *Linker) setupSymfonyLinksInternal(version string) error {
 
 // removeSymfonyLinksInternal removes Symfony CLI symlinks for a PHP version
 func (l *Linker) removeSymfonyLinksInternal(version string) error {
+	if l.symfonyBinDir == "" || l.symfonySbinDir == "" {
+		return nil
+	}
+
 	macportsVersion := strings.ReplaceAll(version, ".", "")
 
 	// Remove /opt/local/bin/php85
@@ -158,11 +196,8 @@ func (l *Linker) SetDefaultVersion(version string) error {
 	}
 
 	// Ensure PHM bin directory exists
-	if err := os.MkdirAll(l.phmBinDir, 0755); err != nil {
-		cmd := exec.Command("sudo", "mkdir", "-p", l.phmBinDir)
-		if runErr := cmd.Run(); runErr != nil {
-			return fmt.Errorf("failed to create PHM bin dir %s: %v: %w", l.phmBinDir, err, runErr)
-		}
+	if err := l.fs.MkdirAll(l.phmBinDir); err != nil {
+		return err
 	}
 
 	// Create default symlinks in /opt/php/bin (e.g., php, phpize)
@@ -181,18 +216,15 @@ func (l *Linker) SetDefaultVersion(version string) error {
 
 	// Save current version to a file
 	versionFile := filepath.Join(l.installPrefix, ".current")
-	if err := os.WriteFile(versionFile, []byte(version), 0644); err != nil {
-		cmd := exec.Command("sudo", "sh", "-c", fmt.Sprintf("echo '%s' > %s", version, versionFile))
-		if runErr := cmd.Run(); runErr != nil {
-			return fmt.Errorf("failed to write version file %s: %v: %w", versionFile, err, runErr)
-		}
-	}
-
-	return nil
+	return l.fs.WriteFile(versionFile, []byte(version), 0644)
 }
 
 // SetSystemDefault creates symlinks in /usr/local/bin (use with caution - may conflict with Homebrew)
 func (l *Linker) SetSystemDefault(version string) error {
+	if l.systemBinDir == "" {
+		return fmt.Errorf("system-wide links are not available in user mode, add %s to your PATH instead", l.phmBinDir)
+	}
+
 	phpBin := filepath.Join(l.installPrefix, version, "bin", "php")
 
 	// Check if PHP binary exists
@@ -219,11 +251,15 @@ func (l *Linker) SetSystemDefault(version string) error {
 
 // RemoveSystemLinks removes PHM symlinks from /usr/local/bin
 func (l *Linker) RemoveSystemLinks() error {
+	if l.systemBinDir == "" {
+		return nil
+	}
+
 	for _, binary := range l.getBinaries() {
 		target := filepath.Join(l.systemBinDir, binary)
 
 		// Only remove if it's a symlink pointing to our installation
-		linkTarget, err := os.Readlink(target)
+		linkTarget, err := l.ReadLink(target)
 		if err != nil {
 			continue // Not a symlink or doesn't exist
 		}
@@ -241,6 +277,9 @@ func (l *Linker) RemoveSystemLinks() error {
 // DetectHomebrewConflicts checks for existing Homebrew PHP installations
 func (l *Linker) DetectHomebrewConflicts() []HomebrewConflict {
 	var conflicts []HomebrewConflict
+	if l.systemBinDir == "" {
+		return conflicts
+	}
 
 	for _, binary := range l.getBinaries() {
 		target := filepath.Join(l.systemBinDir, binary)
@@ -274,16 +313,60 @@ func (l *Linker) DetectHomebrewConflicts() []HomebrewConflict {
 	return conflicts
 }
 
+// GetSystemBinDir returns the shared system bin directory (/usr/local/bin), "" in user mode
+func (l *Linker) GetSystemBinDir() string {
+	return l.systemBinDir
+}
+
 // IsSystemLinked checks if PHM has symlinks in /usr/local/bin
 func (l *Linker) IsSystemLinked() bool {
+	if l.systemBinDir == "" {
+		return false
+	}
 	phpTarget := filepath.Join(l.systemBinDir, "php")
-	linkTarget, err := os.Readlink(phpTarget)
+	linkTarget, err := l.ReadLink(phpTarget)
 	if err != nil {
 		return false
 	}
 	return strings.HasPrefix(linkTarget, l.installPrefix)
 }
 
+// ResolveLink returns the file under the install prefix that path points to
+// through symlinks (e.g. /opt/php/bin/php -> /opt/php/8.5/bin/php), or "" if
+// path is not such a link
+func (l *Linker) ResolveLink(path string) string {
+	path = filepath.Clean(path)
+	target := path
+	for i := 0; i < 40; i++ { // like the ELOOP limit of the kernel
+		next, err := l.ReadLink(target)
+		if err != nil {
+			break
+		}
+		if !filepath.IsAbs(next) {
+			next = filepath.Join(filepath.Dir(target), next)
+		}
+		target = filepath.Clean(next)
+	}
+	if target == path || !strings.HasPrefix(target, filepath.Clean(l.installPrefix)+string(os.PathSeparator)) {
+		return ""
+	}
+	if _, err := os.Stat(target); err != nil {
+		return ""
+	}
+	return target
+}
+
+// ReadLink returns the target of a symlink as a path on the host. Links are
+// created with their targets as seen from inside --root (so the tree still
+// works when it is copied or mounted as /), see createSymlink.
+func (l *Linker) ReadLink(link string) (string, error) {
+	target, err := os.Readlink(link)
+	if err != nil {
+		return "", err
+	}
+	return l.layout.reroot(target), nil
+}
+
 // GetDefaultVersion returns the currently set default PHP version
 func (l *Linker) GetDefaultVersion() string {
 	versionFile := filepath.Join(l.installPrefix, ".current")
@@ -337,34 +420,13 @@ func (l *Linker) RemoveVersionLinks(version string) error {
 	return nil
 }
 
-// removePath removes a file or symlink, falling back to sudo if needed.
+// removePath removes a file or symlink
 func (l *Linker) removePath(target string) error {
-	if err := os.Remove(target); err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		cmd := exec.Command("sudo", "rm", "-f", target)
-		if runErr := cmd.Run(); runErr != nil {
-			return fmt.Errorf("failed to remove %s: %w", target, runErr)
-		}
-	}
-	return nil
+	return l.fs.Remove(target)
 }
 
-// createSymlink creates a symlink, using sudo if necessary
+// createSymlink creates a symlink, replacing an existing symlink or file. The
+// link points to source as seen from inside --root.
 func (l *Linker) createSymlink(source, target string) error {
-	// Remove existing symlink/file
-	if err := l.removePath(target); err != nil {
-		return err
-	}
-
-	// Create new symlink
-	if err := os.Symlink(source, target); err != nil {
-		cmd := exec.Command("sudo", "ln", "-sf", source, target)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to create symlink %s: %w", target, err)
-		}
-	}
-
-	return nil
+	return l.fs.Symlink(l.layout.unroot(source), target)
 }
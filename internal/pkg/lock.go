@@ -17,6 +17,7 @@ func AcquireLock(lockDir string) (func(), error) {
 	if lockDir == "" {
 		lockDir = DefaultLockDir
 	}
+	_ = os.MkdirAll(lockDir, 0755) // e.g. a fresh relocated root
 	lockPath := filepath.Join(lockDir, ".phm.lock")
 	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
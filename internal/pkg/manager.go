@@ -3,11 +3,12 @@ package pkg
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"os/user"
 	"path/filepath"
 	"regexp"
@@ -17,20 +18,11 @@ import (
 	"time"
 
 	"github.com/klauspost/compress/zstd"
-)
-
-// extractMode determines how file extraction handles existing files
-type extractMode int
-
-const (
-	// extractOverwrite overwrites all existing files
-	extractOverwrite extractMode = iota
-	// extractMerge skips config files that already exist (preserves user config)
-	extractMerge
+	"github.com/phm-dev/phm/internal/privfs"
 )
 
 var (
-	dependencyRegex    = regexp.MustCompile(`^([a-zA-Z0-9._-]+)\s*\(([<>=]+)\s*([0-9.]+)\)$`)
+	dependencyRegex    = regexp.MustCompile(`^([a-zA-Z0-9._+-]+)\s*\((<<|>>|<=|>=|==|=|<|>)\s*([0-9][0-9a-zA-Z.+~-]*)\)$`)
 	installedVersionRe = regexp.MustCompile(`^php(\d+\.\d+)`)
 	// installSlotRegex validates InstallSlot values (e.g., "8.5" or "8.5.1")
 	installSlotRegex = regexp.MustCompile(`^\d+\.\d+(\.\d+)?$`)
@@ -47,6 +39,11 @@ type Manager struct {
 	installPrefix string
 	dataDir       string
 	installed     map[string]*InstalledPackage
+	tx            *Transaction  // running transaction, if any
+	scriptTimeout time.Duration // limit for lifecycle scripts (see SetScriptTimeout)
+	layout        Layout        // where package paths outside the install prefix go (see SetLayout)
+	fs            privfs.FS     // changes files outside the data directory (see SetFS)
+	command       string        // command line recorded in the history (see SetCommand)
 }
 
 // NewManager creates a new package manager
@@ -55,9 +52,16 @@ func NewManager(installPrefix, dataDir string) *Manager {
 		installPrefix: installPrefix,
 		dataDir:       dataDir,
 		installed:     make(map[string]*InstalledPackage),
+		layout:        SystemLayout(""),
+		fs:            privfs.Sudo{},
 	}
 }
 
+// SetFS sets how installed files are changed (default: directly, falling back to sudo)
+func (m *Manager) SetFS(fs privfs.FS) {
+	m.fs = fs
+}
+
 // getInstallingUser returns the username and group of the user running the installation
 // If run with sudo, it returns SUDO_USER instead of root
 func getInstallingUser() (username, groupname string) {
@@ -80,8 +84,9 @@ func getInstallingUser() (username, groupname string) {
 	return username, groupname
 }
 
-// replaceConfigPlaceholders replaces {{PHM_USER}} and {{PHM_GROUP}} in config data
-func replaceConfigPlaceholders(data []byte) []byte {
+// replaceConfigPlaceholders replaces {{PHM_USER}}, {{PHM_GROUP}} and the layout
+// paths {{PHM_PREFIX}}, {{PHM_RUN_DIR}} and {{PHM_LOG_DIR}} in config data
+func (m *Manager) replaceConfigPlaceholders(data []byte) []byte {
 	username, groupname := getInstallingUser()
 
 	// Validate to prevent config injection via crafted env vars
@@ -94,6 +99,9 @@ func replaceConfigPlaceholders(data []byte) []byte {
 
 	data = bytes.ReplaceAll(data, []byte("{{PHM_USER}}"), []byte(username))
 	data = bytes.ReplaceAll(data, []byte("{{PHM_GROUP}}"), []byte(groupname))
+	data = bytes.ReplaceAll(data, []byte("{{PHM_PREFIX}}"), []byte(m.layout.unroot(m.installPrefix)))
+	data = bytes.ReplaceAll(data, []byte("{{PHM_RUN_DIR}}"), []byte(m.layout.unroot(m.layout.RunDir)))
+	data = bytes.ReplaceAll(data, []byte("{{PHM_LOG_DIR}}"), []byte(m.layout.unroot(m.layout.LogDir)))
 
 	return data
 }
@@ -101,11 +109,12 @@ func replaceConfigPlaceholders(data []byte) []byte {
 // isConfigFile checks if the file is a config file that should have placeholders replaced
 func isConfigFile(path string) bool {
 	// Replace placeholders only in known config file types under etc/ directory
+	// and in launchd service definitions
+	ext := filepath.Ext(path)
 	if strings.Contains(path, "/etc/") {
-		ext := filepath.Ext(path)
 		return ext == ".conf" || ext == ".ini"
 	}
-	return false
+	return ext == ".plist" && strings.Contains(path, "/Library/Launch")
 }
 
 // LoadInstalled loads installed packages database
@@ -168,7 +177,7 @@ func (m *Manager) GetInstalledByPrefix(prefix string) []*InstalledPackage {
 // Dependency represents a parsed dependency
 type Dependency struct {
 	Name       string
-	Constraint string // >=, =, <=, etc.
+	Constraint string // >=, >> (or >), =, <<  (or <), <=
 	Version    string
 }
 
@@ -187,66 +196,18 @@ func ParseDependency(dep string) Dependency {
 	return d
 }
 
-// ResolveDependencies resolves all dependencies for a package
-func (m *Manager) ResolveDependencies(pkg *Package, available []Package) ([]Package, error) {
-	resolved := make(map[string]bool)
-	inProgress := make(map[string]bool)
-	var result []Package
-
-	var resolve func(p *Package) error
-	resolve = func(p *Package) error {
-		if resolved[p.Name] {
-			return nil
-		}
-		if inProgress[p.Name] {
-			return fmt.Errorf("circular dependency detected: %s", p.Name)
-		}
-		inProgress[p.Name] = true
-
-		for _, depStr := range p.Depends {
-			dep := ParseDependency(depStr)
-
-			// Check if already installed with correct version
-			if installed := m.GetInstalled(dep.Name); installed != nil {
-				if m.versionSatisfies(installed.Version, dep.Constraint, dep.Version) {
-					continue
-				}
-			}
-
-			// Find in available packages
-			var depPkg *Package
-			for i := range available {
-				if available[i].Name == dep.Name {
-					depPkg = &available[i]
-					break
-				}
-			}
-
-			if depPkg == nil {
-				return fmt.Errorf("dependency not found: %s", dep.Name)
-			}
-
-			// Resolve dependencies of this dependency
-			if err := resolve(depPkg); err != nil {
-				return err
-			}
-		}
-
-		delete(inProgress, p.Name)
-		resolved[p.Name] = true
-		result = append(result, *p)
-		return nil
-	}
-
-	if err := resolve(pkg); err != nil {
-		return nil, err
-	}
+// versionSatisfies checks if version satisfies constraint
+func (m *Manager) versionSatisfies(version, constraint, required string) bool {
+	return versionSatisfies(version, constraint, required)
+}
 
-	return result, nil
+// SatisfiedBy reports whether version meets the dependency's version constraint
+func (d Dependency) SatisfiedBy(version string) bool {
+	return versionSatisfies(version, d.Constraint, d.Version)
 }
 
 // versionSatisfies checks if version satisfies constraint
-func (m *Manager) versionSatisfies(version, constraint, required string) bool {
+func versionSatisfies(version, constraint, required string) bool {
 	if constraint == "" || required == "" {
 		return true
 	}
@@ -256,11 +217,11 @@ func (m *Manager) versionSatisfies(version, constraint, required string) bool {
 	switch constraint {
 	case ">=":
 		return cmp >= 0
-	case ">":
+	case ">", ">>":
 		return cmp > 0
 	case "<=":
 		return cmp <= 0
-	case "<":
+	case "<", "<<":
 		return cmp < 0
 	case "=", "==":
 		return cmp == 0
@@ -325,15 +286,14 @@ type InstallOptions struct {
 	// CustomName overrides the package name in the database
 	// Used for pinned versions: php8.5.1-cli vs php8.5-cli
 	CustomName string
+	// Repository records which repository the package was installed from
+	Repository string
+	// ForceOverwrite lets the package take over files owned by unrelated packages
+	ForceOverwrite bool
 }
 
-// allowedSystemPrefixes lists system directories that packages may legitimately install to
-// (e.g., FPM LaunchDaemon plists)
-var allowedSystemPrefixes = []string{
-	"/Library/LaunchDaemons/",
-}
-
-// validateInstallPath checks that destPath is under the install prefix or a known system path
+// validateInstallPath checks that destPath is under the install prefix or the
+// launchd directory of the layout (FPM service plists)
 func (m *Manager) validateInstallPath(destPath string) error {
 	cleanDest := filepath.Clean(destPath)
 	cleanPrefix := filepath.Clean(m.installPrefix) + string(os.PathSeparator)
@@ -343,11 +303,9 @@ func (m *Manager) validateInstallPath(destPath string) error {
 		return nil
 	}
 
-	// Allow known system paths
-	for _, sysPrefix := range allowedSystemPrefixes {
-		if strings.HasPrefix(cleanDest, sysPrefix) {
-			return nil
-		}
+	// Allow service definitions
+	if m.layout.LaunchDir != "" && strings.HasPrefix(cleanDest, filepath.Clean(m.layout.LaunchDir)+string(os.PathSeparator)) {
+		return nil
 	}
 
 	return fmt.Errorf("path traversal detected: %q escapes %q", destPath, m.installPrefix)
@@ -355,12 +313,12 @@ func (m *Manager) validateInstallPath(destPath string) error {
 
 // Install installs a package from a tarball with default options
 func (m *Manager) Install(pkgPath string) (*InstalledPackage, error) {
-	return m.installFromTarball(pkgPath, InstallOptions{}, extractOverwrite)
+	return m.installFromTarball(pkgPath, InstallOptions{})
 }
 
 // InstallWithOptions installs a package from a tarball with custom options
 func (m *Manager) InstallWithOptions(pkgPath string, opts InstallOptions) (*InstalledPackage, error) {
-	return m.installFromTarball(pkgPath, opts, extractOverwrite)
+	return m.installFromTarball(pkgPath, opts)
 }
 
 // validateInstallSlot ensures the slot value is safe (e.g., "8.5" or "8.5.1")
@@ -374,6 +332,13 @@ func validateInstallSlot(slot string) error {
 	return nil
 }
 
+// ReadPackageInfo reads and validates pkginfo.json from a package tarball
+// without extracting it (same rules as installation).
+func ReadPackageInfo(pkgPath string) (*Package, error) {
+	pkgInfo, _, err := readPkgInfo(pkgPath)
+	return pkgInfo, err
+}
+
 // readPkgInfo reads and validates pkginfo.json from the tarball (first pass).
 func readPkgInfo(pkgPath string) (*Package, string, error) {
 	f, err := os.Open(pkgPath)
@@ -445,8 +410,9 @@ func sanitizeFileMode(mode int64) os.FileMode {
 
 // installFromTarball is the unified extraction logic for both Install and InstallWithMerge.
 // Uses two-pass approach: first reads and validates pkginfo.json, then extracts files.
-// In extractMerge mode, config files that already exist on disk are skipped.
-func (m *Manager) installFromTarball(pkgPath string, opts InstallOptions, mode extractMode) (*InstalledPackage, error) {
+// Binary files are overwritten; config files under etc/ keep local changes, with the
+// new version written next to them as .phmnew (see conffileTarget).
+func (m *Manager) installFromTarball(pkgPath string, opts InstallOptions) (*InstalledPackage, error) {
 	if err := validateInstallSlot(opts.InstallSlot); err != nil {
 		return nil, err
 	}
@@ -457,29 +423,198 @@ func (m *Manager) installFromTarball(pkgPath string, opts InstallOptions, mode e
 		return nil, fmt.Errorf("failed to read package metadata: %w", err)
 	}
 
+	// Determine package name for database
+	pkgName := pkgInfo.Name
+	if opts.CustomName != "" {
+		if !safeNameRegex.MatchString(opts.CustomName) {
+			return nil, fmt.Errorf("invalid custom package name %q: contains disallowed characters", opts.CustomName)
+		}
+		pkgName = opts.CustomName
+	}
+
+	// Refuse to overwrite files of other packages before writing anything
+	installSlot := sourceSlot
+	if opts.InstallSlot != "" {
+		installSlot = opts.InstallSlot
+	}
+	takeovers, err := m.fileTakeovers(pkgPath, sourceSlot, installSlot, pkgInfo, pkgName, opts.ForceOverwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lifecycle scripts run with the installed version as old version (upgrade)
+	scripts, err := readScripts(pkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package scripts: %w", err)
+	}
+	env := scriptEnv{
+		Action:  ScriptActionInstall,
+		Package: pkgName,
+		Version: fmt.Sprintf("%s-%d", pkgInfo.Version, pkgInfo.Revision),
+		Slot:    installSlot,
+	}
+	previous := m.installed[pkgName]
+	if previous != nil {
+		env.Action = ScriptActionUpgrade
+		env.OldVersion = fmt.Sprintf("%s-%d", previous.Version, previous.Revision)
+
+		// The replaced package gets to clean up first (prerm upgrade <new-version>)
+		if err := m.runInstalledScript(previous, ScriptPrerm, env, env.Version); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.runPackageScript(scripts, ScriptPreinst, env); err != nil {
+		return nil, err
+	}
+
+	// Pristine checksums of the config files shipped by the installed version
+	pristine := make(map[string]string)
+	if previous != nil {
+		for _, f := range previous.Files {
+			if f.Config {
+				pristine[f.Path] = f.SHA256
+			}
+		}
+	}
+
 	// Pass 2: extract files
-	f, err := os.Open(pkgPath)
+	var installedFiles []string
+	var files []InstalledFile
+	err = m.walkTarball(pkgPath, sourceSlot, opts.InstallSlot, func(r io.Reader, header *tar.Header, destPath string) error {
+		destDir := filepath.Dir(destPath)
+
+		// Create directory
+		if err := m.fs.MkdirAll(destDir); err != nil {
+			return err
+		}
+
+		// Extract file — stream to temp file, then move into place
+		tmpPath, sum, size, err := m.extractEntry(r, header, destPath)
+		if err != nil {
+			return err
+		}
+
+		// Mode has setuid/setgid/sticky bits stripped
+		record := InstalledFile{
+			Path:   destPath,
+			SHA256: sum,
+			Size:   size,
+			Mode:   sanitizeFileMode(header.Mode),
+			Config: !isBinaryPath(destPath),
+		}
+		installedFiles = append(installedFiles, destPath)
+		files = append(files, record)
+
+		target := destPath
+		if record.Config {
+			target = conffileTarget(destPath, sum, pristine[destPath])
+			if target == "" {
+				os.Remove(tmpPath)
+				return nil
+			}
+		}
+
+		// Record the previous contents so the transaction can be rolled back
+		if err := m.journalFile(target); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to journal %s: %w", target, err)
+		}
+
+		if err := m.fs.Install(tmpPath, target, record.Mode); err != nil {
+			return fmt.Errorf("failed to install %s: %w", target, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Files taken over now belong to this package only
+	owners := make([]string, 0, len(takeovers))
+	for owner := range takeovers {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		if err := m.disownFiles(owner, takeovers[owner]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Files are replaced: the old postrm runs before its scripts are replaced
+	if previous != nil {
+		if err := m.runInstalledScript(previous, ScriptPostrm, env, env.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the scripts for upgrade and removal
+	var oldScripts []string
+	if previous != nil {
+		oldScripts = previous.Scripts
+	}
+	storedScripts, err := m.storeScripts(pkgName, oldScripts, scripts)
 	if err != nil {
 		return nil, err
 	}
+
+	pkgInfoVal := *pkgInfo
+	pkgInfoVal.Repo = opts.Repository
+
+	// Create installed package record
+	installed := &InstalledPackage{
+		Package:        pkgInfoVal,
+		InstalledFiles: installedFiles,
+		Files:          files,
+		InstallSlot:    installSlot,
+		Pinned:         opts.Pinned,
+		Scripts:        storedScripts,
+		InstalledAt:    time.Now(),
+	}
+	installed.Name = pkgName
+
+	// Save to database
+	if err := m.journalEntry(pkgName); err != nil {
+		return nil, fmt.Errorf("failed to journal database entry: %w", err)
+	}
+	if err := m.saveInstalled(installed); err != nil {
+		return nil, err
+	}
+	m.installed[pkgName] = installed
+
+	// A failing postinst fails the install
+	if _, ok := scripts[ScriptPostinst]; ok {
+		postinst := filepath.Join(m.scriptDir(pkgName), ScriptPostinst)
+		if err := m.runScript(postinst, ScriptPostinst, env, env.OldVersion); err != nil {
+			return nil, err
+		}
+	}
+	return installed, nil
+}
+
+// walkTarball calls fn for every regular file in the files/ tree of a package,
+// with its destination path rewritten to installSlot and validated
+func (m *Manager) walkTarball(pkgPath, sourceSlot, installSlot string, fn func(r io.Reader, header *tar.Header, destPath string) error) error {
+	f, err := os.Open(pkgPath)
+	if err != nil {
+		return err
+	}
 	defer f.Close()
 
 	zr, err := zstd.NewReader(f)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer zr.Close()
 
 	tr := tar.NewReader(zr)
-	var installedFiles []string
-
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		// Skip pkginfo.json (already read in pass 1)
@@ -496,7 +631,7 @@ func (m *Manager) installFromTarball(pkgPath string, opts InstallOptions, mode e
 			continue
 		}
 
-		destPath := "/" + relPath
+		destPath := m.installPath(relPath)
 
 		// Skip directory entries and non-regular files early (before validation)
 		if header.Typeflag == tar.TypeDir {
@@ -507,9 +642,9 @@ func (m *Manager) installFromTarball(pkgPath string, opts InstallOptions, mode e
 		}
 
 		// Rewrite path if installing to a different slot
-		if opts.InstallSlot != "" && sourceSlot != "" && opts.InstallSlot != sourceSlot {
+		if installSlot != "" && sourceSlot != "" && installSlot != sourceSlot {
 			oldPrefix := m.installPrefix + "/" + sourceSlot + "/"
-			newPrefix := m.installPrefix + "/" + opts.InstallSlot + "/"
+			newPrefix := m.installPrefix + "/" + installSlot + "/"
 			if strings.HasPrefix(destPath, oldPrefix) {
 				destPath = newPrefix + strings.TrimPrefix(destPath, oldPrefix)
 			}
@@ -517,126 +652,69 @@ func (m *Manager) installFromTarball(pkgPath string, opts InstallOptions, mode e
 
 		// Validate path stays within allowed prefixes
 		if err := m.validateInstallPath(destPath); err != nil {
-			return nil, err
-		}
-
-		destDir := filepath.Dir(destPath)
-
-		// Create directory
-		if err := os.MkdirAll(destDir, 0755); err != nil {
-			if err2 := exec.Command("sudo", "mkdir", "-p", destDir).Run(); err2 != nil {
-				return nil, fmt.Errorf("failed to create directory %s: %w", destDir, err2)
-			}
+			return err
 		}
 
 		// Reject files that exceed the maximum allowed size
 		if header.Size > maxFileSize {
-			return nil, fmt.Errorf("file %s exceeds maximum size (%d > %d bytes)", header.Name, header.Size, maxFileSize)
+			return fmt.Errorf("file %s exceeds maximum size (%d > %d bytes)", header.Name, header.Size, maxFileSize)
 		}
 
-		// In merge mode, skip config files that already exist
-		if mode == extractMerge && !isBinaryPath(destPath) {
-			if _, err := os.Stat(destPath); err == nil {
-				installedFiles = append(installedFiles, destPath)
-				continue
-			}
+		if err := fn(tr, header, destPath); err != nil {
+			return err
 		}
+	}
+}
 
-		// Extract file — stream to temp file, then move into place
-		tmp, err := os.CreateTemp("", "phm-install-*")
-		if err != nil {
-			return nil, fmt.Errorf("failed to create temp file: %w", err)
-		}
-		tmpPath := tmp.Name()
+// extractEntry writes a tarball entry to a temp file as it is installed (placeholders
+// replaced in config files) and returns the temp path, SHA256 and size of the contents
+func (m *Manager) extractEntry(r io.Reader, header *tar.Header, destPath string) (string, string, int64, error) {
+	tmp, err := os.CreateTemp("", "phm-install-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
 
-		if isConfigFile(destPath) {
-			// Config files: buffer into memory for placeholder replacement
-			if header.Size > maxConfigSize {
-				tmp.Close()
-				os.Remove(tmpPath)
-				return nil, fmt.Errorf("config file %s exceeds maximum size (%d > %d bytes)", header.Name, header.Size, maxConfigSize)
-			}
-			data, err := io.ReadAll(io.LimitReader(tr, maxConfigSize))
-			if err != nil {
-				tmp.Close()
-				os.Remove(tmpPath)
-				return nil, err
-			}
-			data = replaceConfigPlaceholders(data)
-			if _, err := tmp.Write(data); err != nil {
-				tmp.Close()
-				os.Remove(tmpPath)
-				return nil, err
-			}
-		} else {
-			// Binary files: stream directly to disk
-			written, err := io.Copy(tmp, io.LimitReader(tr, maxFileSize))
-			if err != nil {
-				tmp.Close()
-				os.Remove(tmpPath)
-				return nil, err
-			}
-			if header.Size > 0 && written != header.Size {
-				tmp.Close()
-				os.Remove(tmpPath)
-				return nil, fmt.Errorf("incomplete extraction of %s: got %d bytes, expected %d", header.Name, written, header.Size)
-			}
-		}
+	h := sha256.New()
+	var size int64
+	fail := func(err error) (string, string, int64, error) {
 		tmp.Close()
+		os.Remove(tmpPath)
+		return "", "", 0, err
+	}
 
-		// Move temp file to destination (try directly, then with sudo)
-		if err := os.Rename(tmpPath, destPath); err != nil {
-			cmd := exec.Command("sudo", "cp", tmpPath, destPath)
-			if err := cmd.Run(); err != nil {
-				os.Remove(tmpPath)
-				return nil, fmt.Errorf("failed to install %s: %w", destPath, err)
-			}
-			os.Remove(tmpPath)
+	if isConfigFile(destPath) {
+		// Config files: buffer into memory for placeholder replacement
+		if header.Size > maxConfigSize {
+			return fail(fmt.Errorf("config file %s exceeds maximum size (%d > %d bytes)", header.Name, header.Size, maxConfigSize))
 		}
-
-		// Set permissions with setuid/setgid/sticky bits stripped
-		safeMode := sanitizeFileMode(header.Mode)
-		if err := os.Chmod(destPath, safeMode); err != nil {
-			_ = exec.Command("sudo", "chmod", fmt.Sprintf("%o", safeMode), destPath).Run()
+		data, err := io.ReadAll(io.LimitReader(r, maxConfigSize))
+		if err != nil {
+			return fail(err)
 		}
-
-		installedFiles = append(installedFiles, destPath)
-	}
-
-	pkgInfoVal := *pkgInfo
-
-	// Determine the actual install slot
-	installSlot := sourceSlot
-	if opts.InstallSlot != "" {
-		installSlot = opts.InstallSlot
-	}
-
-	// Determine package name for database
-	pkgName := pkgInfoVal.Name
-	if opts.CustomName != "" {
-		if !safeNameRegex.MatchString(opts.CustomName) {
-			return nil, fmt.Errorf("invalid custom package name %q: contains disallowed characters", opts.CustomName)
+		data = m.replaceConfigPlaceholders(data)
+		if _, err := tmp.Write(data); err != nil {
+			return fail(err)
 		}
-		pkgName = opts.CustomName
-	}
-
-	// Create installed package record
-	installed := &InstalledPackage{
-		Package:        pkgInfoVal,
-		InstalledFiles: installedFiles,
-		InstallSlot:    installSlot,
-		Pinned:         opts.Pinned,
-		InstalledAt:    time.Now(),
+		h.Write(data)
+		size = int64(len(data))
+	} else {
+		// Binary files: stream directly to disk
+		written, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, maxFileSize))
+		if err != nil {
+			return fail(err)
+		}
+		if header.Size > 0 && written != header.Size {
+			return fail(fmt.Errorf("incomplete extraction of %s: got %d bytes, expected %d", header.Name, written, header.Size))
+		}
+		size = written
 	}
-	installed.Name = pkgName
-
-	// Save to database
-	if err := m.saveInstalled(installed); err != nil {
-		return nil, err
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", "", 0, err
 	}
 
-	m.installed[pkgName] = installed
-	return installed, nil
+	return tmpPath, hex.EncodeToString(h.Sum(nil)), size, nil
 }
 
 // saveInstalled saves installed package info to database using atomic write
@@ -687,32 +765,73 @@ func (m *Manager) Remove(name string) error {
 		return fmt.Errorf("package not installed: %s", name)
 	}
 
-	// Remove files (only if they are under allowed paths)
-	cleanPrefix := filepath.Clean(m.installPrefix) + string(os.PathSeparator)
+	// A failing prerm keeps the package installed
+	env := scriptEnv{
+		Action:  ScriptActionRemove,
+		Package: name,
+		Version: fmt.Sprintf("%s-%d", pkg.Version, pkg.Revision),
+		Slot:    pkg.InstallSlot,
+	}
+	if err := m.runInstalledScript(pkg, ScriptPrerm, env, ""); err != nil {
+		return err
+	}
+
+	// Remove files (only if they are under allowed paths and not owned by another package)
+	ownedByOthers := m.ownedByOthers(name)
 	for _, file := range pkg.InstalledFiles {
 		cleanFile := filepath.Clean(file)
-		allowed := strings.HasPrefix(cleanFile, cleanPrefix)
-		if !allowed {
-			for _, sysPrefix := range allowedSystemPrefixes {
-				if strings.HasPrefix(cleanFile, sysPrefix) {
-					allowed = true
-					break
-				}
-			}
+		if ownedByOthers[cleanFile] {
+			continue
 		}
-		if !allowed {
+		if m.validateInstallPath(cleanFile) != nil {
 			fmt.Fprintf(os.Stderr, "warning: skipping removal of %s (outside allowed paths)\n", file)
 			continue
 		}
-		if err := os.Remove(cleanFile); err != nil {
-			_ = exec.Command("sudo", "rm", "-f", cleanFile).Run()
+		if err := m.journalFile(cleanFile); err != nil {
+			return fmt.Errorf("failed to journal %s: %w", cleanFile, err)
+		}
+		if err := m.fs.Remove(cleanFile); err != nil {
+			return err
 		}
+
+		// Unresolved new config version (see conffileTarget)
+		if newFile := cleanFile + conffileSuffix; !isBinaryPath(cleanFile) && fileExists(newFile) {
+			if err := m.journalFile(newFile); err != nil {
+				return fmt.Errorf("failed to journal %s: %w", newFile, err)
+			}
+			if err := m.fs.Remove(newFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.removeEmptyDirs(pkg.InstalledFiles)
+
+	if err := m.runInstalledScript(pkg, ScriptPostrm, env, ""); err != nil {
+		return err
+	}
+	if _, err := m.storeScripts(name, pkg.Scripts, nil); err != nil {
+		return err
+	}
+
+	// Remove the database entry last: until it is gone, rolling an interrupted
+	// transaction forward runs the whole removal again
+	if safeNameRegex.MatchString(name) {
+		if err := m.journalEntry(name); err != nil {
+			return fmt.Errorf("failed to journal database entry: %w", err)
+		}
+		dbFile := filepath.Join(m.dataDir, "installed", name+".json")
+		os.Remove(dbFile)
 	}
+	delete(m.installed, name)
+	return nil
+}
 
-	// Clean up empty parent directories (only within install prefix)
+// removeEmptyDirs removes parent directories of files that are left empty (only within install prefix)
+func (m *Manager) removeEmptyDirs(files []string) {
 	cleanedDirs := make(map[string]bool)
 	cleanInstallPrefix := filepath.Clean(m.installPrefix)
-	for _, file := range pkg.InstalledFiles {
+	for _, file := range files {
 		dir := filepath.Clean(filepath.Dir(file))
 		for !cleanedDirs[dir] {
 			// Stop at or above install prefix
@@ -724,21 +843,10 @@ func (m *Manager) Remove(name string) error {
 			if err != nil || len(entries) > 0 {
 				break
 			}
-			if err := os.Remove(dir); err != nil {
-				_ = exec.Command("sudo", "rmdir", dir).Run()
-			}
+			_ = m.fs.Remove(dir)
 			dir = filepath.Dir(dir)
 		}
 	}
-
-	// Remove database entry
-	if safeNameRegex.MatchString(name) {
-		dbFile := filepath.Join(m.dataDir, "installed", name+".json")
-		os.Remove(dbFile)
-	}
-
-	delete(m.installed, name)
-	return nil
 }
 
 // GetInstalledVersions returns all installed PHP versions
@@ -770,12 +878,8 @@ func (m *Manager) GetDependents(name string) []string {
 			continue
 		}
 
-		for _, depStr := range pkg.Depends {
-			dep := ParseDependency(depStr)
-			if dep.Name == name {
-				dependents = append(dependents, pkgName)
-				break
-			}
+		if dependsOn(&pkg.Package, name) {
+			dependents = append(dependents, pkgName)
 		}
 	}
 
@@ -783,6 +887,19 @@ func (m *Manager) GetDependents(name string) []string {
 	return dependents
 }
 
+// dependsOn reports whether any Depends clause of p names the package (including alternatives)
+func dependsOn(p *Package, name string) bool {
+	clauses, _ := packageClauses(p)
+	for _, clause := range clauses {
+		for _, dep := range clause {
+			if dep.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // GetAllInstalled returns all installed packages
 func (m *Manager) GetAllInstalled() []*InstalledPackage {
 	var result []*InstalledPackage
@@ -812,9 +929,9 @@ func (m *Manager) CheckUpgrade(name string, availableVersion string) string {
 	return ""
 }
 
-// CheckUpgradeWithPHP checks if upgrade is needed considering both extension and PHP version
+// CheckUpgradeWithPHP checks if upgrade is needed considering the build and the PHP version
 // For extensions, the extension version might be the same but PHP version different (e.g., redis 6.3.0 for PHP 8.5.0 vs 8.5.1)
-func (m *Manager) CheckUpgradeWithPHP(name string, availableVersion string, availablePHPVersion string) string {
+func (m *Manager) CheckUpgradeWithPHP(name string, available *Package) string {
 	installed := m.GetInstalled(name)
 	if installed == nil {
 		return ""
@@ -825,20 +942,35 @@ func (m *Manager) CheckUpgradeWithPHP(name string, availableVersion string, avai
 		return ""
 	}
 
-	// Check extension version first
-	versionCmp := compareVersions(availableVersion, installed.Version)
-	if versionCmp > 0 {
-		return availableVersion
+	if IsUpgrade(available, &installed.Package) {
+		return available.Version
 	}
+	return ""
+}
 
-	// Same extension version - check PHP version (for extensions rebuilt for newer PHP)
-	if versionCmp == 0 && availablePHPVersion != "" && installed.PHPVersion != "" {
-		if compareVersions(availablePHPVersion, installed.PHPVersion) > 0 {
-			return availableVersion
-		}
+// IsUpgrade reports whether available is newer than installed: a newer version
+// or revision, or the same build rebuilt for a newer PHP version
+func IsUpgrade(available, installed *Package) bool {
+	if cmp := CompareBuilds(available, installed); cmp != 0 {
+		return cmp > 0
+	}
+	if available.PHPVersion == "" || installed.PHPVersion == "" {
+		return false
 	}
+	return compareVersions(available.PHPVersion, installed.PHPVersion) > 0
+}
 
-	return ""
+// PackageInfo returns an available package with its state in this installation
+func (m *Manager) PackageInfo(p Package) PackageInfo {
+	info := PackageInfo{Package: p, State: StateNotInstalled}
+	if installed := m.GetInstalled(p.Name); installed != nil {
+		info.InstalledVersion = installed.Version
+		info.State = StateInstalled
+		if m.CheckUpgradeWithPHP(p.Name, &p) != "" {
+			info.State = StateUpgradable
+		}
+	}
+	return info
 }
 
 // CompareVersions compares two version strings (exported wrapper)
@@ -873,10 +1005,11 @@ func isBinaryPath(relPath string) bool {
 
 // InstallWithMerge installs a package using merge strategy:
 // - Binary files (bin/, sbin/, lib/, *.so) are always overwritten
-// - Config files (etc/) are only written if they don't exist
-// This solves macOS code signing issues when adding extensions to existing PHP installation
+// - Config files (etc/) are handled as conffiles (see conffileTarget)
+// This solves macOS code signing issues when adding extensions to existing PHP installation.
+// Since config files are never clobbered, this is the same as InstallWithOptions.
 func (m *Manager) InstallWithMerge(pkgPath string, opts InstallOptions) (*InstalledPackage, error) {
-	return m.installFromTarball(pkgPath, opts, extractMerge)
+	return m.installFromTarball(pkgPath, opts)
 }
 
 // GetInstalledForVersion returns all installed packages for a specific PHP version slot
@@ -0,0 +1,405 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/phm-dev/phm/internal/privfs"
+)
+
+// buildPackage packs a package for tests. files maps paths under /opt/php to
+// their contents (files under bin/ are executable), scripts maps lifecycle
+// script names to their contents.
+func buildPackage(t *testing.T, info Package, files, scripts map[string]string) string {
+	t.Helper()
+
+	stage := t.TempDir()
+	for path, data := range files {
+		mode := os.FileMode(0644)
+		if strings.Contains(path, "/bin/") {
+			mode = 0755
+		}
+		dest := filepath.Join(stage, "opt", "php", path)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dest, []byte(data), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scriptsDir := ""
+	if len(scripts) > 0 {
+		scriptsDir = t.TempDir()
+		for name, data := range scripts {
+			if err := os.WriteFile(filepath.Join(scriptsDir, name), []byte(data), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if info.Revision == 0 {
+		info.Revision = 1
+	}
+	if info.Platform == "" {
+		info.Platform = "darwin-arm64"
+	}
+	result, err := Pack(info, stage, scriptsDir, t.TempDir(), PackagePrefix)
+	if err != nil {
+		t.Fatalf("Pack(%s): %v", info.Name, err)
+	}
+	return result.Path
+}
+
+// newFakeManager returns a manager that changes files through a privfs.Fake,
+// with an install prefix that does not exist on disk. The test fails if sudo
+// is run.
+func newFakeManager(t *testing.T) (*Manager, *privfs.Fake) {
+	t.Helper()
+
+	bin := t.TempDir()
+	calls := filepath.Join(bin, "calls")
+	stub := "#!/bin/sh\necho \"$@\" >> " + calls + "\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "sudo"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Cleanup(func() {
+		if data, err := os.ReadFile(calls); err == nil {
+			t.Errorf("sudo was run:\n%s", data)
+		}
+	})
+
+	root := t.TempDir()
+	m := NewManager(filepath.Join(root, "opt", "php"), filepath.Join(root, "data"))
+	fake := privfs.NewFake()
+	m.SetFS(fake)
+	return m, fake
+}
+
+// opsSince returns the operations recorded by fake after the first n
+func opsSince(fake *privfs.Fake, n int) []string {
+	return fake.Ops()[n:]
+}
+
+func TestInstallWritesThroughFS(t *testing.T) {
+	m, fake := newFakeManager(t)
+	pkgPath := buildPackage(t, Package{Name: "php8.5-cli", Version: "8.5.0"}, map[string]string{
+		"8.5/bin/php":     "php binary",
+		"8.5/etc/php.ini": "extension_dir={{PHM_PREFIX}}/8.5/lib\n",
+	}, nil)
+
+	installed, err := m.Install(pkgPath)
+	if err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	bin := filepath.Join(m.installPrefix, "8.5", "bin", "php")
+	if data, err := fake.ReadFile(bin); err != nil || string(data) != "php binary" {
+		t.Errorf("%s = %q, %v; want %q", bin, data, err, "php binary")
+	}
+	if mode := fake.Mode(bin); mode != 0755 {
+		t.Errorf("mode of %s = %o, want 755", bin, mode)
+	}
+	ini := filepath.Join(m.installPrefix, "8.5", "etc", "php.ini")
+	want := "extension_dir=" + m.installPrefix + "/8.5/lib\n"
+	if data, err := fake.ReadFile(ini); err != nil || string(data) != want {
+		t.Errorf("%s = %q, %v; want %q", ini, data, err, want)
+	}
+
+	// Nothing was written to the install prefix on disk
+	if _, err := os.Stat(m.installPrefix); !os.IsNotExist(err) {
+		t.Errorf("install prefix exists on disk (err = %v)", err)
+	}
+
+	if installed.InstallSlot != "8.5" {
+		t.Errorf("InstallSlot = %q, want 8.5", installed.InstallSlot)
+	}
+	for _, f := range installed.Files {
+		if f.Config != (f.Path == ini) {
+			t.Errorf("%s: Config = %v", f.Path, f.Config)
+		}
+	}
+	for _, path := range []string{bin, ini} {
+		if owners := m.Owners(path); !reflect.DeepEqual(owners, []string{"php8.5-cli"}) {
+			t.Errorf("Owners(%s) = %v, want [php8.5-cli]", path, owners)
+		}
+	}
+
+	// The database entry is on disk and loads back
+	reloaded := NewManager(m.installPrefix, m.dataDir)
+	if err := reloaded.LoadInstalled(); err != nil {
+		t.Fatal(err)
+	}
+	if p := reloaded.GetInstalled("php8.5-cli"); p == nil || p.Version != "8.5.0" {
+		t.Errorf("reloaded php8.5-cli = %+v", p)
+	}
+}
+
+func TestInstallRunsScriptsThroughFS(t *testing.T) {
+	m, fake := newFakeManager(t)
+	pkgPath := buildPackage(t, Package{Name: "php8.5-redis", Version: "6.1.0", PHPVersion: "8.5.0"},
+		map[string]string{"8.5/lib/php/extensions/redis.so": "so"},
+		map[string]string{ScriptPreinst: "true\n", ScriptPostinst: "true\n", ScriptPrerm: "true\n"})
+
+	if _, err := m.Install(pkgPath); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	dir := m.scriptDir("php8.5-redis")
+	var runs []string
+	for _, op := range fake.Ops() {
+		if strings.HasPrefix(op, "run ") {
+			runs = append(runs, op)
+		}
+	}
+	want := []string{
+		"run /bin/sh " + filepath.Join(dir, "preinst.new") + " install",
+		"run /bin/sh " + filepath.Join(dir, "postinst") + " install",
+	}
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("scripts run:\n%s\nwant:\n%s", strings.Join(runs, "\n"), strings.Join(want, "\n"))
+	}
+	if data, err := fake.ReadFile(filepath.Join(dir, ScriptPrerm)); err != nil || string(data) != "true\n" {
+		t.Errorf("stored prerm = %q, %v", data, err)
+	}
+}
+
+func TestInstallFileOwnership(t *testing.T) {
+	shared := map[string]string{"8.5/bin/php-config": "config"}
+
+	tests := []struct {
+		name     string
+		replaces []string
+		force    bool
+		wantErr  bool
+	}{
+		{name: "conflict", wantErr: true},
+		{name: "replaces", replaces: []string{"php8.5-dev"}},
+		{name: "force overwrite", force: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, fake := newFakeManager(t)
+			if _, err := m.Install(buildPackage(t, Package{Name: "php8.5-dev", Version: "8.5.0"}, shared, nil)); err != nil {
+				t.Fatalf("Install php8.5-dev: %v", err)
+			}
+			other := buildPackage(t, Package{Name: "php8.5-tools", Version: "8.5.0", Replaces: tt.replaces}, shared, nil)
+
+			n := len(fake.Ops())
+			_, err := m.InstallWithOptions(other, InstallOptions{ForceOverwrite: tt.force})
+
+			path := filepath.Join(m.installPrefix, "8.5", "bin", "php-config")
+			if tt.wantErr {
+				var conflict *FileConflictError
+				if !errors.As(err, &conflict) || conflict.Owner != "php8.5-dev" {
+					t.Fatalf("Install = %v, want file conflict with php8.5-dev", err)
+				}
+				if ops := opsSince(fake, n); len(ops) > 0 {
+					t.Errorf("conflicting install changed files: %v", ops)
+				}
+				if owners := m.Owners(path); !reflect.DeepEqual(owners, []string{"php8.5-dev"}) {
+					t.Errorf("Owners = %v, want [php8.5-dev]", owners)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Install php8.5-tools: %v", err)
+			}
+			if owners := m.Owners(path); !reflect.DeepEqual(owners, []string{"php8.5-tools"}) {
+				t.Errorf("Owners = %v, want [php8.5-tools]", owners)
+			}
+			if slices.Contains(m.GetInstalled("php8.5-dev").InstalledFiles, path) {
+				t.Errorf("php8.5-dev still lists %s", path)
+			}
+		})
+	}
+}
+
+func TestInstallConffiles(t *testing.T) {
+	const (
+		v1    = "memory_limit=128M\n"
+		v2    = "memory_limit=256M\n"
+		local = "memory_limit=1G\n"
+	)
+
+	tests := []struct {
+		name      string
+		installed string // shipped by the installed version ("" if not installed)
+		onDisk    string // current contents ("" if missing)
+		shipped   string // shipped by the new version
+		want      string // "" (not written), "path" or "phmnew"
+	}{
+		{name: "new file", shipped: v1, want: "path"},
+		{name: "unmodified", installed: v1, onDisk: v1, shipped: v2, want: "path"},
+		{name: "modified locally", installed: v1, onDisk: local, shipped: v2, want: "phmnew"},
+		{name: "modified, same upstream", installed: v1, onDisk: local, shipped: v1, want: ""},
+		{name: "already up to date", installed: v1, onDisk: v2, shipped: v2, want: ""},
+		{name: "unowned file", onDisk: local, shipped: v1, want: "phmnew"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, fake := newFakeManager(t)
+			ini := filepath.Join(m.installPrefix, "8.5", "etc", "php.ini")
+
+			if tt.installed != "" {
+				v := buildPackage(t, Package{Name: "php8.5-common", Version: "8.5.0"}, map[string]string{"8.5/etc/php.ini": tt.installed}, nil)
+				if _, err := m.Install(v); err != nil {
+					t.Fatalf("Install 8.5.0: %v", err)
+				}
+			}
+			// The decision is made from the file on disk
+			if tt.onDisk != "" {
+				if err := os.MkdirAll(filepath.Dir(ini), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(ini, []byte(tt.onDisk), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n := len(fake.Ops())
+			v := buildPackage(t, Package{Name: "php8.5-common", Version: "8.5.1"}, map[string]string{"8.5/etc/php.ini": tt.shipped}, nil)
+			installed, err := m.Install(v)
+			if err != nil {
+				t.Fatalf("Install 8.5.1: %v", err)
+			}
+
+			var written []string
+			for _, op := range opsSince(fake, n) {
+				if strings.HasPrefix(op, "install ") {
+					written = append(written, strings.TrimPrefix(op, "install "))
+				}
+			}
+			var want []string
+			switch tt.want {
+			case "path":
+				want = []string{ini}
+			case "phmnew":
+				want = []string{ini + conffileSuffix}
+			}
+			if !reflect.DeepEqual(written, want) {
+				t.Errorf("written = %v, want %v", written, want)
+			}
+
+			// The recorded pristine version is always the shipped one
+			for _, f := range installed.Files {
+				if f.Path == ini && (!f.Config || f.Size != int64(len(tt.shipped))) {
+					t.Errorf("recorded %+v, want config file of %d bytes", f, len(tt.shipped))
+				}
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	m, fake := newFakeManager(t)
+	pkgPath := buildPackage(t, Package{Name: "php8.5-redis", Version: "6.1.0", PHPVersion: "8.5.0"},
+		map[string]string{
+			"8.5/lib/php/extensions/redis.so": "so",
+			"8.5/etc/conf.d/redis.ini":        "extension=redis.so\n",
+		},
+		map[string]string{ScriptPrerm: "true\n", ScriptPostrm: "true\n"})
+	if _, err := m.Install(pkgPath); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	n := len(fake.Ops())
+	if err := m.Remove("php8.5-redis"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	dir := m.scriptDir("php8.5-redis")
+	want := []string{
+		"run /bin/sh " + filepath.Join(dir, ScriptPrerm) + " remove",
+		"remove " + filepath.Join(m.installPrefix, "8.5", "etc", "conf.d", "redis.ini"),
+		"remove " + filepath.Join(m.installPrefix, "8.5", "lib", "php", "extensions", "redis.so"),
+		"run /bin/sh " + filepath.Join(dir, ScriptPostrm) + " remove",
+		"remove " + filepath.Join(dir, ScriptPrerm),
+		"remove " + filepath.Join(dir, ScriptPostrm),
+	}
+	if ops := opsSince(fake, n); !reflect.DeepEqual(ops, want) {
+		t.Errorf("ops:\n%s\nwant:\n%s", strings.Join(ops, "\n"), strings.Join(want, "\n"))
+	}
+	for _, p := range fake.Paths() {
+		if _, err := fake.ReadFile(p); err == nil {
+			t.Errorf("%s left after removal", p)
+		}
+	}
+	if m.IsInstalled("php8.5-redis") {
+		t.Error("php8.5-redis still installed")
+	}
+	if _, err := os.Stat(filepath.Join(m.dataDir, "installed", "php8.5-redis.json")); !os.IsNotExist(err) {
+		t.Errorf("database entry left (err = %v)", err)
+	}
+}
+
+func TestCheckUpgradeWithPHP(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed Package
+		pinned    bool
+		available Package
+		want      string
+	}{
+		{
+			name:      "newer version",
+			installed: Package{Name: "php8.5-cli", Version: "8.5.0", Revision: 1},
+			available: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 1},
+			want:      "8.5.1",
+		},
+		{
+			name:      "newer revision after a downgrade",
+			installed: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 1},
+			available: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 2},
+			want:      "8.5.1",
+		},
+		{
+			name:      "same build",
+			installed: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 2},
+			available: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 2},
+		},
+		{
+			name:      "older revision",
+			installed: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 2},
+			available: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 1},
+		},
+		{
+			name:      "older version with a higher revision",
+			installed: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 1},
+			available: Package{Name: "php8.5-cli", Version: "8.5.0", Revision: 3},
+		},
+		{
+			name:      "extension rebuilt for a newer PHP",
+			installed: Package{Name: "php8.5-redis", Version: "6.1.0", Revision: 1, PHPVersion: "8.5.0"},
+			available: Package{Name: "php8.5-redis", Version: "6.1.0", Revision: 1, PHPVersion: "8.5.1"},
+			want:      "6.1.0",
+		},
+		{
+			name:      "extension built for an older PHP",
+			installed: Package{Name: "php8.5-redis", Version: "6.1.0", Revision: 1, PHPVersion: "8.5.1"},
+			available: Package{Name: "php8.5-redis", Version: "6.1.0", Revision: 1, PHPVersion: "8.5.0"},
+		},
+		{
+			name:      "pinned",
+			installed: Package{Name: "php8.5-cli", Version: "8.5.0", Revision: 1},
+			pinned:    true,
+			available: Package{Name: "php8.5-cli", Version: "8.5.1", Revision: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(t.TempDir(), t.TempDir())
+			m.installed[tt.installed.Name] = &InstalledPackage{Package: tt.installed, Pinned: tt.pinned}
+
+			if got := m.CheckUpgradeWithPHP(tt.installed.Name, &tt.available); got != tt.want {
+				t.Errorf("CheckUpgradeWithPHP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileConflictError reports files a package would take over from another
+// installed package that it does not replace
+type FileConflictError struct {
+	Package string
+	Owner   string
+	Files   []string
+}
+
+func (e *FileConflictError) Error() string {
+	const shown = 3
+	files := e.Files
+	more := ""
+	if len(files) > shown {
+		more = fmt.Sprintf(" (and %d more)", len(files)-shown)
+		files = files[:shown]
+	}
+	return fmt.Sprintf("%s would overwrite %d file(s) owned by %s: %s%s (remove %s first, or use --force-overwrite)",
+		e.Package, len(e.Files), e.Owner, strings.Join(files, ", "), more, e.Owner)
+}
+
+// ownedFiles returns the files recorded for an installed package
+func ownedFiles(p *InstalledPackage) []string {
+	if len(p.Files) > 0 {
+		files := make([]string, len(p.Files))
+		for i, f := range p.Files {
+			files[i] = f.Path
+		}
+		return files
+	}
+	return p.InstalledFiles
+}
+
+// FileOwners builds a path -> package name index of all installed files.
+// A path listed by more than one package maps to the first name in order.
+func (m *Manager) FileOwners() map[string]string {
+	owners := make(map[string]string)
+	for _, p := range m.sortedInstalled() {
+		for _, path := range ownedFiles(p) {
+			path = filepath.Clean(path)
+			if _, ok := owners[path]; !ok {
+				owners[path] = p.Name
+			}
+		}
+	}
+	return owners
+}
+
+// Owners returns the names of installed packages that list path, sorted
+func (m *Manager) Owners(path string) []string {
+	path = filepath.Clean(path)
+	var names []string
+	for _, p := range m.sortedInstalled() {
+		for _, f := range ownedFiles(p) {
+			if filepath.Clean(f) == path {
+				names = append(names, p.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// ownedByOthers returns the installed files of packages other than name
+func (m *Manager) ownedByOthers(name string) map[string]bool {
+	owned := make(map[string]bool)
+	for _, p := range m.installed {
+		if p.Name == name {
+			continue
+		}
+		for _, path := range ownedFiles(p) {
+			owned[filepath.Clean(path)] = true
+		}
+	}
+	return owned
+}
+
+// replaces reports whether p declares that it replaces the installed package q
+func replaces(p *Package, q *Package) bool {
+	for _, entry := range p.Replaces {
+		clauses, err := ParseRelations(entry)
+		if err != nil {
+			continue
+		}
+		for _, clause := range clauses {
+			if clause.MatchesPackage(q) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canonicalName maps pinned and old-style names (php8.5.1-cli) to the index name
+func canonicalName(name string) string {
+	if info := ParsePackageName(name); info != nil {
+		return info.GetCanonicalName()
+	}
+	return name
+}
+
+// fileTakeovers checks the files of a package tarball against the files of
+// other installed packages. Files of packages it replaces, of other builds of
+// the same package, or of any package with force are returned by owner so they
+// can be handed over; other overlaps are reported as a FileConflictError before
+// anything is written.
+func (m *Manager) fileTakeovers(pkgPath, sourceSlot, installSlot string, pkgInfo *Package, pkgName string, force bool) (map[string][]string, error) {
+	owners := m.FileOwners()
+	takeovers := make(map[string][]string)
+	err := m.walkTarball(pkgPath, sourceSlot, installSlot, func(_ io.Reader, _ *tar.Header, destPath string) error {
+		owner, ok := owners[filepath.Clean(destPath)]
+		if !ok || owner == pkgName {
+			return nil
+		}
+		takeovers[owner] = append(takeovers[owner], filepath.Clean(destPath))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make([]string, 0, len(takeovers))
+	for owner := range takeovers {
+		owned = append(owned, owner)
+	}
+	sort.Strings(owned)
+	for _, owner := range owned {
+		if force || canonicalName(owner) == canonicalName(pkgInfo.Name) || replaces(pkgInfo, &m.installed[owner].Package) {
+			continue
+		}
+		return nil, &FileConflictError{Package: pkgName, Owner: owner, Files: takeovers[owner]}
+	}
+	return takeovers, nil
+}
+
+// disownFiles drops files from the database entry of an installed package,
+// after another package took them over
+func (m *Manager) disownFiles(name string, files []string) error {
+	p := m.installed[name]
+	if p == nil {
+		return nil
+	}
+	drop := make(map[string]bool, len(files))
+	for _, f := range files {
+		drop[f] = true
+	}
+
+	updated := *p
+	updated.InstalledFiles = nil
+	for _, f := range p.InstalledFiles {
+		if !drop[filepath.Clean(f)] {
+			updated.InstalledFiles = append(updated.InstalledFiles, f)
+		}
+	}
+	updated.Files = nil
+	for _, f := range p.Files {
+		if !drop[filepath.Clean(f.Path)] {
+			updated.Files = append(updated.Files, f)
+		}
+	}
+
+	if err := m.journalEntry(name); err != nil {
+		return fmt.Errorf("failed to journal database entry: %w", err)
+	}
+	if err := m.saveInstalled(&updated); err != nil {
+		return err
+	}
+	m.installed[name] = &updated
+	return nil
+}
@@ -0,0 +1,307 @@
+package pkg
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// PackResult describes a package created by Pack
+type PackResult struct {
+	Path     string
+	Files    int
+	Warnings []string
+}
+
+// Pack creates a package tarball in outDir from a staged directory tree.
+//
+// stageDir mirrors the install locations (like a DESTDIR), e.g.
+// stage/opt/php/8.5/lib/php/extensions/foo.so is installed as
+// /opt/php/8.5/lib/php/extensions/foo.so. Every file must be under installPrefix
+// or an allowed system path. The tarball contains pkginfo.json, the lifecycle
+// scripts found in scriptsDir (optional: preinst, postinst, prerm, postrm) and
+// the files/ tree, exactly as installFromTarball expects.
+func Pack(info Package, stageDir, scriptsDir, outDir, installPrefix string) (*PackResult, error) {
+	if err := validatePackInfo(&info); err != nil {
+		return nil, err
+	}
+
+	if info.Depends == nil {
+		info.Depends = []string{}
+	}
+	if info.Provides == nil {
+		info.Provides = []string{}
+	}
+	info.InstalledSize = 0
+
+	m := &Manager{installPrefix: installPrefix, layout: SystemLayout("")}
+	result := &PackResult{}
+
+	// Expected slot directory, as derived by readPkgInfo
+	slotSource := info.Version
+	if info.PHPVersion != "" {
+		slotSource = info.PHPVersion
+	}
+	slotPrefix := ""
+	if parts := strings.Split(slotSource, "."); len(parts) >= 2 {
+		slotPrefix = filepath.Clean(installPrefix) + "/" + parts[0] + "." + parts[1] + "/"
+	}
+
+	// Collect files first so metadata (installed size) is known before writing
+	type stagedFile struct {
+		path string
+		rel  string
+		info fs.FileInfo
+	}
+	var files []stagedFile
+	err := filepath.WalkDir(stageDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(stageDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			files = append(files, stagedFile{path: path, rel: rel, info: fi})
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("skipping /%s: not a regular file (symlinks and special files are not installed)", rel))
+			return nil
+		}
+
+		dest := "/" + rel
+		if err := m.validateInstallPath(dest); err != nil {
+			return fmt.Errorf("%s is outside %s: stage files under the install prefix", dest, installPrefix)
+		}
+		if fi.Size() > maxFileSize {
+			return fmt.Errorf("%s exceeds maximum size (%d > %d bytes)", dest, fi.Size(), maxFileSize)
+		}
+		if isConfigFile(dest) && fi.Size() > maxConfigSize {
+			return fmt.Errorf("config file %s exceeds maximum size (%d > %d bytes)", dest, fi.Size(), maxConfigSize)
+		}
+		if slotPrefix != "" && strings.HasPrefix(dest, filepath.Clean(installPrefix)+"/") && !strings.HasPrefix(dest, slotPrefix) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s is not under %s (slot derived from version %s)", dest, slotPrefix, slotSource))
+		}
+		if fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("%s: setuid/setgid/sticky bits are stripped on install", dest))
+		}
+
+		info.InstalledSize += fi.Size()
+		result.Files++
+		files = append(files, stagedFile{path: path, rel: rel, info: fi})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Files == 0 {
+		return nil, fmt.Errorf("no files found in %s", stageDir)
+	}
+
+	scripts, err := readScriptsDir(scriptsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgInfo, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	result.Path = filepath.Join(outDir, info.Filename())
+
+	tmp, err := os.CreateTemp(outDir, ".phm-pack-*")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	zw, err := zstd.NewWriter(tmp, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+
+	writeErr := func() error {
+		// pkginfo.json goes first so readers find it without scanning the whole archive
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     "pkginfo.json",
+			Mode:     0644,
+			Size:     int64(len(pkgInfo)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(pkgInfo); err != nil {
+			return err
+		}
+
+		for _, name := range ScriptNames {
+			data, ok := scripts[name]
+			if !ok {
+				continue
+			}
+			if err := tw.WriteHeader(&tar.Header{
+				Name:     "scripts/" + name,
+				Mode:     0755,
+				Size:     int64(len(data)),
+				Typeflag: tar.TypeReg,
+			}); err != nil {
+				return err
+			}
+			if _, err := tw.Write(data); err != nil {
+				return err
+			}
+		}
+
+		for _, f := range files {
+			header, err := tar.FileInfoHeader(f.info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = "files/" + f.rel
+			header.Mode = int64(sanitizeFileMode(int64(f.info.Mode().Perm())))
+			header.Uid, header.Gid = 0, 0
+			header.Uname, header.Gname = "", ""
+			if f.info.IsDir() {
+				header.Name += "/"
+				if err := tw.WriteHeader(header); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			src, err := os.Open(f.path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, src)
+			src.Close()
+			if err != nil {
+				return fmt.Errorf("failed to add /%s: %w", f.rel, err)
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return zw.Close()
+	}()
+	if closeErr := tmp.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		return nil, fmt.Errorf("failed to write package: %w", writeErr)
+	}
+
+	// Read it back with the installer's own validation
+	if _, _, err := readPkgInfo(tmpPath); err != nil {
+		return nil, fmt.Errorf("created package is invalid: %w", err)
+	}
+	if _, err := readScripts(tmpPath); err != nil {
+		return nil, fmt.Errorf("created package is invalid: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, result.Path); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// readScriptsDir reads lifecycle scripts from dir ("" for none). Other files
+// are rejected so a misspelled script name is not silently left out.
+func readScriptsDir(dir string) (map[string][]byte, error) {
+	scripts := make(map[string][]byte)
+	if dir == "" {
+		return scripts, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !isScriptName(entry.Name()) {
+			return nil, fmt.Errorf("unknown script %s in %s (supported: %s)", entry.Name(), dir, strings.Join(ScriptNames, ", "))
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil, fmt.Errorf("script %s is not a regular file", entry.Name())
+		}
+		if fi.Size() > maxScriptSize {
+			return nil, fmt.Errorf("script %s exceeds maximum size (%d > %d bytes)", entry.Name(), fi.Size(), maxScriptSize)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		scripts[entry.Name()] = data
+	}
+	return scripts, nil
+}
+
+// validatePackInfo checks package metadata with the rules enforced at install time
+func validatePackInfo(info *Package) error {
+	if info.Name == "" || info.Version == "" {
+		return fmt.Errorf("name and version are required")
+	}
+	if !safeNameRegex.MatchString(info.Name) {
+		return fmt.Errorf("invalid package name %q: contains disallowed characters", info.Name)
+	}
+	if !safeVersionRegex.MatchString(info.Version) {
+		return fmt.Errorf("invalid package version %q: must be numeric (e.g., 8.5.0)", info.Version)
+	}
+	if info.PHPVersion != "" && !safeVersionRegex.MatchString(info.PHPVersion) {
+		return fmt.Errorf("invalid PHP version %q: must be numeric (e.g., 8.5.0)", info.PHPVersion)
+	}
+	if info.Revision < 1 {
+		return fmt.Errorf("invalid revision %d: must be at least 1", info.Revision)
+	}
+	if info.Platform == "" || strings.ContainsAny(info.Platform, "/_ ") {
+		return fmt.Errorf("invalid platform %q", info.Platform)
+	}
+
+	for _, list := range [][]string{info.Depends, info.Conflicts, info.Replaces} {
+		for _, entry := range list {
+			if _, err := ParseRelations(entry); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range info.Provides {
+		if name := ParseDependency(entry).Name; !safeNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid package name %q in provides", name)
+		}
+	}
+
+	return nil
+}
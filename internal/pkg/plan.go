@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// PlannedFile is a file an installation would write
+type PlannedFile struct {
+	Path     string // where the file is written (path.phmnew for modified config files)
+	Replaces bool   // an existing file is overwritten
+}
+
+// InstallPlan lists what installing a package tarball would change
+type InstallPlan struct {
+	Files     []PlannedFile
+	Takeovers map[string][]string // files handed over from other packages, by owner
+}
+
+// PlanInstall returns the files InstallWithOptions would write for a package,
+// without changing anything. Config files that are up to date are left out,
+// locally modified ones are planned as .phmnew (see conffileTarget). Conflicts
+// with other packages fail as they would during installation.
+func (m *Manager) PlanInstall(pkgPath string, opts InstallOptions) (*InstallPlan, error) {
+	if err := validateInstallSlot(opts.InstallSlot); err != nil {
+		return nil, err
+	}
+	pkgInfo, sourceSlot, err := readPkgInfo(pkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package metadata: %w", err)
+	}
+
+	pkgName := pkgInfo.Name
+	if opts.CustomName != "" {
+		pkgName = opts.CustomName
+	}
+	installSlot := sourceSlot
+	if opts.InstallSlot != "" {
+		installSlot = opts.InstallSlot
+	}
+	takeovers, err := m.fileTakeovers(pkgPath, sourceSlot, installSlot, pkgInfo, pkgName, opts.ForceOverwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	pristine := make(map[string]string)
+	if previous := m.installed[pkgName]; previous != nil {
+		for _, f := range previous.Files {
+			if f.Config {
+				pristine[f.Path] = f.SHA256
+			}
+		}
+	}
+
+	plan := &InstallPlan{Takeovers: takeovers}
+	err = m.walkTarball(pkgPath, sourceSlot, opts.InstallSlot, func(r io.Reader, header *tar.Header, destPath string) error {
+		target := destPath
+		if !isBinaryPath(destPath) {
+			// The checksum is taken after placeholders are replaced, as when installing
+			tmpPath, sum, _, err := m.extractEntry(r, header, destPath)
+			if err != nil {
+				return err
+			}
+			os.Remove(tmpPath)
+			if target = conffileTarget(destPath, sum, pristine[destPath]); target == "" {
+				return nil
+			}
+		}
+		plan.Files = append(plan.Files, PlannedFile{Path: target, Replaces: fileExists(target)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanRemove returns the files Remove would delete for an installed package,
+// sorted. Files of other packages and paths outside the installation are kept.
+func (m *Manager) PlanRemove(name string) ([]string, error) {
+	p := m.installed[name]
+	if p == nil {
+		return nil, fmt.Errorf("package not installed: %s", name)
+	}
+
+	var files []string
+	ownedByOthers := m.ownedByOthers(name)
+	for _, file := range p.InstalledFiles {
+		cleanFile := filepath.Clean(file)
+		if ownedByOthers[cleanFile] || m.validateInstallPath(cleanFile) != nil {
+			continue
+		}
+		files = append(files, cleanFile)
+		if newFile := cleanFile + conffileSuffix; !isBinaryPath(cleanFile) && fileExists(newFile) {
+			files = append(files, newFile)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
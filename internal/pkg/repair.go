@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// RepairResult lists what Repair changed
+type RepairResult struct {
+	Restored []string // missing or damaged files extracted again
+	Modes    []string // files whose permissions were reset
+	Kept     []string // locally modified config files left alone
+}
+
+// Repair extracts missing or damaged files of an installed package again from
+// its tarball, which must be the installed build. Files that match the package
+// are not touched (only their mode is fixed), config files that exist are kept
+// even if modified, and files taken over by another package are skipped. Paths
+// are validated and rewritten to the install slot exactly as during
+// installation. The package database is updated with the checksums of all files.
+func (m *Manager) Repair(name, pkgPath string) (*RepairResult, error) {
+	installed := m.installed[name]
+	if installed == nil {
+		return nil, fmt.Errorf("package not installed: %s", name)
+	}
+	if err := validateInstallSlot(installed.InstallSlot); err != nil {
+		return nil, err
+	}
+
+	pkgInfo, sourceSlot, err := readPkgInfo(pkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package metadata: %w", err)
+	}
+	if pkgInfo.Version != installed.Version || pkgInfo.Revision != installed.Revision {
+		return nil, fmt.Errorf("package file is %s-%d but %s %s-%d is installed",
+			pkgInfo.Version, pkgInfo.Revision, name, installed.Version, installed.Revision)
+	}
+
+	result := &RepairResult{}
+	var installedFiles []string
+	var files []InstalledFile
+	ownedByOthers := m.ownedByOthers(name)
+	err = m.walkTarball(pkgPath, sourceSlot, installed.InstallSlot, func(r io.Reader, header *tar.Header, destPath string) error {
+		// Files taken over by another package are not restored
+		if ownedByOthers[filepath.Clean(destPath)] {
+			return nil
+		}
+
+		safeMode := sanitizeFileMode(header.Mode)
+		config := !isBinaryPath(destPath)
+
+		tmpPath, sum, size, err := m.extractEntry(r, header, destPath)
+		if err != nil {
+			return err
+		}
+		installedFiles = append(installedFiles, destPath)
+		files = append(files, InstalledFile{Path: destPath, SHA256: sum, Size: size, Mode: safeMode, Config: config})
+
+		info, statErr := os.Lstat(destPath)
+		if statErr == nil && info.Mode().IsRegular() {
+			diskSum, hashErr := copyAndHash(io.Discard, destPath)
+			switch {
+			case hashErr == nil && diskSum == sum:
+				os.Remove(tmpPath)
+				if config || info.Mode().Perm() == safeMode {
+					return nil
+				}
+				if err := m.journalFile(destPath); err != nil {
+					return fmt.Errorf("failed to journal %s: %w", destPath, err)
+				}
+				if err := m.fs.Chmod(destPath, safeMode); err != nil {
+					return err
+				}
+				result.Modes = append(result.Modes, destPath)
+				return nil
+			case config:
+				// Local edits to config files are kept
+				os.Remove(tmpPath)
+				result.Kept = append(result.Kept, destPath)
+				return nil
+			}
+		}
+
+		destDir := filepath.Dir(destPath)
+		if err := m.fs.MkdirAll(destDir); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		if err := m.journalFile(destPath); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to journal %s: %w", destPath, err)
+		}
+		if err := m.fs.Install(tmpPath, destPath, safeMode); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", destPath, err)
+		}
+		result.Restored = append(result.Restored, destPath)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Older database entries have no checksums; record them now
+	updated := *installed
+	updated.InstalledFiles = installedFiles
+	updated.Files = files
+	if err := m.journalEntry(name); err != nil {
+		return nil, fmt.Errorf("failed to journal database entry: %w", err)
+	}
+	if err := m.saveInstalled(&updated); err != nil {
+		return nil, err
+	}
+	m.installed[name] = &updated
+
+	return result, nil
+}
@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Lifecycle scripts a package may ship in its scripts/ directory
+const (
+	ScriptPreinst  = "preinst"  // before files are installed
+	ScriptPostinst = "postinst" // after files are installed
+	ScriptPrerm    = "prerm"    // before files are removed
+	ScriptPostrm   = "postrm"   // after files are removed
+)
+
+// ScriptNames lists the supported lifecycle scripts in execution order
+var ScriptNames = []string{ScriptPreinst, ScriptPostinst, ScriptPrerm, ScriptPostrm}
+
+// Script actions (first argument and PHM_ACTION of a lifecycle script)
+const (
+	ScriptActionInstall = "install"
+	ScriptActionUpgrade = "upgrade"
+	ScriptActionRemove  = "remove"
+)
+
+// DefaultScriptTimeout limits how long a lifecycle script may run
+const DefaultScriptTimeout = 5 * time.Minute
+
+// maxScriptSize is the maximum size of a lifecycle script (1MB)
+const maxScriptSize int64 = 1 * 1024 * 1024
+
+// scriptsDirName holds the scripts of installed packages (under <prefix>/.phm/scripts/<package>).
+// It is written through the FS like the installed files, so the scripts that run
+// as root cannot be changed by the user who owns DataDir.
+const scriptsDirName = ".phm/scripts"
+
+// ScriptError reports a lifecycle script that failed or timed out
+type ScriptError struct {
+	Package string
+	Script  string
+	Err     error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("%s script of %s failed: %v", e.Script, e.Package, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// isScriptName reports whether name is a supported lifecycle script
+func isScriptName(name string) bool {
+	for _, s := range ScriptNames {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetScriptTimeout sets how long lifecycle scripts may run (0 uses DefaultScriptTimeout)
+func (m *Manager) SetScriptTimeout(timeout time.Duration) {
+	m.scriptTimeout = timeout
+}
+
+// readScripts returns the lifecycle scripts in a package tarball by name
+func readScripts(pkgPath string) (map[string][]byte, error) {
+	f, err := os.Open(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	scripts := make(map[string][]byte)
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return scripts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header.Name, "scripts/") || header.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "scripts/"))
+		if !isScriptName(name) {
+			return nil, fmt.Errorf("unknown package script %q (supported: %s)", header.Name, strings.Join(ScriptNames, ", "))
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			return nil, fmt.Errorf("package script %s is not a regular file", header.Name)
+		}
+		if header.Size > maxScriptSize {
+			return nil, fmt.Errorf("package script %s exceeds maximum size (%d > %d bytes)", header.Name, header.Size, maxScriptSize)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxScriptSize))
+		if err != nil {
+			return nil, err
+		}
+		scripts[name] = data
+	}
+}
+
+// scriptDir returns where the scripts of an installed package are kept
+func (m *Manager) scriptDir(name string) string {
+	return filepath.Join(m.installPrefix, filepath.FromSlash(scriptsDirName), name)
+}
+
+// storeScripts replaces the stored scripts of a package, so prerm and postrm
+// can run when it is upgraded or removed. old lists the scripts stored for the
+// installed version. Returns the names of the stored scripts.
+func (m *Manager) storeScripts(name string, old []string, scripts map[string][]byte) ([]string, error) {
+	dir := m.scriptDir(name)
+	var stored []string
+	for _, script := range ScriptNames {
+		p := filepath.Join(dir, script)
+		data, ok := scripts[script]
+		if !ok && !slices.Contains(old, script) {
+			continue
+		}
+		if err := m.journalFile(p); err != nil {
+			return nil, fmt.Errorf("failed to journal %s: %w", p, err)
+		}
+		if !ok {
+			if err := m.fs.Remove(p); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := m.fs.MkdirAll(dir); err != nil {
+			return nil, err
+		}
+		if err := m.fs.WriteFile(p, data, 0755); err != nil {
+			return nil, fmt.Errorf("failed to store %s script: %w", script, err)
+		}
+		stored = append(stored, script)
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		_ = m.fs.Remove(dir)
+	}
+	return stored, nil
+}
+
+// scriptEnv describes the operation a lifecycle script runs for
+type scriptEnv struct {
+	Action     string // install, upgrade or remove
+	Package    string
+	Version    string // version-revision being installed or removed
+	OldVersion string // previously installed version-revision (upgrade)
+	Slot       string
+}
+
+// environ returns the PHM_* variables passed to lifecycle scripts
+func (e scriptEnv) environ(prefix, root string) []string {
+	return []string{
+		"PHM_ACTION=" + e.Action,
+		"PHM_PACKAGE=" + e.Package,
+		"PHM_VERSION=" + e.Version,
+		"PHM_OLD_VERSION=" + e.OldVersion,
+		"PHM_SLOT=" + e.Slot,
+		"PHM_PREFIX=" + prefix,
+		"PHM_SLOT_DIR=" + filepath.Join(prefix, e.Slot),
+		"PHM_ROOT=" + root,
+	}
+}
+
+// runScript runs a lifecycle script with /bin/sh as `script <action> [version]`
+// with the privileges of the FS (as root through sudo by default), and kills it
+// when it exceeds the script timeout. Like dpkg, the scripts of the new package
+// get the old version and the scripts of the package being replaced get the new one.
+func (m *Manager) runScript(scriptPath, script string, env scriptEnv, version string) error {
+	timeout := m.scriptTimeout
+	if timeout <= 0 {
+		timeout = DefaultScriptTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := []string{scriptPath, env.Action}
+	if version != "" {
+		args = append(args, version)
+	}
+	vars := env.environ(m.installPrefix, m.layout.Root)
+
+	cmd := m.fs.Command(ctx, vars, "/bin/sh", args...)
+	cmd.Dir = "/"
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return &ScriptError{Package: env.Package, Script: script, Err: err}
+	}
+	return nil
+}
+
+// runPackageScript runs a script from a package tarball that is not stored yet
+// (preinst). It is staged next to the stored scripts, so it runs from a
+// location the user cannot write to.
+func (m *Manager) runPackageScript(scripts map[string][]byte, script string, env scriptEnv) error {
+	data, ok := scripts[script]
+	if !ok {
+		return nil
+	}
+
+	dir := m.scriptDir(env.Package)
+	staged := filepath.Join(dir, script+".new")
+	if err := m.journalFile(staged); err != nil {
+		return fmt.Errorf("failed to journal %s: %w", staged, err)
+	}
+	if err := m.fs.MkdirAll(dir); err != nil {
+		return err
+	}
+	if err := m.fs.WriteFile(staged, data, 0755); err != nil {
+		return fmt.Errorf("failed to write %s script: %w", script, err)
+	}
+	defer func() {
+		_ = m.fs.Remove(staged)
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			_ = m.fs.Remove(dir)
+		}
+	}()
+	return m.runScript(staged, script, env, env.OldVersion)
+}
+
+// runInstalledScript runs a stored script of an installed package, if it has
+// one, with version as argument
+func (m *Manager) runInstalledScript(p *InstalledPackage, script string, env scriptEnv, version string) error {
+	if !slices.Contains(p.Scripts, script) {
+		return nil
+	}
+	return m.runScript(filepath.Join(m.scriptDir(p.Name), script), script, env, version)
+}
@@ -0,0 +1,529 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxSolverSteps bounds the backtracking search
+const maxSolverSteps = 100000
+
+// Clause is a single requirement; any one of its alternatives satisfies it ("a | b")
+type Clause []Dependency
+
+// String formats the clause in relation syntax
+func (c Clause) String() string {
+	alternatives := make([]string, len(c))
+	for i, d := range c {
+		alternatives[i] = d.String()
+	}
+	return strings.Join(alternatives, " | ")
+}
+
+// MatchesPackage reports whether p satisfies any alternative of the clause
+func (c Clause) MatchesPackage(p *Package) bool {
+	for _, d := range c {
+		if d.MatchesPackage(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the dependency in relation syntax
+func (d Dependency) String() string {
+	if d.Constraint == "" {
+		return d.Name
+	}
+	return fmt.Sprintf("%s (%s %s)", d.Name, d.Constraint, d.Version)
+}
+
+// MatchesPackage reports whether p satisfies the dependency, either by name or
+// through Provides (a provided name carries the providing package's version)
+func (d Dependency) MatchesPackage(p *Package) bool {
+	if p.Name != d.Name {
+		found := false
+		for _, provided := range p.Provides {
+			if ParseDependency(provided).Name == d.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return d.SatisfiedBy(p.Version)
+}
+
+// ParseRelations parses a Depends or Conflicts entry (Debian syntax). An entry may
+// hold several comma-separated clauses, each with "|"-separated alternatives:
+//
+//	"php8.5-common (>= 8.5.0), php8.5-common (<< 8.6)"
+//	"php8.5-apcu | php8.5-opcache"
+func ParseRelations(entry string) ([]Clause, error) {
+	var clauses []Clause
+	for _, part := range strings.Split(entry, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var clause Clause
+		for _, alt := range strings.Split(part, "|") {
+			alt = strings.TrimSpace(alt)
+			if m := dependencyRegex.FindStringSubmatch(alt); m != nil {
+				clause = append(clause, Dependency{Name: m[1], Constraint: m[2], Version: m[3]})
+				continue
+			}
+			if !safeNameRegex.MatchString(alt) {
+				return nil, fmt.Errorf("invalid relation %q: expected \"name\" or \"name (op version)\"", alt)
+			}
+			clause = append(clause, Dependency{Name: alt})
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+// packageClauses parses all Depends entries of a package
+func packageClauses(p *Package) ([]Clause, error) {
+	var clauses []Clause
+	for _, entry := range p.Depends {
+		parsed, err := ParseRelations(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name, err)
+		}
+		clauses = append(clauses, parsed...)
+	}
+	return clauses, nil
+}
+
+// CompareBuilds orders two builds of a package by version, then revision
+func CompareBuilds(a, b *Package) int {
+	if cmp := compareVersions(a.Version, b.Version); cmp != 0 {
+		return cmp
+	}
+	return a.Revision - b.Revision
+}
+
+// label identifies a package build in explanations
+func label(p *Package) string {
+	return p.Name + " " + p.Version
+}
+
+// UnsatisfiableError explains why no consistent set of packages exists
+type UnsatisfiableError struct {
+	Chain       []string // dependency path to the failing requirement, root first
+	Requirement string   // the requirement that could not be met
+	Rejected    []string // why each candidate was rejected
+}
+
+func (e *UnsatisfiableError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cannot satisfy %s", e.Requirement)
+	if len(e.Chain) > 0 {
+		fmt.Fprintf(&b, " (required by %s)", strings.Join(e.Chain, " -> "))
+	}
+	if len(e.Rejected) == 0 {
+		b.WriteString(": no package provides it")
+	}
+	for _, r := range e.Rejected {
+		fmt.Fprintf(&b, "\n    %s", r)
+	}
+	return b.String()
+}
+
+// ConflictError reports two packages that cannot be installed together
+type ConflictError struct {
+	Package   string // package declaring or hit by the conflict
+	Other     string // the conflicting package
+	Relation  string // the Conflicts entry that matched
+	Installed bool   // Other is already installed
+}
+
+func (e *ConflictError) Error() string {
+	if e.Installed {
+		return fmt.Sprintf("%s conflicts with installed package %s (%s); remove %s first", e.Package, e.Other, e.Relation, e.Other)
+	}
+	return fmt.Sprintf("%s conflicts with %s (%s); they cannot be installed together", e.Package, e.Other, e.Relation)
+}
+
+// conflictRelation returns the Conflicts entry of a that matches b, if any
+func conflictRelation(a, b *Package) string {
+	for _, entry := range a.Conflicts {
+		clauses, err := ParseRelations(entry)
+		if err != nil {
+			continue
+		}
+		for _, clause := range clauses {
+			for _, d := range clause {
+				if d.MatchesPackage(b) {
+					return d.String()
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// CheckConflicts checks a set of packages to be installed against each other
+// and against installed packages (in both directions). Installed packages that
+// are replaced by a package of the same name are ignored.
+func (m *Manager) CheckConflicts(packages []Package) error {
+	for i := range packages {
+		a := &packages[i]
+
+		for j := range packages {
+			b := &packages[j]
+			if a.Name == b.Name {
+				continue
+			}
+			if rel := conflictRelation(a, b); rel != "" {
+				return &ConflictError{Package: a.Name, Other: b.Name, Relation: rel}
+			}
+		}
+
+		for _, installed := range m.sortedInstalled() {
+			if replaced(installed, packages) {
+				continue
+			}
+			if rel := conflictRelation(a, &installed.Package); rel != "" {
+				return &ConflictError{Package: a.Name, Other: installed.Name, Relation: rel, Installed: true}
+			}
+			// Declared by the installed package: it still has to be removed first
+			if rel := conflictRelation(&installed.Package, a); rel != "" {
+				return &ConflictError{Package: a.Name, Other: installed.Name, Relation: rel, Installed: true}
+			}
+		}
+	}
+	return nil
+}
+
+// replaced reports whether an installed package is being reinstalled or upgraded by packages
+func replaced(installed *InstalledPackage, packages []Package) bool {
+	for i := range packages {
+		if packages[i].Name == installed.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedInstalled returns installed packages ordered by name (deterministic iteration)
+func (m *Manager) sortedInstalled() []*InstalledPackage {
+	result := m.GetAllInstalled()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// ResolveDependencies resolves all dependencies for a package.
+// Returns the packages to install in dependency order (dependencies first),
+// ending with pkg itself; already installed packages that satisfy a
+// requirement are not included.
+func (m *Manager) ResolveDependencies(pkg *Package, available []Package) ([]Package, error) {
+	result, err := m.Resolve([]Package{*pkg}, available)
+	if err != nil {
+		return nil, err
+	}
+	return result[0], nil
+}
+
+// Resolve finds one consistent set of packages that satisfies the Depends of
+// all roots, choosing among all versions in available:
+//   - "a | b" alternatives are tried in order;
+//   - installed packages are preferred, then the newest matching build;
+//   - a dependency may be satisfied by a package that Provides the name;
+//   - Conflicts of selected and installed packages are respected, and installed
+//     packages are not upgraded in a way that breaks other installed packages.
+//
+// The search backtracks over these choices. For each root, the packages it
+// needs are returned in dependency order, as with ResolveDependencies. If no
+// solution exists, an *UnsatisfiableError explains which requirement failed.
+func (m *Manager) Resolve(roots []Package, available []Package) ([][]Package, error) {
+	s := newSolver(m, available)
+
+	sel := &selection{byName: make(map[string]*Package)}
+	var agenda []requirement
+	rootSet := make(map[*Package]bool)
+	for i := range roots {
+		root := &roots[i]
+		if reason := s.reject(root, sel); reason != "" {
+			return nil, &UnsatisfiableError{Requirement: label(root), Rejected: []string{reason}}
+		}
+		clauses, err := packageClauses(root)
+		if err != nil {
+			return nil, err
+		}
+		sel = sel.with(root)
+		rootSet[root] = true
+		for _, c := range clauses {
+			agenda = append(agenda, requirement{clause: c, chain: []string{label(root)}})
+		}
+	}
+
+	chosen, err := s.solve(agenda, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dependencies first (post-order), skipping installed packages that were kept
+	result := make([][]Package, len(roots))
+	for i := range roots {
+		visited := make(map[*Package]bool)
+		var visit func(p *Package)
+		visit = func(p *Package) {
+			if visited[p] {
+				return
+			}
+			visited[p] = true
+			clauses, _ := packageClauses(p)
+			for _, c := range clauses {
+				if dep := chosen.satisfying(c); dep != nil {
+					visit(dep)
+				}
+			}
+			if rootSet[p] || !s.installed[p] {
+				result[i] = append(result[i], *p)
+			}
+		}
+		visit(&roots[i])
+	}
+
+	return result, nil
+}
+
+// requirement is a clause waiting to be satisfied
+type requirement struct {
+	clause Clause
+	chain  []string // packages that led to this requirement, root first
+}
+
+// selection is an immutable set of chosen packages (at most one build per name)
+type selection struct {
+	byName map[string]*Package
+	order  []*Package
+}
+
+// with returns a copy of the selection that includes p
+func (sel *selection) with(p *Package) *selection {
+	next := &selection{
+		byName: make(map[string]*Package, len(sel.byName)+1),
+		order:  make([]*Package, len(sel.order), len(sel.order)+1),
+	}
+	for k, v := range sel.byName {
+		next.byName[k] = v
+	}
+	copy(next.order, sel.order)
+	next.byName[p.Name] = p
+	next.order = append(next.order, p)
+	return next
+}
+
+// satisfying returns the selected package that satisfies the clause, if any
+func (sel *selection) satisfying(c Clause) *Package {
+	for _, d := range c {
+		for _, p := range sel.order {
+			if d.MatchesPackage(p) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// solver is a backtracking dependency solver
+type solver struct {
+	m          *Manager
+	installed  map[*Package]bool     // candidates that are installed packages
+	sorted     []*InstalledPackage   // installed packages by name
+	candidates map[string][]*Package // by real and provided name, in preference order
+	steps      int
+}
+
+func newSolver(m *Manager, available []Package) *solver {
+	s := &solver{
+		m:          m,
+		installed:  make(map[*Package]bool),
+		sorted:     m.sortedInstalled(),
+		candidates: make(map[string][]*Package),
+	}
+
+	add := func(p *Package) {
+		s.candidates[p.Name] = append(s.candidates[p.Name], p)
+		for _, provided := range p.Provides {
+			if name := ParseDependency(provided).Name; name != p.Name {
+				s.candidates[name] = append(s.candidates[name], p)
+			}
+		}
+	}
+	for _, inst := range s.sorted {
+		s.installed[&inst.Package] = true
+		add(&inst.Package)
+	}
+	for i := range available {
+		// The installed build stands in for an identical available one
+		if inst := m.installed[available[i].Name]; inst != nil && CompareBuilds(&inst.Package, &available[i]) == 0 {
+			continue
+		}
+		add(&available[i])
+	}
+
+	// Installed first, then real packages before providers, then newest first
+	for name, list := range s.candidates {
+		sort.SliceStable(list, func(i, j int) bool {
+			a, b := list[i], list[j]
+			if s.installed[a] != s.installed[b] {
+				return s.installed[a]
+			}
+			if (a.Name == name) != (b.Name == name) {
+				return a.Name == name
+			}
+			return CompareBuilds(a, b) > 0
+		})
+	}
+
+	return s
+}
+
+// solve satisfies the agenda in order, backtracking over candidate choices
+func (s *solver) solve(agenda []requirement, sel *selection) (*selection, error) {
+	for len(agenda) > 0 && sel.satisfying(agenda[0].clause) != nil {
+		agenda = agenda[1:]
+	}
+	if len(agenda) == 0 {
+		return sel, nil
+	}
+	req, rest := agenda[0], agenda[1:]
+
+	var rejected []string
+	tried := make(map[*Package]bool)
+	for _, d := range req.clause {
+		for _, p := range s.candidates[d.Name] {
+			if tried[p] {
+				continue
+			}
+			tried[p] = true
+
+			if !d.MatchesPackage(p) {
+				rejected = append(rejected, fmt.Sprintf("%s%s: does not match %s", label(p), s.tag(p), d))
+				continue
+			}
+			if reason := s.reject(p, sel); reason != "" {
+				rejected = append(rejected, fmt.Sprintf("%s%s: %s", label(p), s.tag(p), reason))
+				continue
+			}
+
+			s.steps++
+			if s.steps > maxSolverSteps {
+				return nil, fmt.Errorf("dependency resolution is too complex (gave up after %d steps)", maxSolverSteps)
+			}
+
+			clauses, err := packageClauses(p)
+			if err != nil {
+				return nil, err
+			}
+			chain := append(append([]string{}, req.chain...), label(p))
+			next := append([]requirement{}, rest...)
+			for _, c := range clauses {
+				next = append(next, requirement{clause: c, chain: chain})
+			}
+
+			result, err := s.solve(next, sel.with(p))
+			if err == nil {
+				return result, nil
+			}
+			unsat, ok := err.(*UnsatisfiableError)
+			if !ok {
+				return nil, err
+			}
+			reason := strings.SplitN(unsat.Error(), "\n", 2)[0]
+			rejected = append(rejected, fmt.Sprintf("%s%s: %s", label(p), s.tag(p), reason))
+		}
+	}
+
+	return nil, &UnsatisfiableError{Chain: req.chain, Requirement: req.clause.String(), Rejected: rejected}
+}
+
+// tag marks installed candidates in explanations
+func (s *solver) tag(p *Package) string {
+	if s.installed[p] {
+		return " (installed)"
+	}
+	return ""
+}
+
+// reject returns why p cannot be added to the selection ("" if it can)
+func (s *solver) reject(p *Package, sel *selection) string {
+	if q := sel.byName[p.Name]; q != nil && q != p {
+		return fmt.Sprintf("%s is already selected", label(q))
+	}
+
+	for _, q := range sel.order {
+		if q.Name == p.Name {
+			continue
+		}
+		if rel := conflictRelation(p, q); rel != "" {
+			return fmt.Sprintf("conflicts with %s (%s)", label(q), rel)
+		}
+		if rel := conflictRelation(q, p); rel != "" {
+			return fmt.Sprintf("%s conflicts with it (%s)", label(q), rel)
+		}
+	}
+
+	// Installed packages that stay as they are
+	for _, inst := range s.sorted {
+		if inst.Name == p.Name || sel.byName[inst.Name] != nil {
+			continue
+		}
+		if rel := conflictRelation(p, &inst.Package); rel != "" {
+			return fmt.Sprintf("conflicts with installed %s (%s)", label(&inst.Package), rel)
+		}
+		if rel := conflictRelation(&inst.Package, p); rel != "" {
+			return fmt.Sprintf("installed %s conflicts with it (%s)", label(&inst.Package), rel)
+		}
+	}
+
+	// Replacing an installed build must not break other installed packages
+	old := s.m.installed[p.Name]
+	if old == nil || s.installed[p] {
+		return ""
+	}
+	for _, inst := range s.sorted {
+		if inst.Name == p.Name || sel.byName[inst.Name] != nil {
+			continue
+		}
+		clauses, err := packageClauses(&inst.Package)
+		if err != nil {
+			continue
+		}
+		for _, c := range clauses {
+			if !c.MatchesPackage(&old.Package) || c.MatchesPackage(p) {
+				continue
+			}
+			if sel.satisfying(c) != nil || s.keptInstalledSatisfies(c, p.Name, sel) {
+				continue
+			}
+			return fmt.Sprintf("would break installed %s (requires %s)", label(&inst.Package), c)
+		}
+	}
+
+	return ""
+}
+
+// keptInstalledSatisfies reports whether an installed package that is neither
+// replaced nor named replacing satisfies the clause
+func (s *solver) keptInstalledSatisfies(c Clause, replacing string, sel *selection) bool {
+	for _, inst := range s.sorted {
+		if inst.Name == replacing || sel.byName[inst.Name] != nil {
+			continue
+		}
+		if c.MatchesPackage(&inst.Package) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package pkg
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRelations(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    []Clause
+		wantErr bool
+	}{
+		{entry: "a", want: []Clause{{{Name: "a"}}}},
+		{entry: "a (>= 1) | b", want: []Clause{{{Name: "a", Constraint: ">=", Version: "1"}, {Name: "b"}}}},
+		{entry: " a(<< 2.0) ,  b ", want: []Clause{{{Name: "a", Constraint: "<<", Version: "2.0"}}, {{Name: "b"}}}},
+		{entry: "php8.5-apcu | php8.5-opcache (= 8.5.0), php8.5-common (>> 8.4)", want: []Clause{
+			{{Name: "php8.5-apcu"}, {Name: "php8.5-opcache", Constraint: "=", Version: "8.5.0"}},
+			{{Name: "php8.5-common", Constraint: ">>", Version: "8.4"}},
+		}},
+		{entry: "", want: nil},
+		{entry: "a (>= )", wantErr: true},
+		{entry: "a (~> 1)", wantErr: true},
+		{entry: "a (>= 1", wantErr: true},
+		{entry: "(>= 1)", wantErr: true},
+		{entry: "a |", wantErr: true},
+		{entry: "| b", wantErr: true},
+		{entry: "a b", wantErr: true},
+		{entry: "a (>= x1)", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			got, err := ParseRelations(tt.entry)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseRelations(%q) = %v, want error", tt.entry, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRelations(%q): %v", tt.entry, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRelations(%q) = %#v, want %#v", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed []Package
+		available []Package
+		root      Package
+		want      []string // "name version" in install order
+		wantErr   string   // exact UnsatisfiableError message
+	}{
+		{
+			name:      "first alternative",
+			available: []Package{{Name: "a", Version: "1.0"}, {Name: "b", Version: "1.0"}},
+			root:      Package{Name: "app", Version: "1.0", Depends: []string{"a | b"}},
+			want:      []string{"a 1.0", "app 1.0"},
+		},
+		{
+			name:      "fall back to an alternative",
+			available: []Package{{Name: "a", Version: "1.0"}, {Name: "b", Version: "1.0"}},
+			root:      Package{Name: "app", Version: "1.0", Depends: []string{"a (>= 2) | b"}},
+			want:      []string{"b 1.0", "app 1.0"},
+		},
+		{
+			name:      "installed build is kept",
+			installed: []Package{{Name: "lib", Version: "1.0"}},
+			available: []Package{{Name: "lib", Version: "1.0"}, {Name: "lib", Version: "2.0"}},
+			root:      Package{Name: "app", Version: "1.0", Depends: []string{"lib"}},
+			want:      []string{"app 1.0"},
+		},
+		{
+			name:      "newest matching build",
+			available: []Package{{Name: "lib", Version: "1.0"}, {Name: "lib", Version: "2.1"}, {Name: "lib", Version: "3.0"}},
+			root:      Package{Name: "app", Version: "1.0", Depends: []string{"lib (<< 3)"}},
+			want:      []string{"lib 2.1", "app 1.0"},
+		},
+		{
+			name: "virtual package through Provides",
+			available: []Package{
+				{Name: "php8.5-opcache", Version: "8.5.0", Provides: []string{"php8.5-opcode-cache"}},
+			},
+			root: Package{Name: "app", Version: "1.0", Depends: []string{"php8.5-opcode-cache (>= 8.5)"}},
+			want: []string{"php8.5-opcache 8.5.0", "app 1.0"},
+		},
+		{
+			name: "real package before provider",
+			available: []Package{
+				{Name: "mailer", Version: "1.0"},
+				{Name: "sendmail", Version: "9.0", Provides: []string{"mailer"}},
+			},
+			root: Package{Name: "app", Version: "1.0", Depends: []string{"mailer"}},
+			want: []string{"mailer 1.0", "app 1.0"},
+		},
+		{
+			name: "conflict forces backtracking",
+			available: []Package{
+				{Name: "x", Version: "1.0"},
+				{Name: "x", Version: "2.0", Conflicts: []string{"y"}},
+				{Name: "y", Version: "1.0"},
+			},
+			root: Package{Name: "app", Version: "1.0", Depends: []string{"x", "y"}},
+			want: []string{"x 1.0", "y 1.0", "app 1.0"},
+		},
+		{
+			name: "conflict with installed package forces alternative",
+			installed: []Package{
+				{Name: "legacy", Version: "1.0", Conflicts: []string{"a"}},
+			},
+			available: []Package{{Name: "a", Version: "1.0"}, {Name: "b", Version: "1.0"}},
+			root:      Package{Name: "app", Version: "1.0", Depends: []string{"a | b"}},
+			want:      []string{"b 1.0", "app 1.0"},
+		},
+		{
+			name:      "no matching version",
+			available: []Package{{Name: "lib", Version: "1.0"}},
+			root:      Package{Name: "app", Version: "1.0", Depends: []string{"lib (>= 2)"}},
+			wantErr: "cannot satisfy lib (>= 2) (required by app 1.0)\n" +
+				"    lib 1.0: does not match lib (>= 2)",
+		},
+		{
+			name:    "missing package",
+			root:    Package{Name: "app", Version: "1.0", Depends: []string{"nothing"}},
+			wantErr: "cannot satisfy nothing (required by app 1.0): no package provides it",
+		},
+		{
+			name: "transitive failure",
+			available: []Package{
+				{Name: "x", Version: "1.0", Depends: []string{"y (>= 2)"}},
+				{Name: "y", Version: "1.0"},
+			},
+			root: Package{Name: "app", Version: "1.0", Depends: []string{"x"}},
+			wantErr: "cannot satisfy x (required by app 1.0)\n" +
+				"    x 1.0: cannot satisfy y (>= 2) (required by app 1.0 -> x 1.0)",
+		},
+		{
+			name: "every alternative conflicts with a later requirement",
+			available: []Package{
+				{Name: "a", Version: "1.0", Conflicts: []string{"c"}},
+				{Name: "b", Version: "1.0", Conflicts: []string{"c"}},
+				{Name: "c", Version: "1.0"},
+			},
+			root: Package{Name: "app", Version: "1.0", Depends: []string{"a | b", "c"}},
+			wantErr: "cannot satisfy a | b (required by app 1.0)\n" +
+				"    a 1.0: cannot satisfy c (required by app 1.0)\n" +
+				"    b 1.0: cannot satisfy c (required by app 1.0)",
+		},
+		{
+			name:      "root conflicts with installed package",
+			installed: []Package{{Name: "legacy", Version: "1.0", Conflicts: []string{"app"}}},
+			root:      Package{Name: "app", Version: "1.0"},
+			wantErr:   "cannot satisfy app 1.0\n    installed legacy 1.0 conflicts with it (app)",
+		},
+		{
+			name: "upgrade would break installed package",
+			installed: []Package{
+				{Name: "lib", Version: "1.0"},
+				{Name: "tool", Version: "1.0", Depends: []string{"lib (<< 2)"}},
+			},
+			available: []Package{{Name: "lib", Version: "2.0"}},
+			root:      Package{Name: "app", Version: "1.0", Depends: []string{"lib (>= 2)"}},
+			wantErr: "cannot satisfy lib (>= 2) (required by app 1.0)\n" +
+				"    lib 1.0 (installed): does not match lib (>= 2)\n" +
+				"    lib 2.0: would break installed tool 1.0 (requires lib (<< 2))",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(t.TempDir(), t.TempDir())
+			for _, p := range tt.installed {
+				m.installed[p.Name] = &InstalledPackage{Package: p}
+			}
+
+			result, err := m.Resolve([]Package{tt.root}, tt.available)
+			if tt.wantErr != "" {
+				var unsat *UnsatisfiableError
+				if !errors.As(err, &unsat) {
+					t.Fatalf("Resolve = %v, %v; want UnsatisfiableError", result, err)
+				}
+				if got := err.Error(); got != tt.wantErr {
+					t.Errorf("error:\n%s\nwant:\n%s", got, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+
+			var got []string
+			for _, p := range result[0] {
+				got = append(got, label(&p))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve = [%s], want [%s]", strings.Join(got, ", "), strings.Join(tt.want, ", "))
+			}
+		})
+	}
+}
+
+func TestCheckConflicts(t *testing.T) {
+	m := NewManager(t.TempDir(), t.TempDir())
+	m.installed["legacy"] = &InstalledPackage{Package: Package{Name: "legacy", Version: "1.0", Conflicts: []string{"app (<< 2)"}}}
+
+	var conflict *ConflictError
+	err := m.CheckConflicts([]Package{{Name: "app", Version: "1.5"}})
+	if !errors.As(err, &conflict) || !conflict.Installed || conflict.Package != "app" || conflict.Other != "legacy" {
+		t.Errorf("CheckConflicts(app 1.5) = %v, want conflict with installed legacy", err)
+	}
+	if err := m.CheckConflicts([]Package{{Name: "app", Version: "2.0"}}); err != nil {
+		t.Errorf("CheckConflicts(app 2.0) = %v", err)
+	}
+	// Upgrading the conflicting package itself lifts the conflict
+	if err := m.CheckConflicts([]Package{{Name: "app", Version: "1.5"}, {Name: "legacy", Version: "2.0"}}); err != nil {
+		t.Errorf("CheckConflicts(app 1.5, legacy 2.0) = %v", err)
+	}
+}
@@ -0,0 +1,591 @@
+package pkg
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Transaction action kinds
+const (
+	ActionInstall = "install"
+	ActionRemove  = "remove"
+	ActionRepair  = "repair"
+)
+
+// journalDirName is the directory under DataDir that holds the running transaction
+const journalDirName = "journal"
+
+// TransactionAction is one step of a transaction
+type TransactionAction struct {
+	Kind    string         `json:"kind"`
+	Name    string         `json:"name"`
+	Path    string         `json:"path,omitempty"` // package tarball (install, repair)
+	Options InstallOptions `json:"options"`
+	Done    bool           `json:"done,omitempty"`
+}
+
+// TransactionInfo describes a transaction as stored in its journal
+type TransactionInfo struct {
+	Operation string              `json:"operation"`
+	StartedAt time.Time           `json:"started_at"`
+	Actions   []TransactionAction `json:"actions"`
+	Command   string              `json:"command,omitempty"` // command line, for the history
+	User      string              `json:"user,omitempty"`
+	// Previous holds the installed builds of the changed packages before the transaction
+	Previous map[string]*HistoryVersion `json:"previous,omitempty"`
+	Changes  int                        `json:"-"` // files and database entries changed so far
+}
+
+// Pending returns the number of actions that have not completed
+func (i *TransactionInfo) Pending() int {
+	n := 0
+	for _, a := range i.Actions {
+		if !a.Done {
+			n++
+		}
+	}
+	return n
+}
+
+// journalRecord holds the previous state of a file or database entry.
+// It is written (and synced) before the transaction changes it.
+type journalRecord struct {
+	File   string      `json:"file,omitempty"`   // installed file
+	Entry  string      `json:"entry,omitempty"`  // package database entry
+	Backup string      `json:"backup,omitempty"` // copy of the previous contents ("" if it did not exist)
+	Link   string      `json:"link,omitempty"`   // target of the previous symlink (files only)
+	SHA256 string      `json:"sha256,omitempty"` // checksum of the previous contents
+	Mode   os.FileMode `json:"mode,omitempty"`
+}
+
+// Transaction applies package operations so that they can be rolled back as a
+// whole, even after a crash: before a file or database entry is changed, its
+// previous contents are copied into a journal under DataDir. The journal is
+// discarded on Commit; if it is still there on the next run, the transaction
+// was interrupted and can be rolled back or forward (see phm recover).
+type Transaction struct {
+	m       *Manager
+	dir     string
+	info    TransactionInfo
+	log     *os.File
+	records []journalRecord
+	seen    map[string]bool
+}
+
+// journalDir returns the directory of the running transaction
+func (m *Manager) journalDir() string {
+	return filepath.Join(m.dataDir, journalDirName)
+}
+
+// PendingTransaction returns the interrupted transaction, or nil if there is none
+func (m *Manager) PendingTransaction() (*TransactionInfo, error) {
+	dir := m.journalDir()
+	data, err := os.ReadFile(filepath.Join(dir, "transaction.json"))
+	if os.IsNotExist(err) {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			// Crashed while starting: nothing was changed yet
+			return &TransactionInfo{Operation: "unknown"}, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info TransactionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("corrupted transaction journal: %w", err)
+	}
+	records, err := readJournalLog(filepath.Join(dir, "journal.log"))
+	if err != nil {
+		return nil, err
+	}
+	info.Changes = len(records)
+	return &info, nil
+}
+
+// Begin starts a transaction. Actions are applied with Apply and the
+// transaction ends with Commit or Rollback.
+func (m *Manager) Begin(operation string, actions []TransactionAction) (*Transaction, error) {
+	if m.tx != nil {
+		return nil, fmt.Errorf("a transaction is already running")
+	}
+	pending, err := m.PendingTransaction()
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, fmt.Errorf("an interrupted %s transaction was found (run: phm recover)", pending.Operation)
+	}
+
+	dir := m.journalDir()
+	_ = os.RemoveAll(dir + ".done")
+	if err := os.MkdirAll(filepath.Join(dir, "backup"), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create transaction journal: %w", err)
+	}
+
+	username, _ := getInstallingUser()
+	if username == "" {
+		if u, err := user.Current(); err == nil {
+			username = u.Username
+		}
+	}
+	tx := &Transaction{
+		m:   m,
+		dir: dir,
+		info: TransactionInfo{
+			Operation: operation,
+			StartedAt: time.Now(),
+			Actions:   actions,
+			Command:   m.command,
+			User:      username,
+			Previous:  m.snapshot(actions),
+		},
+		seen: make(map[string]bool),
+	}
+	if err := tx.saveInfo(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := tx.openLog(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	m.tx = tx
+	return tx, nil
+}
+
+// ResumeTransaction opens the interrupted transaction so it can be rolled back or forward
+func (m *Manager) ResumeTransaction() (*Transaction, error) {
+	if m.tx != nil {
+		return nil, fmt.Errorf("a transaction is already running")
+	}
+	info, err := m.PendingTransaction()
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("no interrupted transaction")
+	}
+
+	tx := &Transaction{m: m, dir: m.journalDir(), info: *info, seen: make(map[string]bool)}
+	tx.records, err = readJournalLog(filepath.Join(tx.dir, "journal.log"))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range tx.records {
+		tx.seen[r.key()] = true
+	}
+	if err := os.MkdirAll(filepath.Join(tx.dir, "backup"), 0700); err != nil {
+		return nil, err
+	}
+	if err := tx.openLog(); err != nil {
+		return nil, err
+	}
+
+	m.tx = tx
+	return tx, nil
+}
+
+// Info returns the transaction description
+func (tx *Transaction) Info() TransactionInfo {
+	info := tx.info
+	info.Changes = len(tx.records)
+	return info
+}
+
+// Apply runs action i and marks it done. Install actions return the installed package.
+func (tx *Transaction) Apply(i int) (*InstalledPackage, error) {
+	a := &tx.info.Actions[i]
+
+	var installed *InstalledPackage
+	var err error
+	switch a.Kind {
+	case ActionInstall:
+		installed, err = tx.m.installFromTarball(a.Path, a.Options)
+	case ActionRemove:
+		err = tx.m.Remove(a.Name)
+	case ActionRepair:
+		_, err = tx.m.Repair(a.Name, a.Path)
+	default:
+		err = fmt.Errorf("unknown transaction action %q", a.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.markDone(i); err != nil {
+		return nil, err
+	}
+	return installed, nil
+}
+
+// Repair runs repair action i (see Manager.Repair) and marks it done
+func (tx *Transaction) Repair(i int) (*RepairResult, error) {
+	a := &tx.info.Actions[i]
+	if a.Kind != ActionRepair {
+		return nil, fmt.Errorf("action %d is not a repair", i)
+	}
+	result, err := tx.m.Repair(a.Name, a.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.markDone(i); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// markDone records that action i has completed
+func (tx *Transaction) markDone(i int) error {
+	tx.info.Actions[i].Done = true
+	return tx.saveInfo()
+}
+
+// Commit makes the transaction permanent, discards its journal and records it in the history
+func (tx *Transaction) Commit() error {
+	if err := tx.discard(); err != nil {
+		return err
+	}
+	tx.m.recordHistory(tx.info, HistoryOK)
+	return nil
+}
+
+// discard closes the transaction and removes its journal
+func (tx *Transaction) discard() error {
+	tx.close()
+
+	// Renaming is atomic: a crash can never leave a half-deleted journal behind
+	done := tx.dir + ".done"
+	_ = os.RemoveAll(done)
+	if err := os.Rename(tx.dir, done); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	_ = os.RemoveAll(done)
+	return nil
+}
+
+// RollForward completes the remaining actions of an interrupted transaction and commits it
+func (tx *Transaction) RollForward() error {
+	for _, a := range tx.info.Actions {
+		if a.Done || (a.Kind != ActionInstall && a.Kind != ActionRepair) {
+			continue
+		}
+		if _, err := os.Stat(a.Path); err != nil {
+			return fmt.Errorf("cannot roll forward: package file for %s is missing (%s); roll back instead", a.Name, a.Path)
+		}
+	}
+
+	for i, a := range tx.info.Actions {
+		if a.Done {
+			continue
+		}
+		if a.Kind == ActionRemove && !tx.m.IsInstalled(a.Name) {
+			tx.info.Actions[i].Done = true
+			continue
+		}
+		if _, err := tx.Apply(i); err != nil {
+			return fmt.Errorf("%s %s: %w", a.Kind, a.Name, err)
+		}
+	}
+	if err := tx.discard(); err != nil {
+		return err
+	}
+	tx.m.recordHistory(tx.info, HistoryRecovered)
+	return nil
+}
+
+// Rollback restores every file and database entry changed by the transaction
+// and discards its journal. If something cannot be restored, the journal is
+// kept so the rollback can be retried.
+func (tx *Transaction) Rollback() error {
+	tx.close()
+
+	var errs []error
+	var files []string
+	for i := len(tx.records) - 1; i >= 0; i-- {
+		r := tx.records[i]
+		if err := tx.restore(r); err != nil {
+			errs = append(errs, err)
+		}
+		if r.File != "" {
+			files = append(files, r.File)
+		}
+	}
+	tx.m.removeEmptyDirs(files)
+
+	// Reload the database as it is on disk now
+	tx.m.installed = make(map[string]*InstalledPackage)
+	if err := tx.m.LoadInstalled(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if err := tx.discard(); err != nil {
+		return err
+	}
+	tx.m.recordHistory(tx.info, HistoryRolledBack)
+	return nil
+}
+
+// restore puts back the previous state of one journal record
+func (tx *Transaction) restore(r journalRecord) error {
+	target := r.File
+	if r.Entry != "" {
+		target = filepath.Join(tx.m.dataDir, "installed", r.Entry+".json")
+	}
+
+	if r.Link != "" {
+		if err := tx.m.fs.MkdirAll(filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := tx.m.fs.Symlink(r.Link, target); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		return nil
+	}
+
+	if r.Backup == "" {
+		// Did not exist before the transaction
+		if r.Entry != "" {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		return tx.m.fs.Remove(target)
+	}
+
+	backup := filepath.Join(tx.dir, r.Backup)
+	tmpDir := ""
+	if r.Entry != "" {
+		tmpDir = filepath.Dir(target) // same directory, so the rename is atomic
+		if err := os.MkdirAll(tmpDir, 0755); err != nil {
+			return err
+		}
+	} else if err := tx.m.fs.MkdirAll(filepath.Dir(target)); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(tmpDir, ".phm-restore-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	sum, err := copyAndHash(tmp, backup)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to read backup of %s: %w", target, err)
+	}
+	if sum != r.SHA256 {
+		os.Remove(tmpPath)
+		return fmt.Errorf("backup of %s is corrupted (checksum mismatch)", target)
+	}
+
+	if r.Entry != "" {
+		if err := os.Rename(tmpPath, target); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		return nil
+	}
+	if err := tx.m.fs.Install(tmpPath, target, r.Mode); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", target, err)
+	}
+	return nil
+}
+
+// journalFile records the previous state of a file before the running transaction changes it
+func (m *Manager) journalFile(path string) error {
+	if m.tx == nil {
+		return nil
+	}
+	return m.tx.record(journalRecord{File: path}, path)
+}
+
+// journalEntry records the previous database entry of a package before the running transaction changes it
+func (m *Manager) journalEntry(name string) error {
+	if m.tx == nil {
+		return nil
+	}
+	return m.tx.record(journalRecord{Entry: name}, filepath.Join(m.dataDir, "installed", name+".json"))
+}
+
+// key identifies what a record protects
+func (r journalRecord) key() string {
+	if r.Entry != "" {
+		return "entry:" + r.Entry
+	}
+	return "file:" + r.File
+}
+
+// record backs up src (if it exists, a symlink by its target) and appends r to
+// the journal log. Only the first change of a path is recorded: that is the
+// state to restore.
+func (tx *Transaction) record(r journalRecord, src string) error {
+	if tx.seen[r.key()] {
+		return nil
+	}
+
+	info, err := os.Lstat(src)
+	switch {
+	case err == nil && info.Mode().IsRegular():
+		r.Backup = filepath.Join("backup", strconv.Itoa(len(tx.records)))
+		r.Mode = info.Mode().Perm()
+		r.SHA256, err = backupFile(src, filepath.Join(tx.dir, r.Backup))
+		if err != nil {
+			return err
+		}
+	case err == nil && info.Mode()&os.ModeSymlink != 0 && r.File != "":
+		if r.Link, err = os.Readlink(src); err != nil {
+			return err
+		}
+	case err == nil:
+		return fmt.Errorf("%s is not a regular file", src)
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.log.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := tx.log.Sync(); err != nil {
+		return err
+	}
+
+	tx.records = append(tx.records, r)
+	tx.seen[r.key()] = true
+	return nil
+}
+
+// openLog opens the journal log for appending
+func (tx *Transaction) openLog() error {
+	f, err := os.OpenFile(filepath.Join(tx.dir, "journal.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open transaction journal: %w", err)
+	}
+	tx.log = f
+	return nil
+}
+
+// close releases the journal log and detaches the transaction from the manager
+func (tx *Transaction) close() {
+	if tx.log != nil {
+		tx.log.Close()
+		tx.log = nil
+	}
+	if tx.m.tx == tx {
+		tx.m.tx = nil
+	}
+}
+
+// saveInfo atomically writes transaction.json
+func (tx *Transaction) saveInfo() error {
+	data, err := json.MarshalIndent(tx.info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(tx.dir, ".transaction-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write transaction journal: %w", err)
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filepath.Join(tx.dir, "transaction.json"))
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write transaction journal: %w", err)
+	}
+	return nil
+}
+
+// readJournalLog reads the journal records. A torn last line (crash while
+// appending) is ignored: the change it announced was never made.
+func readJournalLog(path string) ([]journalRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []journalRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			break
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transaction journal: %w", err)
+	}
+	return records, nil
+}
+
+// backupFile copies src to dest, syncs it and returns the SHA256 of the contents
+func backupFile(src, dest string) (string, error) {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	sum, err := copyAndHash(f, src)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", src, err)
+	}
+	return sum, nil
+}
+
+// copyAndHash copies the file at src into w and returns the SHA256 of the contents
+func copyAndHash(w io.Writer, src string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,289 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/phm-dev/phm/internal/privfs"
+)
+
+// errCrash simulates the process dying before a change is made
+var errCrash = errors.New("simulated crash")
+
+// txFixture is an installed tree on disk and the actions of a transaction that
+// changes it. Each test run gets a fresh copy.
+type txFixture struct {
+	cliV1, cliV2, redis, old string // package tarballs
+}
+
+func newTxFixture(t *testing.T) *txFixture {
+	t.Helper()
+	return &txFixture{
+		cliV1: buildPackage(t, Package{Name: "php8.5-cli", Version: "8.5.0"}, map[string]string{
+			"8.5/bin/php":          "php 8.5.0",
+			"8.5/lib/libphp.so.1":  "libphp 8.5.0",
+			"8.5/etc/php.ini":      "memory_limit=128M\n",
+			"8.5/etc/conf.d/a.ini": "a=1\n",
+		}, nil),
+		cliV2: buildPackage(t, Package{Name: "php8.5-cli", Version: "8.5.1"}, map[string]string{
+			"8.5/bin/php":          "php 8.5.1",
+			"8.5/lib/libphp.so.1":  "libphp 8.5.1",
+			"8.5/lib/libphp.so":    "libphp 8.5.1",
+			"8.5/etc/php.ini":      "memory_limit=256M\n",
+			"8.5/etc/conf.d/a.ini": "a=1\n",
+		}, map[string]string{ScriptPostinst: "true\n"}),
+		redis: buildPackage(t, Package{Name: "php8.5-redis", Version: "6.1.0", PHPVersion: "8.5.0"}, map[string]string{
+			"8.5/lib/php/extensions/redis.so": "redis",
+			"8.5/etc/conf.d/redis.ini":        "extension=redis.so\n",
+		}, map[string]string{ScriptPreinst: "true\n", ScriptPostinst: "true\n"}),
+		old: buildPackage(t, Package{Name: "php8.5-old", Version: "1.0.0", PHPVersion: "8.5.0"}, map[string]string{
+			"8.5/lib/php/extensions/old.so": "old",
+		}, map[string]string{ScriptPrerm: "true\n", ScriptPostrm: "true\n"}),
+	}
+}
+
+// setup installs the tree on disk (php8.5-cli 8.5.0 with a locally edited
+// php.ini and a symlink the upgrade replaces, php8.5-old) and returns a manager
+// that makes further changes through fake
+func (f *txFixture) setup(t *testing.T, fake *privfs.Fake) *Manager {
+	t.Helper()
+
+	root := t.TempDir()
+	m := NewManager(filepath.Join(root, "opt", "php"), filepath.Join(root, "data"))
+	m.SetFS(privfs.Direct{})
+	for _, p := range []string{f.cliV1, f.old} {
+		if _, err := m.Install(p); err != nil {
+			t.Fatalf("Install %s: %v", p, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(m.installPrefix, "8.5", "etc", "conf.d", "a.ini"), []byte("a=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("libphp.so.1", filepath.Join(m.installPrefix, "8.5", "lib", "libphp.so")); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetFS(fake)
+	return m
+}
+
+// actions upgrades php8.5-cli, installs php8.5-redis and removes php8.5-old
+func (f *txFixture) actions() []TransactionAction {
+	return []TransactionAction{
+		{Kind: ActionInstall, Name: "php8.5-cli", Path: f.cliV2},
+		{Kind: ActionInstall, Name: "php8.5-redis", Path: f.redis},
+		{Kind: ActionRemove, Name: "php8.5-old"},
+	}
+}
+
+// tree returns the files and symlinks under dir (by relative path) as the
+// manager sees them after the changes recorded by fake: the contents on disk,
+// overlaid with the paths the fake changed ("-> target" for symlinks)
+func tree(t *testing.T, dir string, fake *privfs.Fake) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			files[rel] = "-> " + target
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[rel] = string(data)
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	for _, op := range fake.Ops() {
+		fields := strings.Fields(op)
+		var touched []string
+		switch fields[0] {
+		case "install", "write", "copy", "remove", "symlink":
+			touched = fields[1:2]
+		case "rename":
+			touched = []string{fields[1], fields[3]}
+		}
+		for _, path := range touched {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			if data, err := fake.ReadFile(path); err == nil {
+				files[rel] = string(data)
+			} else if target, err := fake.Readlink(path); err == nil {
+				files[rel] = "-> " + target
+			} else {
+				delete(files, rel)
+			}
+		}
+	}
+	return files
+}
+
+// installedBuilds returns "name version" of the installed packages, as loaded from disk
+func installedBuilds(t *testing.T, m *Manager) []string {
+	t.Helper()
+	fresh := NewManager(m.installPrefix, m.dataDir)
+	if err := fresh.LoadInstalled(); err != nil {
+		t.Fatal(err)
+	}
+	var builds []string
+	for _, p := range fresh.GetAllInstalled() {
+		builds = append(builds, label(&p.Package))
+	}
+	sort.Strings(builds)
+	return builds
+}
+
+// runTransaction applies all actions and commits
+func runTransaction(m *Manager, actions []TransactionAction) error {
+	tx, err := m.Begin("install", actions)
+	if err != nil {
+		return err
+	}
+	for i := range actions {
+		if _, err := tx.Apply(i); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// diffTrees describes how got differs from want
+func diffTrees(got, want map[string]string) string {
+	var diffs []string
+	for path, w := range want {
+		if g, ok := got[path]; !ok {
+			diffs = append(diffs, fmt.Sprintf("missing %s", path))
+		} else if g != w {
+			diffs = append(diffs, fmt.Sprintf("%s = %q, want %q", path, g, w))
+		}
+	}
+	for path := range got {
+		if _, ok := want[path]; !ok {
+			diffs = append(diffs, fmt.Sprintf("unexpected %s", path))
+		}
+	}
+	sort.Strings(diffs)
+	return strings.Join(diffs, "\n")
+}
+
+func TestTransactionCommit(t *testing.T) {
+	f := newTxFixture(t)
+	fake := privfs.NewFake()
+	m := f.setup(t, fake)
+
+	if err := runTransaction(m, f.actions()); err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+
+	got := tree(t, m.installPrefix, fake)
+	checks := map[string]string{
+		"8.5/bin/php":                     "php 8.5.1",
+		"8.5/lib/libphp.so":               "libphp 8.5.1", // symlink replaced by a file
+		"8.5/etc/php.ini":                 "memory_limit=256M\n",
+		"8.5/etc/conf.d/a.ini":            "a=2\n", // local change kept
+		"8.5/lib/php/extensions/redis.so": "redis",
+	}
+	for path, want := range checks {
+		if got[path] != want {
+			t.Errorf("%s = %q, want %q", path, got[path], want)
+		}
+	}
+	if _, ok := got["8.5/lib/php/extensions/old.so"]; ok {
+		t.Error("old.so left after removal")
+	}
+	want := []string{"php8.5-cli 8.5.1", "php8.5-redis 6.1.0"}
+	if builds := installedBuilds(t, m); !reflect.DeepEqual(builds, want) {
+		t.Errorf("installed = %v, want %v", builds, want)
+	}
+	if pending, err := m.PendingTransaction(); pending != nil || err != nil {
+		t.Errorf("PendingTransaction = %+v, %v after commit", pending, err)
+	}
+}
+
+// TestTransactionInterrupted crashes the transaction before each change it
+// makes, and checks that rolling back restores the tree as it was before and
+// rolling forward produces the tree of an uninterrupted run
+func TestTransactionInterrupted(t *testing.T) {
+	f := newTxFixture(t)
+
+	// Reference runs: the tree before and after, and the number of changes
+	fake := privfs.NewFake()
+	m := f.setup(t, fake)
+	before := tree(t, m.installPrefix, fake)
+	buildsBefore := installedBuilds(t, m)
+	if err := runTransaction(m, f.actions()); err != nil {
+		t.Fatalf("transaction: %v", err)
+	}
+	after := tree(t, m.installPrefix, fake)
+	buildsAfter := installedBuilds(t, m)
+	changes := len(fake.Ops())
+
+	for _, recovery := range []string{"rollback", "roll forward"} {
+		for k := 0; k < changes; k++ {
+			t.Run(fmt.Sprintf("%s after %d changes", recovery, k), func(t *testing.T) {
+				fake := privfs.NewFake()
+				crashed := f.setup(t, fake)
+				n := 0
+				fake.Fail = func(op, path string) error {
+					if n++; n > k {
+						return errCrash
+					}
+					return nil
+				}
+				if err := runTransaction(crashed, f.actions()); !errors.Is(err, errCrash) {
+					t.Fatalf("transaction = %v, want simulated crash", err)
+				}
+				crashed.tx.close()
+				fake.Fail = nil
+
+				// The next run finds the journal
+				m := NewManager(crashed.installPrefix, crashed.dataDir)
+				m.SetFS(fake)
+				if err := m.LoadInstalled(); err != nil {
+					t.Fatal(err)
+				}
+				tx, err := m.ResumeTransaction()
+				if err != nil {
+					t.Fatalf("ResumeTransaction: %v", err)
+				}
+
+				wantTree, wantBuilds := before, buildsBefore
+				if recovery == "rollback" {
+					err = tx.Rollback()
+				} else {
+					err = tx.RollForward()
+					wantTree, wantBuilds = after, buildsAfter
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", recovery, err)
+				}
+
+				if diff := diffTrees(tree(t, m.installPrefix, fake), wantTree); diff != "" {
+					t.Errorf("tree after %s:\n%s", recovery, diff)
+				}
+				if builds := installedBuilds(t, m); !reflect.DeepEqual(builds, wantBuilds) {
+					t.Errorf("installed = %v, want %v", builds, wantBuilds)
+				}
+				if pending, err := m.PendingTransaction(); pending != nil || err != nil {
+					t.Errorf("PendingTransaction = %+v, %v after %s", pending, err, recovery)
+				}
+			})
+		}
+	}
+}
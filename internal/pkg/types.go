@@ -1,26 +1,37 @@
 package pkg
 
 import (
+	"fmt"
+	"os"
 	"regexp"
 	"time"
 )
 
 // Package represents a PHP package
 type Package struct {
-	Name          string   `json:"name"`
-	Version       string   `json:"version"`
-	Revision      int      `json:"revision"`
-	PHPVersion    string   `json:"php_version,omitempty"`
-	Description   string   `json:"description"`
-	Platform      string   `json:"platform"`
-	Depends       []string `json:"depends"`
-	Conflicts     []string `json:"conflicts,omitempty"`
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Revision    int      `json:"revision"`
+	PHPVersion  string   `json:"php_version,omitempty"`
+	Description string   `json:"description"`
+	Platform    string   `json:"platform"`
+	Depends     []string `json:"depends"`
+	Conflicts   []string `json:"conflicts,omitempty"`
+	// Replaces lists packages whose files this package may take over
+	Replaces      []string `json:"replaces,omitempty"`
 	Provides      []string `json:"provides"`
 	InstalledSize int64    `json:"installed_size"`
 	Maintainer    string   `json:"maintainer,omitempty"`
 	URL           string   `json:"url,omitempty"`
 	SHA256        string   `json:"sha256,omitempty"`
 	Size          int64    `json:"size,omitempty"`
+	// Repo is the name of the repository the package was resolved from (set at runtime)
+	Repo string `json:"repo,omitempty"`
+}
+
+// Filename returns the package tarball name (name_version-rev_platform.tar.zst)
+func (p *Package) Filename() string {
+	return fmt.Sprintf("%s_%s-%d_%s.tar.zst", p.Name, p.Version, p.Revision, p.Platform)
 }
 
 // InstalledPackage extends Package with installation info
@@ -28,6 +39,9 @@ type InstalledPackage struct {
 	Package
 	InstalledAt    time.Time `json:"installed_at"`
 	InstalledFiles []string  `json:"installed_files"`
+	// Files records checksum, size and mode of each installed file (see phm verify).
+	// Empty for packages installed by older PHM versions.
+	Files []InstalledFile `json:"files,omitempty"`
 	// InstallSlot is the directory where this package is installed (e.g., "8.5" or "8.5.1")
 	// For minor version installs (php8.5-cli), this is "8.5"
 	// For pinned version installs (php8.5.1-cli), this is "8.5.1"
@@ -35,6 +49,18 @@ type InstalledPackage struct {
 	// Pinned indicates if this package is pinned to a specific patch version
 	// Pinned packages are not upgraded by `phm upgrade`
 	Pinned bool `json:"pinned,omitempty"`
+	// Scripts lists the lifecycle scripts kept for upgrade and removal (see storeScripts)
+	Scripts []string `json:"scripts,omitempty"`
+}
+
+// InstalledFile is the state of a file as written by the installer
+type InstalledFile struct {
+	Path   string      `json:"path"`
+	SHA256 string      `json:"sha256"`
+	Size   int64       `json:"size"`
+	Mode   os.FileMode `json:"mode"`
+	// Config marks configuration files (under etc/), which users are expected to edit
+	Config bool `json:"config,omitempty"`
 }
 
 // Index represents the package index
@@ -70,11 +96,16 @@ func (s PackageState) String() string {
 	}
 }
 
+// MarshalText encodes the state as its name (e.g. "upgradable")
+func (s PackageState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 // PackageInfo combines package data with its state
 type PackageInfo struct {
-	Package          Package
-	State            PackageState
-	InstalledVersion string
+	Package          Package      `json:"package"`
+	State            PackageState `json:"state"`
+	InstalledVersion string       `json:"installed_version,omitempty"`
 }
 
 // VersionInfo contains parsed version information from a package name
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// File issue kinds reported by Verify
+const (
+	IssueMissing    = "missing"
+	IssueModified   = "modified"
+	IssueMode       = "mode"
+	IssueUnreadable = "unreadable"
+)
+
+// FileIssue describes an installed file that no longer matches the package database
+type FileIssue struct {
+	Path     string `json:"path"`
+	Issue    string `json:"issue"`
+	Config   bool   `json:"config,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// VerifyResult is the outcome of verifying one installed package
+type VerifyResult struct {
+	Package string      `json:"package"`
+	Version string      `json:"version"`
+	Files   int         `json:"files"`
+	Issues  []FileIssue `json:"issues"`
+	// Unverified is set for packages installed without checksums; only presence is checked
+	Unverified bool `json:"unverified,omitempty"`
+}
+
+// Damaged reports whether any file other than a modified config file has an issue
+func (r *VerifyResult) Damaged() bool {
+	for _, issue := range r.Issues {
+		if !issue.Config || issue.Issue == IssueMissing {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the files of an installed package against the checksums,
+// sizes and modes recorded at install time
+func (m *Manager) Verify(name string) (*VerifyResult, error) {
+	installed := m.installed[name]
+	if installed == nil {
+		return nil, fmt.Errorf("package not installed: %s", name)
+	}
+
+	result := &VerifyResult{
+		Package: installed.Name,
+		Version: installed.Version,
+		Issues:  []FileIssue{},
+	}
+
+	if len(installed.Files) == 0 {
+		result.Unverified = true
+		result.Files = len(installed.InstalledFiles)
+		for _, path := range installed.InstalledFiles {
+			if _, err := os.Lstat(path); os.IsNotExist(err) {
+				result.Issues = append(result.Issues, FileIssue{Path: path, Issue: IssueMissing, Config: !isBinaryPath(path)})
+			}
+		}
+		return result, nil
+	}
+
+	result.Files = len(installed.Files)
+	for _, f := range installed.Files {
+		if issue := verifyFile(f); issue != nil {
+			result.Issues = append(result.Issues, *issue)
+		}
+	}
+	return result, nil
+}
+
+// verifyFile compares one file on disk with its recorded state
+func verifyFile(f InstalledFile) *FileIssue {
+	issue := &FileIssue{Path: f.Path, Config: f.Config}
+
+	info, err := os.Lstat(f.Path)
+	switch {
+	case os.IsNotExist(err):
+		issue.Issue = IssueMissing
+		return issue
+	case err != nil:
+		issue.Issue = IssueUnreadable
+		issue.Actual = err.Error()
+		return issue
+	case !info.Mode().IsRegular():
+		issue.Issue = IssueModified
+		issue.Expected = "regular file"
+		issue.Actual = info.Mode().Type().String()
+		return issue
+	}
+
+	if info.Size() != f.Size {
+		issue.Issue = IssueModified
+		issue.Expected = fmt.Sprintf("%d bytes", f.Size)
+		issue.Actual = fmt.Sprintf("%d bytes", info.Size())
+		return issue
+	}
+
+	sum, err := copyAndHash(io.Discard, f.Path)
+	if err != nil {
+		issue.Issue = IssueUnreadable
+		issue.Actual = err.Error()
+		return issue
+	}
+	if sum != f.SHA256 {
+		issue.Issue = IssueModified
+		issue.Expected = "sha256 " + f.SHA256
+		issue.Actual = "sha256 " + sum
+		return issue
+	}
+
+	if info.Mode().Perm() != f.Mode.Perm() {
+		issue.Issue = IssueMode
+		issue.Expected = fmt.Sprintf("%04o", f.Mode.Perm())
+		issue.Actual = fmt.Sprintf("%04o", info.Mode().Perm())
+		return issue
+	}
+
+	return nil
+}
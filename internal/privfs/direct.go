@@ -0,0 +1,81 @@
+package privfs
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// Direct changes files with the privileges of the current process. Used when
+// running as root, in user mode and for relocated roots owned by the user.
+type Direct struct{}
+
+// MkdirAll creates a directory and its parents
+func (Direct) MkdirAll(path string) error {
+	return wrap("mkdir", path, os.MkdirAll(path, 0755))
+}
+
+// WriteFile writes data to a file
+func (Direct) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return wrap("write", path, err)
+	}
+	return wrap("chmod", path, os.Chmod(path, perm))
+}
+
+// Install moves a temporary file into place, copying it across filesystems
+func (Direct) Install(src, dst string, perm os.FileMode) error {
+	defer os.Remove(src)
+	if err := os.Rename(src, dst); err != nil {
+		if err := copyFile(src, dst, perm); err != nil {
+			return wrap("install", dst, err)
+		}
+		return nil
+	}
+	return wrap("chmod", dst, os.Chmod(dst, perm))
+}
+
+// CopyFile copies a file
+func (Direct) CopyFile(src, dst string, perm os.FileMode) error {
+	return wrap("copy", dst, copyFile(src, dst, perm))
+}
+
+// Rename renames a file
+func (Direct) Rename(oldpath, newpath string) error {
+	return wrap("rename", newpath, os.Rename(oldpath, newpath))
+}
+
+// Chmod changes the mode of a file
+func (Direct) Chmod(path string, mode os.FileMode) error {
+	return wrap("chmod", path, os.Chmod(path, mode))
+}
+
+// Remove removes a file, symlink or empty directory
+func (Direct) Remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return wrap("remove", path, err)
+	}
+	return nil
+}
+
+// RemoveAll removes a path recursively
+func (Direct) RemoveAll(path string) error {
+	return wrap("remove", path, os.RemoveAll(path))
+}
+
+// Symlink creates or replaces a symlink
+func (d Direct) Symlink(target, link string) error {
+	if err := d.Remove(link); err != nil {
+		return err
+	}
+	return wrap("symlink", link, os.Symlink(target, link))
+}
+
+// Command returns a command run as the current user
+func (Direct) Command(ctx context.Context, env []string, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
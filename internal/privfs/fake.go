@@ -0,0 +1,263 @@
+package privfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fake keeps every change in memory and records the operations, without
+// touching the disk (except to read and remove the source of Install). Used by
+// tests and --dry-run to inspect what a command would change.
+type Fake struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	modes map[string]os.FileMode
+	dirs  map[string]bool
+	links map[string]string
+	ops   []string
+
+	// Fail, if set, is called before every operation; a non-nil error makes
+	// the operation fail without changes
+	Fail func(op, path string) error
+}
+
+// NewFake returns an empty in-memory FS
+func NewFake() *Fake {
+	return &Fake{
+		files: make(map[string][]byte),
+		modes: make(map[string]os.FileMode),
+		dirs:  make(map[string]bool),
+		links: make(map[string]string),
+	}
+}
+
+// do records an operation and reports an injected failure
+func (f *Fake) do(op, path, detail string) error {
+	f.ops = append(f.ops, strings.TrimSpace(op+" "+path+" "+detail))
+	if f.Fail != nil {
+		return wrap(op, path, f.Fail(op, path))
+	}
+	return nil
+}
+
+// MkdirAll records a directory and its parents
+func (f *Fake) MkdirAll(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("mkdir", path, ""); err != nil {
+		return err
+	}
+	for dir := filepath.Clean(path); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		f.dirs[dir] = true
+	}
+	return nil
+}
+
+// WriteFile stores data for path
+func (f *Fake) WriteFile(path string, data []byte, perm os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("write", path, ""); err != nil {
+		return err
+	}
+	f.put(path, append([]byte(nil), data...), perm)
+	return nil
+}
+
+// Install stores the contents of a temporary file for dst and removes it
+func (f *Fake) Install(src, dst string, perm os.FileMode) error {
+	defer os.Remove(src)
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return wrap("install", dst, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("install", dst, ""); err != nil {
+		return err
+	}
+	f.put(dst, data, perm)
+	return nil
+}
+
+// CopyFile stores a copy of src (from memory, or from disk) for dst
+func (f *Fake) CopyFile(src, dst string, perm os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("copy", dst, "from "+src); err != nil {
+		return err
+	}
+	data, ok := f.files[filepath.Clean(src)]
+	if !ok {
+		var err error
+		if data, err = os.ReadFile(src); err != nil {
+			return wrap("copy", dst, err)
+		}
+	}
+	f.put(dst, append([]byte(nil), data...), perm)
+	return nil
+}
+
+// Rename moves a file in memory
+func (f *Fake) Rename(oldpath, newpath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("rename", newpath, "from "+oldpath); err != nil {
+		return err
+	}
+	oldpath = filepath.Clean(oldpath)
+	data, ok := f.files[oldpath]
+	if !ok {
+		return wrap("rename", newpath, os.ErrNotExist)
+	}
+	f.put(newpath, data, f.modes[oldpath])
+	delete(f.files, oldpath)
+	delete(f.modes, oldpath)
+	return nil
+}
+
+// Chmod records a mode change
+func (f *Fake) Chmod(path string, mode os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("chmod", path, fmt.Sprintf("%o", mode.Perm())); err != nil {
+		return err
+	}
+	f.modes[filepath.Clean(path)] = mode.Perm()
+	return nil
+}
+
+// Remove forgets a file, symlink or directory
+func (f *Fake) Remove(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("remove", path, ""); err != nil {
+		return err
+	}
+	f.forget(filepath.Clean(path))
+	return nil
+}
+
+// RemoveAll forgets a path and everything below it
+func (f *Fake) RemoveAll(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("remove", path, "(recursive)"); err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	for _, p := range f.paths() {
+		if p == path || strings.HasPrefix(p, path+string(filepath.Separator)) {
+			f.forget(p)
+		}
+	}
+	return nil
+}
+
+// Symlink records a symlink, replacing a file at link
+func (f *Fake) Symlink(target, link string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.do("symlink", link, "-> "+target); err != nil {
+		return err
+	}
+	link = filepath.Clean(link)
+	f.forget(link)
+	f.links[link] = target
+	return nil
+}
+
+// Command records the command and returns one that does nothing
+func (f *Fake) Command(ctx context.Context, env []string, name string, args ...string) *exec.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmd := exec.CommandContext(ctx, "true")
+	if err := f.do("run", name, strings.Join(args, " ")); err != nil {
+		cmd.Err = err
+	}
+	return cmd
+}
+
+// Ops returns the recorded operations in order (e.g. "install /opt/php/8.5/bin/php")
+func (f *Fake) Ops() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.ops...)
+}
+
+// ReadFile returns the contents written to path
+func (f *Fake) ReadFile(path string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[filepath.Clean(path)]
+	if !ok {
+		return nil, wrap("read", path, os.ErrNotExist)
+	}
+	return data, nil
+}
+
+// Mode returns the mode of a file written to the fake
+func (f *Fake) Mode(path string) os.FileMode {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.modes[filepath.Clean(path)]
+}
+
+// Readlink returns the target of a symlink created in the fake
+func (f *Fake) Readlink(path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target, ok := f.links[filepath.Clean(path)]
+	if !ok {
+		return "", wrap("readlink", path, os.ErrNotExist)
+	}
+	return target, nil
+}
+
+// Paths returns every file, symlink and directory in the fake, sorted
+func (f *Fake) Paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.paths()
+}
+
+func (f *Fake) paths() []string {
+	var paths []string
+	for p := range f.files {
+		paths = append(paths, p)
+	}
+	for p := range f.links {
+		paths = append(paths, p)
+	}
+	for p := range f.dirs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// put stores a file, creating its parent directories
+func (f *Fake) put(path string, data []byte, perm os.FileMode) {
+	path = filepath.Clean(path)
+	delete(f.links, path)
+	f.files[path] = data
+	f.modes[path] = perm.Perm()
+	for dir := filepath.Dir(path); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		f.dirs[dir] = true
+	}
+}
+
+// forget drops a path of any kind
+func (f *Fake) forget(path string) {
+	delete(f.files, path)
+	delete(f.modes, path)
+	delete(f.links, path)
+	delete(f.dirs, path)
+}
@@ -0,0 +1,100 @@
+// Package privfs changes files in locations that may need root privileges (the
+// install prefix, /usr/local/bin, LaunchDaemons) through a single interface, so
+// the same code runs directly, through sudo, or against an in-memory fake.
+package privfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Implementations
+var (
+	_ FS = Direct{}
+	_ FS = Sudo{}
+	_ FS = (*Fake)(nil)
+)
+
+// FS performs filesystem changes and runs commands that may need root
+// privileges. Reading files is not privileged and uses the os package.
+type FS interface {
+	// MkdirAll creates a directory and its parents (mode 0755)
+	MkdirAll(path string) error
+	// WriteFile writes data to a file, creating or truncating it
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// Install moves a temporary file into place and sets its mode.
+	// src is removed, also when installing fails.
+	Install(src, dst string, perm os.FileMode) error
+	// CopyFile copies a file and sets the mode of the copy
+	CopyFile(src, dst string, perm os.FileMode) error
+	// Rename renames (moves) a file, replacing newpath
+	Rename(oldpath, newpath string) error
+	// Chmod changes the mode of a file
+	Chmod(path string, mode os.FileMode) error
+	// Remove removes a file, symlink or empty directory. A missing path is not an error.
+	Remove(path string) error
+	// RemoveAll removes a path and everything it contains
+	RemoveAll(path string) error
+	// Symlink creates link pointing to target, replacing an existing link or file
+	Symlink(target, link string) error
+	// Command returns a command run with the privileges of the FS. env is
+	// added to the environment of the command.
+	Command(ctx context.Context, env []string, name string, args ...string) *exec.Cmd
+}
+
+// Error reports a failed operation and the path it was applied to
+type Error struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// wrap returns err as an *Error (nil if err is nil). The path of an
+// *os.PathError is dropped, it is already part of the message.
+func wrap(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		err = linkErr.Err
+	}
+	return &Error{Op: op, Path: path, Err: err}
+}
+
+// copyFile copies src to dst with mode perm
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
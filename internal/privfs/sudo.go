@@ -0,0 +1,137 @@
+package privfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Sudo tries every change directly first and falls back to sudo when that
+// fails (e.g. /opt/php or /usr/local/bin owned by root). Commands always run
+// through sudo unless the process already runs as root.
+type Sudo struct{}
+
+// sudo runs a command through sudo and reports failures as *Error with the
+// output of the command
+func sudo(op, path string, args ...string) error {
+	out, err := exec.Command("sudo", args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		err = fmt.Errorf("sudo %s: %s", args[0], msg)
+	} else {
+		err = fmt.Errorf("sudo %s: %w", args[0], err)
+	}
+	return &Error{Op: op, Path: path, Err: err}
+}
+
+// mode formats a file mode for chmod
+func mode(perm os.FileMode) string {
+	return fmt.Sprintf("%o", perm.Perm())
+}
+
+// MkdirAll creates a directory and its parents
+func (Sudo) MkdirAll(path string) error {
+	if (Direct{}).MkdirAll(path) == nil {
+		return nil
+	}
+	return sudo("mkdir", path, "mkdir", "-p", path)
+}
+
+// WriteFile writes data to a file
+func (s Sudo) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if (Direct{}).WriteFile(path, data, perm) == nil {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp("", "phm-write-*")
+	if err != nil {
+		return wrap("write", path, err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return wrap("write", path, err)
+	}
+	return s.CopyFile(tmpPath, path, perm)
+}
+
+// Install moves a temporary file into place
+func (s Sudo) Install(src, dst string, perm os.FileMode) error {
+	defer os.Remove(src)
+	if err := os.Rename(src, dst); err == nil {
+		return s.Chmod(dst, perm)
+	}
+	return s.CopyFile(src, dst, perm)
+}
+
+// CopyFile copies a file
+func (Sudo) CopyFile(src, dst string, perm os.FileMode) error {
+	if (Direct{}).CopyFile(src, dst, perm) == nil {
+		return nil
+	}
+	if err := sudo("copy", dst, "cp", src, dst); err != nil {
+		return err
+	}
+	return sudo("chmod", dst, "chmod", mode(perm), dst)
+}
+
+// Rename renames a file
+func (Sudo) Rename(oldpath, newpath string) error {
+	if (Direct{}).Rename(oldpath, newpath) == nil {
+		return nil
+	}
+	return sudo("rename", newpath, "mv", "-f", oldpath, newpath)
+}
+
+// Chmod changes the mode of a file
+func (Sudo) Chmod(path string, perm os.FileMode) error {
+	if (Direct{}).Chmod(path, perm) == nil {
+		return nil
+	}
+	return sudo("chmod", path, "chmod", mode(perm), path)
+}
+
+// Remove removes a file, symlink or empty directory
+func (Sudo) Remove(path string) error {
+	if (Direct{}).Remove(path) == nil {
+		return nil
+	}
+	if info, err := os.Lstat(path); err == nil && info.IsDir() {
+		return sudo("remove", path, "rmdir", path)
+	}
+	return sudo("remove", path, "rm", "-f", path)
+}
+
+// RemoveAll removes a path recursively
+func (Sudo) RemoveAll(path string) error {
+	if (Direct{}).RemoveAll(path) == nil {
+		return nil
+	}
+	return sudo("remove", path, "rm", "-rf", path)
+}
+
+// Symlink creates or replaces a symlink
+func (Sudo) Symlink(target, link string) error {
+	if (Direct{}).Symlink(target, link) == nil {
+		return nil
+	}
+	return sudo("symlink", link, "ln", "-sfn", target, link)
+}
+
+// Command returns a command run as root through sudo
+func (Sudo) Command(ctx context.Context, env []string, name string, args ...string) *exec.Cmd {
+	if os.Geteuid() == 0 {
+		return (Direct{}).Command(ctx, env, name, args...)
+	}
+	sudoArgs := append([]string{"env"}, env...)
+	sudoArgs = append(append(sudoArgs, name), args...)
+	return exec.CommandContext(ctx, "sudo", sudoArgs...)
+}
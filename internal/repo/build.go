@@ -0,0 +1,215 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/phm-dev/phm/internal/pkg"
+)
+
+// IndexVersion is the index.json schema version written by BuildIndex
+const IndexVersion = 1
+
+// BuildIndex scans dir for package tarballs and builds an index from their pkginfo.json.
+// Every package is validated with the same rules used at install time, and its file
+// name must match name_version-rev_platform.tar.zst.
+func BuildIndex(dir string) (*pkg.Index, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tar.zst"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	index := &pkg.Index{
+		Version:   IndexVersion,
+		Generated: time.Now().UTC().Format(time.RFC3339),
+		Platforms: make(map[string]*pkg.Platform),
+	}
+
+	for _, file := range files {
+		p, err := pkg.ReadPackageInfo(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		if p.Platform == "" {
+			return nil, fmt.Errorf("%s: pkginfo.json has no platform", filepath.Base(file))
+		}
+		if expected := PackageFilename(p); filepath.Base(file) != expected {
+			return nil, fmt.Errorf("%s: file name does not match pkginfo.json (expected %s)", filepath.Base(file), expected)
+		}
+
+		sum, size, err := fileSHA256(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		p.SHA256 = sum
+		p.Size = size
+		p.URL = ""
+		p.Repo = ""
+
+		platform, ok := index.Platforms[p.Platform]
+		if !ok {
+			platform = &pkg.Platform{}
+			index.Platforms[p.Platform] = platform
+		}
+		platform.Packages = append(platform.Packages, *p)
+	}
+
+	if len(index.Platforms) == 0 {
+		return nil, fmt.Errorf("no packages (*.tar.zst) found in %s", dir)
+	}
+
+	return index, nil
+}
+
+// fileSHA256 returns the hex SHA256 and size of a file
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// Problem is an issue found by LintIndex
+type Problem struct {
+	Platform string
+	Package  string
+	Message  string
+	Fatal    bool // false for warnings
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Platform, p.Package, p.Message)
+}
+
+// LintOptions controls LintIndex
+type LintOptions struct {
+	// Dir, if set, is the repository directory whose tarballs are checked against
+	// the recorded size and SHA256 (packages with an absolute URL are skipped)
+	Dir string
+	// ExternalDeps reports missing dependencies as warnings, for repositories
+	// layered on top of another one (e.g., extensions depending on main's php8.5-common)
+	ExternalDeps bool
+}
+
+// LintIndex checks an index for problems: invalid or duplicate entries,
+// dependencies that no package satisfies and conflicts naming unknown packages.
+func LintIndex(index *pkg.Index, opts LintOptions) []Problem {
+	var problems []Problem
+
+	platforms := make([]string, 0, len(index.Platforms))
+	for name := range index.Platforms {
+		platforms = append(platforms, name)
+	}
+	sort.Strings(platforms)
+
+	for _, platform := range platforms {
+		packages := index.Platforms[platform].Packages
+		report := func(p *pkg.Package, fatal bool, format string, args ...any) {
+			problems = append(problems, Problem{
+				Platform: platform,
+				Package:  fmt.Sprintf("%s-%s-%d", p.Name, p.Version, p.Revision),
+				Message:  fmt.Sprintf(format, args...),
+				Fatal:    fatal,
+			})
+		}
+
+		// Packages available per name, including virtual names from Provides
+		byName := make(map[string][]*pkg.Package)
+		seen := make(map[string]bool)
+		for i := range packages {
+			p := &packages[i]
+			byName[p.Name] = append(byName[p.Name], p)
+			for _, provided := range p.Provides {
+				name := pkg.ParseDependency(provided).Name
+				byName[name] = append(byName[name], p)
+			}
+
+			key := fmt.Sprintf("%s_%s-%d", p.Name, p.Version, p.Revision)
+			if seen[key] {
+				report(p, true, "duplicate entry")
+			}
+			seen[key] = true
+
+			if p.Platform != platform {
+				report(p, true, "platform field %q does not match platform %q", p.Platform, platform)
+			}
+			if p.SHA256 == "" {
+				report(p, true, "missing sha256")
+			}
+		}
+
+		for i := range packages {
+			p := &packages[i]
+
+			for _, depStr := range p.Depends {
+				clauses, err := pkg.ParseRelations(depStr)
+				if err != nil {
+					report(p, true, "%v", err)
+					continue
+				}
+				for _, clause := range clauses {
+					known, satisfied := false, false
+					for _, dep := range clause {
+						for _, candidate := range byName[dep.Name] {
+							known = true
+							if dep.MatchesPackage(candidate) {
+								satisfied = true
+							}
+						}
+					}
+					switch {
+					case !known:
+						report(p, !opts.ExternalDeps, "missing dependency %s", clause)
+					case !satisfied:
+						report(p, true, "no package satisfies %q", clause.String())
+					}
+				}
+			}
+
+			for _, entry := range p.Conflicts {
+				clauses, err := pkg.ParseRelations(entry)
+				if err != nil {
+					report(p, true, "%v", err)
+					continue
+				}
+				for _, clause := range clauses {
+					for _, dep := range clause {
+						if _, ok := byName[dep.Name]; !ok {
+							report(p, false, "conflicts with unknown package %s", dep.Name)
+						}
+					}
+				}
+			}
+
+			if opts.Dir != "" && p.URL == "" {
+				path := filepath.Join(opts.Dir, PackageFilename(p))
+				sum, size, err := fileSHA256(path)
+				switch {
+				case err != nil:
+					report(p, true, "tarball: %v", err)
+				case p.Size > 0 && size != p.Size:
+					report(p, true, "tarball size %d does not match index (%d)", size, p.Size)
+				case p.SHA256 != "" && sum != p.SHA256:
+					report(p, true, "tarball checksum does not match index")
+				}
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// indexMeta records HTTP validators for the cached index.json
+type indexMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// cachedIndexPath returns the path of a remote source's cached index
+func (r *Repository) cachedIndexPath(s *source) string {
+	return filepath.Join(r.cfg.SourceCacheDir(s.Name), "index.json")
+}
+
+// indexMetaPath returns the path of a remote source's cached index metadata
+func (r *Repository) indexMetaPath(s *source) string {
+	return filepath.Join(r.cfg.SourceCacheDir(s.Name), "index.meta.json")
+}
+
+// loadIndexMeta reads the cache metadata of a source. Returns nil if it is missing,
+// unreadable, belongs to a different repository URL, or the cached index is gone.
+func (r *Repository) loadIndexMeta(s *source) *indexMeta {
+	data, err := os.ReadFile(r.indexMetaPath(s))
+	if err != nil {
+		return nil
+	}
+
+	var meta indexMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil
+	}
+	if meta.URL != s.URL {
+		return nil
+	}
+	if _, err := os.Stat(r.cachedIndexPath(s)); err != nil {
+		return nil
+	}
+	return &meta
+}
+
+// saveIndexMeta writes the cache metadata of a source (best effort)
+func (r *Repository) saveIndexMeta(s *source, meta *indexMeta) {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(r.indexMetaPath(s), data, 0644)
+}
+
+// IsCacheFresh reports whether every remote index was fetched within CacheExpiry
+func (r *Repository) IsCacheFresh() bool {
+	for _, s := range r.sources {
+		if s.IsLocal() {
+			continue
+		}
+		meta := r.loadIndexMeta(s)
+		if meta == nil || time.Since(meta.FetchedAt) >= r.cfg.CacheExpiry {
+			return false
+		}
+	}
+	return true
+}
+
+// HasCachedIndex reports whether a cached index exists for every remote repository
+func (r *Repository) HasCachedIndex() bool {
+	for _, s := range r.sources {
+		if !s.IsLocal() && r.loadIndexMeta(s) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheAge returns how long ago the oldest cached index was fetched (0 if unknown)
+func (r *Repository) CacheAge() time.Duration {
+	var age time.Duration
+	for _, s := range r.sources {
+		if s.IsLocal() {
+			continue
+		}
+		meta := r.loadIndexMeta(s)
+		if meta == nil {
+			return 0
+		}
+		if a := time.Since(meta.FetchedAt); a > age {
+			age = a
+		}
+	}
+	return age
+}
@@ -0,0 +1,265 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/phm-dev/phm/internal/httputil"
+	"github.com/phm-dev/phm/internal/pkg"
+	"github.com/schollz/progressbar/v3"
+)
+
+// partSuffix marks an incomplete download in the package cache
+const partSuffix = ".part"
+
+// maxBackoff caps the delay between download retries
+const maxBackoff = 30 * time.Second
+
+// errIdleTimeout is returned when a transfer stalls for longer than DownloadIdleTimeout
+var errIdleTimeout = errors.New("transfer stalled: no data received within idle timeout")
+
+// statusError is returned for unexpected HTTP responses
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.code)
+}
+
+// permanentError marks failures that retrying the same URL won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// packageURLs returns the download URLs for a package: the URL from the index
+// (or the repository base URL) first, followed by the repository mirrors
+func packageURLs(s *source, p *pkg.Package, filename string) []string {
+	var urls []string
+	if p.URL != "" {
+		urls = append(urls, p.URL)
+	} else {
+		urls = append(urls, s.URL+"/"+filename)
+	}
+	for _, mirror := range s.Mirrors {
+		urls = append(urls, mirror+"/"+filename)
+	}
+	return urls
+}
+
+// downloadFile downloads a file to dest, trying each URL in order.
+// Every URL is retried with exponential backoff on 5xx responses and network errors;
+// an interrupted transfer is resumed from dest.part with a Range request.
+// Returns the URL the file was finally downloaded from.
+func (r *Repository) downloadFile(urls []string, dest string) (string, error) {
+	part := dest + partSuffix
+
+	var lastErr error
+	for i, url := range urls {
+		if !strings.HasPrefix(url, "https://") {
+			lastErr = fmt.Errorf("refusing non-HTTPS download URL: %s", url)
+			continue
+		}
+
+		for attempt := 0; attempt <= r.cfg.DownloadRetries; attempt++ {
+			if attempt > 0 {
+				delay := backoff(attempt)
+				fmt.Printf("    Retrying in %s (%v)...\n", delay, lastErr)
+				time.Sleep(delay)
+			}
+
+			err := r.fetchPart(url, part)
+			if err == nil {
+				if err := os.Rename(part, dest); err != nil {
+					return "", err
+				}
+				return url, nil
+			}
+			lastErr = err
+
+			var perm *permanentError
+			if errors.As(err, &perm) {
+				break
+			}
+		}
+
+		if i < len(urls)-1 {
+			fmt.Printf("    \033[33mWarning:\033[0m %s failed (%v), trying next mirror\n", url, lastErr)
+		}
+	}
+
+	return "", fmt.Errorf("failed to download: %w", lastErr)
+}
+
+// backoff returns the delay before the given retry attempt (1s, 2s, 4s, ...)
+func backoff(attempt int) time.Duration {
+	delay := time.Second << (attempt - 1)
+	if delay <= 0 || delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// fetchPart downloads url into the partial file, resuming from its current size
+func (r *Repository) fetchPart(url, part string) error {
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return &permanentError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := httputil.DownloadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && rangeStart(resp) == offset:
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		// Server ignored the Range header, start over
+		flags |= os.O_TRUNC
+		offset = 0
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable, resp.StatusCode == http.StatusPartialContent:
+		// Partial file doesn't match what the server has, start over on the next attempt
+		os.Remove(part)
+		return &statusError{resp.StatusCode}
+	default:
+		err := &statusError{resp.StatusCode}
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests {
+			return err
+		}
+		return &permanentError{err}
+	}
+
+	out, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	bar := newProgressBar(total)
+	_ = bar.Set64(offset)
+
+	body := newIdleTimeoutReader(resp.Body, r.cfg.DownloadIdleTimeout, cancel)
+	defer body.stop()
+
+	_, err = io.Copy(io.MultiWriter(out, bar), body)
+	closeErr := out.Close()
+	if err != nil {
+		var pathErr *os.PathError
+		switch {
+		case body.expired.Load():
+			return errIdleTimeout
+		case errors.As(err, &pathErr):
+			// Writing to the cache failed, another attempt won't help
+			return &permanentError{err}
+		}
+		return err
+	}
+	if closeErr != nil {
+		return &permanentError{closeErr}
+	}
+	return nil
+}
+
+// rangeStart returns the first byte position of a Content-Range header (-1 if invalid)
+func rangeStart(resp *http.Response) int64 {
+	// Content-Range: bytes 1000-1999/2000
+	value, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, ok := strings.Cut(value, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// newProgressBar creates the download progress bar (total -1 if unknown)
+func newProgressBar(total int64) *progressbar.ProgressBar {
+	return progressbar.NewOptions64(
+		total,
+		progressbar.OptionSetDescription("    Downloading"),
+		progressbar.OptionSetWidth(30),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "█",
+			SaucerHead:    "█",
+			SaucerPadding: "░",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Println()
+		}),
+	)
+}
+
+// idleTimeoutReader cancels a transfer when no data has been read for the timeout
+type idleTimeoutReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// newIdleTimeoutReader wraps r; cancel is called once the timeout elapses without progress.
+// A zero timeout disables the check.
+func newIdleTimeoutReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutReader {
+	ir := &idleTimeoutReader{r: r, timeout: timeout}
+	if timeout > 0 {
+		ir.timer = time.AfterFunc(timeout, func() {
+			ir.expired.Store(true)
+			cancel()
+		})
+	}
+	return ir
+}
+
+func (ir *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 && ir.timer != nil {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+// stop releases the timer
+func (ir *idleTimeoutReader) stop() {
+	if ir.timer != nil {
+		ir.timer.Stop()
+	}
+}
@@ -16,123 +16,287 @@ import (
 	"github.com/phm-dev/phm/internal/config"
 	"github.com/phm-dev/phm/internal/httputil"
 	"github.com/phm-dev/phm/internal/pkg"
-	"github.com/schollz/progressbar/v3"
 )
 
-// Repository handles package index and downloads
+// Repository handles package indexes and downloads across all configured sources
 type Repository struct {
-	cfg   *config.Config
+	cfg     *config.Config
+	sources []*source // ordered by precedence (highest priority first)
+}
+
+// source is a configured repository together with its loaded index
+type source struct {
+	config.Source
 	index *pkg.Index
 }
 
 // New creates a new Repository
 func New(cfg *config.Config) *Repository {
-	return &Repository{
+	r := &Repository{
 		cfg: cfg,
 	}
+	for _, src := range cfg.Repositories() {
+		r.sources = append(r.sources, &source{Source: src})
+	}
+	return r
 }
 
-// LoadIndex loads the package index
-func (r *Repository) LoadIndex() error {
-	indexPath := r.cfg.GetIndexPath()
+// Sources returns the configured repositories ordered by precedence
+func (r *Repository) Sources() []config.Source {
+	result := make([]config.Source, 0, len(r.sources))
+	for _, s := range r.sources {
+		result = append(result, s.Source)
+	}
+	return result
+}
 
-	// Check if it's a local file
-	if r.cfg.Offline || r.cfg.RepoPath != "" || strings.HasPrefix(indexPath, "/") {
-		return r.loadLocalIndex(indexPath)
+// sourceErr prefixes err with the repository name when several repositories are configured
+func (r *Repository) sourceErr(s *source, err error) error {
+	if len(r.sources) > 1 {
+		return fmt.Errorf("repository %s: %w", s.Name, err)
 	}
+	return err
+}
 
-	// Always fetch fresh index from remote
-	return r.FetchIndex()
+// LoadIndex loads the package indexes from local repositories or the cache
+func (r *Repository) LoadIndex() error {
+	for _, s := range r.sources {
+		path := r.cachedIndexPath(s)
+		if s.IsLocal() {
+			path = filepath.Join(s.Path, "index.json")
+		}
+		if err := r.loadLocalIndex(s, path); err != nil {
+			return r.sourceErr(s, err)
+		}
+	}
+	return nil
 }
 
-// loadLocalIndex loads index from a local file
-func (r *Repository) loadLocalIndex(path string) error {
+// loadLocalIndex loads a source index from a local file
+func (r *Repository) loadLocalIndex(s *source, path string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to read index: %w", err)
 	}
 
+	sigData, err := os.ReadFile(path + signatureSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read index signature: %w", err)
+	}
+	if err := r.verifyIndex(s, data, sigData); err != nil {
+		return err
+	}
+
 	var index pkg.Index
 	if err := json.Unmarshal(data, &index); err != nil {
 		return fmt.Errorf("failed to parse index: %w", err)
 	}
 
-	r.index = &index
+	s.index = &index
 	return nil
 }
 
-// FetchIndex fetches the index from remote repository
-func (r *Repository) FetchIndex() error {
-	if r.cfg.Offline {
-		return r.loadLocalIndex(r.cfg.GetIndexPath())
+// FetchIndex fetches the indexes of all remote repositories (local ones are just loaded).
+// A conditional request is sent when a cached copy exists; on 304 Not Modified
+// the cached index is loaded instead (or fetched in full when the cached copy does
+// not verify). Returns true if any new index was downloaded.
+// All repositories are attempted; the first error is returned.
+func (r *Repository) FetchIndex() (bool, error) {
+	var updated bool
+	var firstErr error
+
+	for _, s := range r.sources {
+		var err error
+		if s.IsLocal() {
+			err = r.loadLocalIndex(s, filepath.Join(s.Path, "index.json"))
+		} else {
+			var changed bool
+			changed, err = r.fetchSourceIndex(s)
+			updated = updated || changed
+		}
+		if err != nil && firstErr == nil {
+			firstErr = r.sourceErr(s, err)
+		}
 	}
 
-	// Add timestamp to bypass GitHub's CDN cache
-	url := fmt.Sprintf("%s/index.json?t=%d", r.cfg.RepoURL, time.Now().Unix())
+	return updated, firstErr
+}
+
+// fetchSourceIndex fetches the index of a single remote repository
+func (r *Repository) fetchSourceIndex(s *source) (bool, error) {
+	return r.fetchSourceIndexFrom(s, true)
+}
 
+// fetchSourceIndexFrom fetches the index of a remote repository, revalidating
+// the cached copy when conditional is set
+func (r *Repository) fetchSourceIndexFrom(s *source, conditional bool) (bool, error) {
 	// Enforce HTTPS for remote index
-	if !strings.HasPrefix(url, "https://") {
-		return fmt.Errorf("refusing non-HTTPS index URL: %s", url)
+	if !strings.HasPrefix(s.URL, "https://") {
+		return false, fmt.Errorf("refusing non-HTTPS index URL: %s/index.json", s.URL)
 	}
 
-	resp, err := httputil.Client.Get(url)
+	// Add timestamp to bypass GitHub's CDN cache; the signature is fetched with
+	// the same one so both come from the same revision of the repository
+	cacheBuster := time.Now().Unix()
+	url := fmt.Sprintf("%s/index.json?t=%d", s.URL, cacheBuster)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to fetch index: %w", err)
+		return false, fmt.Errorf("failed to fetch index: %w", err)
+	}
+
+	var meta *indexMeta
+	if conditional {
+		meta = r.loadIndexMeta(s)
+	}
+	if meta != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := httputil.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch index: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && meta != nil {
+		if err := r.loadLocalIndex(s, r.cachedIndexPath(s)); err != nil {
+			// The cached copy is unusable, e.g. it was cached unsigned and
+			// trusted keys were added since: fetch the index in full
+			resp.Body.Close()
+			return r.fetchSourceIndexFrom(s, false)
+		}
+		meta.FetchedAt = time.Now()
+		r.saveIndexMeta(s, meta)
+		return false, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to fetch index: HTTP %d", resp.StatusCode)
+		return false, fmt.Errorf("failed to fetch index: HTTP %d", resp.StatusCode)
 	}
 
 	data, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024)) // 10MB max index
 	if err != nil {
-		return fmt.Errorf("failed to read index: %w", err)
+		return false, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	// Fetch detached signature only when we have keys to check it against
+	var sigData []byte
+	if len(r.trustedKeyStrings(s)) > 0 {
+		sigURL := fmt.Sprintf("%s/index.json%s?t=%d", s.URL, signatureSuffix, cacheBuster)
+		sigData, err = fetchSignature(sigURL)
+		if err != nil {
+			return false, err
+		}
+	}
+	if err := r.verifyIndex(s, data, sigData); err != nil {
+		return false, err
 	}
 
 	var index pkg.Index
 	if err := json.Unmarshal(data, &index); err != nil {
-		return fmt.Errorf("failed to parse index: %w", err)
+		return false, fmt.Errorf("failed to parse index: %w", err)
 	}
 
-	r.index = &index
+	s.index = &index
 
-	// Cache the index
-	cacheDir := r.cfg.CacheDir
-	if err := os.MkdirAll(cacheDir, 0755); err == nil {
-		cachePath := filepath.Join(cacheDir, "index.json")
-		_ = os.WriteFile(cachePath, data, 0644)
+	// Cache the index together with its validators
+	if err := os.MkdirAll(r.cfg.SourceCacheDir(s.Name), 0755); err == nil {
+		cachePath := r.cachedIndexPath(s)
+		if err := os.WriteFile(cachePath, data, 0644); err == nil {
+			if sigData != nil {
+				_ = os.WriteFile(cachePath+signatureSuffix, sigData, 0644)
+			} else {
+				os.Remove(cachePath + signatureSuffix)
+			}
+			r.saveIndexMeta(s, &indexMeta{
+				URL:          s.URL,
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+				FetchedAt:    time.Now(),
+			})
+		}
 	}
 
-	return nil
+	return true, nil
 }
 
-// GetIndex returns the loaded index
+// GetIndex returns the index of the highest-priority repository
 func (r *Repository) GetIndex() *pkg.Index {
-	return r.index
+	if len(r.sources) == 0 {
+		return nil
+	}
+	return r.sources[0].index
 }
 
-// GetPackages returns all packages for current platform
-func (r *Repository) GetPackages() []pkg.Package {
-	if r.index == nil {
+// packages returns the source's packages for a platform
+func (s *source) packages(platform string) []pkg.Package {
+	if s.index == nil {
 		return nil
 	}
+	if p, ok := s.index.Platforms[platform]; ok {
+		return p.Packages
+	}
+	return nil
+}
 
+// GetPackages returns all packages for current platform, merged across repositories.
+//
+// Each package name is served by exactly one repository:
+//  1. the repository with the highest priority that contains the name wins;
+//  2. among repositories with equal priority, the one with the newest build wins;
+//  3. remaining ties go to the repository listed first (main before sources.d).
+//
+// All versions of a name from the winning repository are returned, tagged with Repo.
+func (r *Repository) GetPackages() []pkg.Package {
 	platform := r.cfg.Platform()
-	if p, ok := r.index.Platforms[platform]; ok {
-		return p.Packages
+
+	winner := make(map[string]*source)
+	newest := make(map[string]pkg.Package)
+	for _, s := range r.sources {
+		for _, p := range s.packages(platform) {
+			cur, ok := winner[p.Name]
+			switch {
+			case !ok:
+				winner[p.Name] = s
+				newest[p.Name] = p
+			case cur == s:
+				if best := newest[p.Name]; pkg.CompareBuilds(&p, &best) > 0 {
+					newest[p.Name] = p
+				}
+			case s.Priority == cur.Priority:
+				if best := newest[p.Name]; pkg.CompareBuilds(&p, &best) > 0 {
+					winner[p.Name] = s
+					newest[p.Name] = p
+				}
+			}
+		}
 	}
 
-	return nil
+	var result []pkg.Package
+	for _, s := range r.sources {
+		for _, p := range s.packages(platform) {
+			if winner[p.Name] == s {
+				p.Repo = s.Name
+				result = append(result, p)
+			}
+		}
+	}
+	return result
 }
 
-// GetPackage returns a specific package by name (latest version)
+// GetPackage returns a specific package by name (latest build)
 func (r *Repository) GetPackage(name string) *pkg.Package {
 	packages := r.GetPackages()
 	var latest *pkg.Package
 	for i := range packages {
 		if packages[i].Name == name {
-			if latest == nil || pkg.CompareVersions(packages[i].Version, latest.Version) > 0 {
+			if latest == nil || pkg.CompareBuilds(&packages[i], latest) > 0 {
 				latest = &packages[i]
 			}
 		}
@@ -140,6 +304,51 @@ func (r *Repository) GetPackage(name string) *pkg.Package {
 	return latest
 }
 
+// GetPackageInAllSources returns the latest build of a package from every repository
+// that contains it, in precedence order (the first entry is not necessarily the winner
+// when priorities are equal; use GetPackage for that).
+func (r *Repository) GetPackageInAllSources(name string) []pkg.Package {
+	platform := r.cfg.Platform()
+
+	var result []pkg.Package
+	for _, s := range r.sources {
+		var latest *pkg.Package
+		packages := s.packages(platform)
+		for i := range packages {
+			if packages[i].Name == name && (latest == nil || pkg.CompareBuilds(&packages[i], latest) > 0) {
+				latest = &packages[i]
+			}
+		}
+		if latest != nil {
+			p := *latest
+			p.Repo = s.Name
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// FindBuild returns a specific build of a package, looking in the repository
+// named repoName first (e.g. the one it was installed from), then in all others
+func (r *Repository) FindBuild(name, version string, revision int, repoName string) *pkg.Package {
+	platform := r.cfg.Platform()
+
+	for _, preferred := range []bool{true, false} {
+		for _, s := range r.sources {
+			if preferred != (s.Name == repoName) {
+				continue
+			}
+			for _, p := range s.packages(platform) {
+				if p.Name == name && p.Version == version && p.Revision == revision {
+					p.Repo = s.Name
+					return &p
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // SearchPackages searches packages by query
 func (r *Repository) SearchPackages(query string) []pkg.Package {
 	var results []pkg.Package
@@ -156,6 +365,27 @@ func (r *Repository) SearchPackages(query string) []pkg.Package {
 	return results
 }
 
+// sourceFor returns the repository a package was loaded from.
+// Packages without a Repo tag are attributed to the main (or local) repository.
+func (r *Repository) sourceFor(p *pkg.Package) *source {
+	for _, s := range r.sources {
+		if s.Name == p.Repo {
+			return s
+		}
+	}
+	for _, s := range r.sources {
+		if s.Name == config.MainSourceName || s.Name == config.LocalSourceName {
+			return s
+		}
+	}
+	return r.sources[0]
+}
+
+// PackageFilename returns the file name of a package tarball
+func PackageFilename(p *pkg.Package) string {
+	return p.Filename()
+}
+
 // verifyChecksum verifies the SHA256 checksum of a file.
 // Returns nil if expectedSHA256 is empty (backward compatibility).
 func verifyChecksum(filePath, expectedSHA256 string) error {
@@ -181,94 +411,114 @@ func verifyChecksum(filePath, expectedSHA256 string) error {
 	return nil
 }
 
+// CachedPackage returns the path of a package that is available without
+// downloading (local repository, or cached with a matching checksum), or "" if
+// it would have to be downloaded
+func (r *Repository) CachedPackage(p *pkg.Package) string {
+	filename := PackageFilename(p)
+	s := r.sourceFor(p)
+
+	path := filepath.Join(r.cfg.SourceCacheDir(s.Name), "packages", filename)
+	if s.IsLocal() {
+		path = filepath.Join(s.Path, filename)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	if !s.IsLocal() && verifyChecksum(path, p.SHA256) != nil {
+		return ""
+	}
+	return path
+}
+
+// CachedBuild returns the tarball of a build that may no longer be in any
+// index: the downloaded copy in the cache of its repository, or the file in a
+// local repository. The checksum is verified when p.SHA256 is set. Returns ""
+// if there is no usable copy.
+func (r *Repository) CachedBuild(p *pkg.Package) string {
+	filename := PackageFilename(p)
+	preferred := r.sourceFor(p)
+	for _, s := range append([]*source{preferred}, r.sources...) {
+		path := filepath.Join(r.cfg.SourceCacheDir(s.Name), "packages", filename)
+		if s.IsLocal() {
+			path = filepath.Join(s.Path, filename)
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if verifyChecksum(path, p.SHA256) != nil || r.verifyPackageSignature(s, path) != nil {
+			continue
+		}
+		return path
+	}
+	return ""
+}
+
 // DownloadPackage downloads a package to cache with progress bar
 func (r *Repository) DownloadPackage(p *pkg.Package) (string, error) {
-	filename := fmt.Sprintf("%s_%s-%d_%s.tar.zst", p.Name, p.Version, p.Revision, p.Platform)
+	filename := PackageFilename(p)
+	s := r.sourceFor(p)
 
-	// In offline mode, return local path
-	if r.cfg.Offline || r.cfg.RepoPath != "" {
-		localPath := r.cfg.GetPackagePath(filename)
+	// Local repository, return local path
+	if s.IsLocal() {
+		localPath := filepath.Join(s.Path, filename)
 		if _, err := os.Stat(localPath); err != nil {
 			return "", fmt.Errorf("package not found: %s", localPath)
 		}
+		if err := r.verifyPackageSignature(s, localPath); err != nil {
+			return "", err
+		}
 		return localPath, nil
 	}
 
 	// Check cache
-	cachePath := r.cfg.GetPackagePath(filename)
+	cachePath := filepath.Join(r.cfg.SourceCacheDir(s.Name), "packages", filename)
 	if _, err := os.Stat(cachePath); err == nil {
-		// Verify cached file checksum
+		// Verify cached file checksum (and signature, if required)
 		if err := verifyChecksum(cachePath, p.SHA256); err != nil {
 			os.Remove(cachePath)
 			// Fall through to re-download
+		} else if err := r.verifyPackageSignature(s, cachePath); err != nil {
+			os.Remove(cachePath)
+			os.Remove(cachePath + signatureSuffix)
+			// Fall through to re-download
 		} else {
 			return cachePath, nil
 		}
 	}
 
-	// Determine download URL
-	url := p.URL
-	if url == "" {
-		url = r.cfg.RepoURL + "/" + filename
-	}
-
-	// Enforce HTTPS for remote downloads
-	if !strings.HasPrefix(url, "https://") {
-		return "", fmt.Errorf("refusing non-HTTPS download URL: %s", url)
-	}
-
 	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
 		return "", err
 	}
 
-	resp, err := httputil.Client.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to download: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
-	}
-
-	out, err := os.Create(cachePath)
+	// Try the repository and its mirrors, resuming partial downloads
+	url, err := r.downloadFile(packageURLs(s, p, filename), cachePath)
 	if err != nil {
 		return "", err
 	}
 
-	// Create progress bar
-	bar := progressbar.NewOptions64(
-		resp.ContentLength,
-		progressbar.OptionSetDescription("    Downloading"),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionShowCount(),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "█",
-			SaucerHead:    "█",
-			SaucerPadding: "░",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}),
-		progressbar.OptionOnCompletion(func() {
-			fmt.Println()
-		}),
-	)
-
-	// Copy with progress
-	if _, err := io.Copy(io.MultiWriter(out, bar), resp.Body); err != nil {
-		out.Close()
-		os.Remove(cachePath)
-		return "", err
-	}
-	out.Close()
-
 	// Verify downloaded file checksum
 	if err := verifyChecksum(cachePath, p.SHA256); err != nil {
 		os.Remove(cachePath)
 		return "", fmt.Errorf("downloaded package verification failed: %w", err)
 	}
 
+	// Verify detached package signature
+	if r.cfg.VerifyPackageSignature {
+		sigData, err := fetchSignature(url + signatureSuffix)
+		if err == nil {
+			err = os.WriteFile(cachePath+signatureSuffix, sigData, 0644)
+		}
+		if err == nil {
+			err = r.verifyPackageSignature(s, cachePath)
+		}
+		if err != nil {
+			os.Remove(cachePath)
+			os.Remove(cachePath + signatureSuffix)
+			return "", fmt.Errorf("downloaded package verification failed: %w", err)
+		}
+	}
+
 	return cachePath, nil
 }
 
@@ -280,7 +530,8 @@ type DownloadResult struct {
 }
 
 // DownloadPackagesParallel downloads multiple packages concurrently
-// Returns a map of package name -> local path, and any errors
+// Returns a map of package filename -> local path, and any errors. The index
+// lists several builds of a name, so results are keyed by build, not by name.
 func (r *Repository) DownloadPackagesParallel(packages []*pkg.Package, maxConcurrent int) map[string]DownloadResult {
 	results := make(map[string]DownloadResult)
 	resultsMutex := sync.Mutex{}
@@ -293,7 +544,14 @@ func (r *Repository) DownloadPackagesParallel(packages []*pkg.Package, maxConcur
 	semaphore := make(chan struct{}, maxConcurrent)
 	var wg sync.WaitGroup
 
+	seen := make(map[string]bool)
 	for _, p := range packages {
+		// The same build requested twice is downloaded once
+		if seen[p.Filename()] {
+			continue
+		}
+		seen[p.Filename()] = true
+
 		wg.Add(1)
 		go func(pkg *pkg.Package) {
 			defer wg.Done()
@@ -305,7 +563,7 @@ func (r *Repository) DownloadPackagesParallel(packages []*pkg.Package, maxConcur
 			path, err := r.DownloadPackage(pkg)
 
 			resultsMutex.Lock()
-			results[pkg.Name] = DownloadResult{
+			results[pkg.Filename()] = DownloadResult{
 				Package: pkg,
 				Path:    path,
 				Error:   err,
@@ -0,0 +1,273 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phm-dev/phm/internal/config"
+	"github.com/phm-dev/phm/internal/pkg"
+)
+
+// servedFileRegex matches the file names a Server exposes
+var servedFileRegex = regexp.MustCompile(`^(index\.json|[a-zA-Z0-9][a-zA-Z0-9._+\-]*\.tar\.zst)(\.minisig)?$`)
+
+// Server serves a package repository over HTTP: index.json, detached signatures
+// and package tarballs, with ETags, conditional requests and Range support.
+type Server struct {
+	indexPath  string
+	packageDir string
+
+	// cache is set when serving the cache of the main repository: absolute
+	// package URLs (pointing upstream) are cleared in the served index
+	cache bool
+
+	// Cache mode: the main repository is refreshed and missing packages are
+	// downloaded on demand, turning the server into a pull-through cache
+	repo     *Repository
+	main     *source
+	mu       sync.Mutex
+	fetching map[string]*sync.Mutex
+
+	// Log receives one line per request (optional)
+	Log func(format string, args ...any)
+}
+
+// NewDirServer serves a repository directory (as used with --repo)
+func NewDirServer(dir string) *Server {
+	return &Server{
+		indexPath:  filepath.Join(dir, "index.json"),
+		packageDir: dir,
+	}
+}
+
+// NewCacheServer serves the local cache of the main repository.
+// When fetch is true, a stale index is refreshed and packages missing from the
+// cache are downloaded (and verified) before they are served.
+func (r *Repository) NewCacheServer(fetch bool) (*Server, error) {
+	var main *source
+	for _, s := range r.sources {
+		if s.Name == config.MainSourceName {
+			main = s
+		}
+	}
+	if main == nil {
+		return nil, fmt.Errorf("no main repository configured")
+	}
+
+	srv := &Server{
+		indexPath:  r.cachedIndexPath(main),
+		packageDir: filepath.Join(r.cfg.SourceCacheDir(main.Name), "packages"),
+		cache:      true,
+	}
+	if fetch {
+		srv.repo = r
+		srv.main = main
+		srv.fetching = make(map[string]*sync.Mutex)
+	}
+	return srv, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	s.serve(rec, req)
+	if s.Log != nil {
+		s.Log("%s %s %s %d %s", req.RemoteAddr, req.Method, req.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean(req.URL.Path), "/")
+	if !servedFileRegex.MatchString(name) {
+		http.NotFound(w, req)
+		return
+	}
+
+	var file string
+	switch {
+	case strings.HasPrefix(name, "index.json"):
+		if s.repo != nil && name == "index.json" {
+			s.refreshIndex()
+		}
+		file = filepath.Join(filepath.Dir(s.indexPath), name)
+		// Clients must always revalidate the index
+		w.Header().Set("Cache-Control", "no-cache")
+	default:
+		file = filepath.Join(s.packageDir, name)
+		if s.repo != nil && strings.HasSuffix(name, ".tar.zst") {
+			if err := s.fetchPackage(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+		// Package files never change under the same name
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, req)
+		return
+	}
+
+	var content io.ReadSeeker = f
+	if s.cache && strings.HasPrefix(name, "index.json") {
+		rewritten, changed, err := s.cachedIndex()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if changed {
+			// The upstream signature does not cover the rewritten index
+			if name != "index.json" {
+				http.NotFound(w, req)
+				return
+			}
+			content = bytes.NewReader(rewritten)
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".minisig"):
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case strings.HasSuffix(name, ".json"):
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		w.Header().Set("Content-Type", "application/zstd")
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+
+	// ServeContent handles If-None-Match, If-Modified-Since, Range and If-Range
+	http.ServeContent(w, req, name, info.ModTime(), content)
+}
+
+// cachedIndex returns the cached index with absolute package URLs cleared, so
+// clients download packages from this server instead of the upstream
+// repository. Reports whether the index had to be changed.
+func (s *Server) cachedIndex() ([]byte, bool, error) {
+	data, err := os.ReadFile(s.indexPath)
+	if err != nil {
+		return nil, false, err
+	}
+	var index pkg.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, false, fmt.Errorf("invalid cached index: %w", err)
+	}
+
+	changed := false
+	for _, platform := range index.Platforms {
+		if platform == nil {
+			continue
+		}
+		for i := range platform.Packages {
+			if platform.Packages[i].URL != "" {
+				platform.Packages[i].URL = ""
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return data, false, nil
+	}
+
+	rewritten, err := json.MarshalIndent(&index, "", "  ")
+	if err != nil {
+		return nil, false, err
+	}
+	return append(rewritten, '\n'), true, nil
+}
+
+// refreshIndex revalidates the cached index when it is older than CacheExpiry
+func (s *Server) refreshIndex() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.repo.IsCacheFresh() {
+		return
+	}
+	if _, err := s.repo.fetchSourceIndex(s.main); err != nil && s.Log != nil {
+		s.Log("failed to refresh index: %v", err)
+	}
+}
+
+// fetchPackage downloads a package into the cache unless it is already there
+func (s *Server) fetchPackage(name string) error {
+	s.mu.Lock()
+	if s.main.index == nil {
+		if err := s.repo.loadLocalIndex(s.main, s.indexPath); err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("no index available: %w", err)
+		}
+	}
+	lock, ok := s.fetching[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.fetching[name] = lock
+	}
+	index := s.main.index
+	s.mu.Unlock()
+
+	// One download per file; concurrent requests wait for it
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := os.Stat(filepath.Join(s.packageDir, name)); err == nil {
+		return nil
+	}
+
+	p := findPackageFile(index, name)
+	if p == nil {
+		return nil // not in the index, respond with 404
+	}
+	p.Repo = s.main.Name
+	_, err := s.repo.DownloadPackage(p)
+	return err
+}
+
+// findPackageFile looks up a package by tarball name across all platforms
+func findPackageFile(index *pkg.Index, name string) *pkg.Package {
+	for _, platform := range index.Platforms {
+		for i := range platform.Packages {
+			if platform.Packages[i].Filename() == name {
+				p := platform.Packages[i]
+				return &p
+			}
+		}
+	}
+	return nil
+}
+
+// statusRecorder captures the response status for logging
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,117 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/phm-dev/phm/internal/httputil"
+	"github.com/phm-dev/phm/internal/minisign"
+)
+
+// signatureSuffix is appended to a file name to locate its detached signature
+const signatureSuffix = ".minisig"
+
+// maxSignatureSize caps the size of a downloaded .minisig file
+const maxSignatureSize = 64 * 1024
+
+// trustedKeyStrings returns the keys a source is verified with.
+// Keys configured on the source replace the global PHM_TRUSTED_KEYS.
+func (r *Repository) trustedKeyStrings(s *source) []string {
+	if len(s.TrustedKeys) > 0 {
+		return s.TrustedKeys
+	}
+	return r.cfg.TrustedKeys
+}
+
+// trustedKeys parses the public keys a source is verified with
+func (r *Repository) trustedKeys(s *source) ([]minisign.PublicKey, error) {
+	// Without the configured keys an index could pass as unsigned
+	if r.cfg.TrustedKeysErr != nil {
+		return nil, fmt.Errorf("refusing to verify signatures, trusted keys could not be loaded: %w", r.cfg.TrustedKeysErr)
+	}
+	var keys []minisign.PublicKey
+	for _, k := range r.trustedKeyStrings(s) {
+		key, err := minisign.ParsePublicKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// verifyIndex checks the index signature against the trusted keys.
+// Returns nil if no trusted keys are configured (backward compatibility).
+func (r *Repository) verifyIndex(s *source, data, sigData []byte) error {
+	keys, err := r.trustedKeys(s)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if len(sigData) == 0 {
+		return fmt.Errorf("refusing unsigned index: trusted keys are configured but index.json%s is missing", signatureSuffix)
+	}
+	if err := minisign.Verify(keys, data, sigData); err != nil {
+		return fmt.Errorf("index signature verification failed: %w", err)
+	}
+	return nil
+}
+
+// verifyPackageSignature checks the detached signature stored next to a package file.
+// Returns nil unless package signature verification is enabled.
+func (r *Repository) verifyPackageSignature(s *source, path string) error {
+	if !r.cfg.VerifyPackageSignature {
+		return nil
+	}
+
+	keys, err := r.trustedKeys(s)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("package signature verification is enabled but no trusted keys are configured")
+	}
+
+	sigData, err := os.ReadFile(path + signatureSuffix)
+	if err != nil {
+		return fmt.Errorf("missing package signature: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read package for signature check: %w", err)
+	}
+
+	if err := minisign.Verify(keys, data, sigData); err != nil {
+		return fmt.Errorf("package signature verification failed: %w", err)
+	}
+	return nil
+}
+
+// fetchSignature downloads a detached signature over HTTPS
+func fetchSignature(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("refusing non-HTTPS signature URL: %s", url)
+	}
+
+	resp, err := httputil.Client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch signature: HTTP %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature: %w", err)
+	}
+	return data, nil
+}
@@ -3,6 +3,8 @@ package tools
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -132,3 +134,18 @@ func ExtractTarGz(tarPath, destDir, expectedBinaryName string) (string, error) {
 func SetExecutable(path string) error {
 	return os.Chmod(path, 0755)
 }
+
+// fileSHA256 returns the hex SHA256 checksum of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -9,6 +9,8 @@ import (
 	"runtime"
 	"strings"
 	"time"
+
+	"github.com/phm-dev/phm/internal/privfs"
 )
 
 // Manager handles tool installation and management
@@ -19,10 +21,12 @@ type Manager struct {
 	platform    string                    // darwin-arm64 or darwin-amd64
 	phpBin      string                    // /opt/php/bin/php
 	composerBin string                    // /opt/phm/bin/composer
+	fs          privfs.FS                 // writes to the tools prefix (see SetFS)
 }
 
-// NewManager creates a new tools manager
-func NewManager(toolsPrefix, dataDir string) *Manager {
+// NewManager creates a new tools manager. phpBin is the PHP binary used to run
+// phar tools (the default version link, e.g. /opt/php/bin/php).
+func NewManager(toolsPrefix, dataDir, phpBin string) *Manager {
 	arch := runtime.GOARCH
 	platform := fmt.Sprintf("darwin-%s", arch)
 
@@ -31,11 +35,17 @@ func NewManager(toolsPrefix, dataDir string) *Manager {
 		dataDir:     dataDir,
 		installed:   make(map[string]*InstalledTool),
 		platform:    platform,
-		phpBin:      "/opt/php/bin/php",
+		phpBin:      phpBin,
 		composerBin: filepath.Join(toolsPrefix, "composer"),
+		fs:          privfs.Sudo{},
 	}
 }
 
+// SetFS sets how the tools prefix is written (default: directly, falling back to sudo)
+func (m *Manager) SetFS(fs privfs.FS) {
+	m.fs = fs
+}
+
 // LoadInstalled loads the installed tools database
 func (m *Manager) LoadInstalled() error {
 	files, err := filepath.Glob(filepath.Join(m.dataDir, "*.json"))
@@ -119,31 +129,89 @@ func (m *Manager) Install(name string, force bool) error {
 		return fmt.Errorf("failed to create tools directory: %w", err)
 	}
 
-	var err error
+	return m.install(tool, nil)
+}
+
+// Pin selects an exact tool version to install (see phm install --locked)
+type Pin struct {
+	Version   string
+	SourceURL string // download URL of bootstrap and binary tools
+	SHA256    string // checksum of the binary or phar, checked if set
+}
+
+// check verifies a downloaded binary or phar against the pinned checksum
+func (p *Pin) check(path string) error {
+	if p == nil || p.SHA256 == "" {
+		return nil
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if sum != p.SHA256 {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", p.SHA256, sum)
+	}
+	return nil
+}
+
+// InstallPinned installs (or reinstalls) an exact version of a tool
+func (m *Manager) InstallPinned(name string, pin Pin) error {
+	tool := GetTool(name)
+	if tool == nil {
+		return fmt.Errorf("unknown tool: %s", name)
+	}
+	if tool.Type != ToolTypePhar && pin.SourceURL == "" {
+		return fmt.Errorf("no download URL for %s %s", name, pin.Version)
+	}
+
+	if err := m.ensureToolsDir(); err != nil {
+		return fmt.Errorf("failed to create tools directory: %w", err)
+	}
+	return m.install(tool, &pin)
+}
+
+// install installs the latest version of a tool, or the pinned one
+func (m *Manager) install(tool *Tool, pin *Pin) error {
 	switch tool.Type {
 	case ToolTypeBootstrap:
-		err = m.installComposer(tool, force)
+		return m.installComposer(tool, pin)
 	case ToolTypeBinary:
-		err = m.installBinary(tool, force)
+		return m.installBinary(tool, pin)
 	case ToolTypePhar:
-		err = m.installPharViaComposer(tool, force)
-	default:
-		return fmt.Errorf("unknown tool type: %s", tool.Type)
+		return m.installPharViaComposer(tool, pin)
 	}
+	return fmt.Errorf("unknown tool type: %s", tool.Type)
+}
 
-	return err
+// InstalledSHA256 returns the checksum of an installed tool's binary or phar
+// (the first installed file; the others are wrappers)
+func (m *Manager) InstalledSHA256(name string) (string, error) {
+	installed := m.GetInstalled(name)
+	if installed == nil {
+		return "", fmt.Errorf("tool %s is not installed", name)
+	}
+	if len(installed.InstalledFiles) == 0 {
+		return "", fmt.Errorf("tool %s has no installed files", name)
+	}
+	return fileSHA256(installed.InstalledFiles[0])
 }
 
 // installComposer installs composer from getcomposer.org
-func (m *Manager) installComposer(tool *Tool, force bool) error {
-	fmt.Printf("\033[34m==>\033[0m Fetching latest version of composer...\n")
+func (m *Manager) installComposer(tool *Tool, pin *Pin) error {
+	var version, downloadURL string
+	if pin != nil {
+		version, downloadURL = pin.Version, pin.SourceURL
+	} else {
+		fmt.Printf("\033[34m==>\033[0m Fetching latest version of composer...\n")
 
-	version, downloadURL, err := GetComposerLatestVersion()
-	if err != nil {
-		return fmt.Errorf("failed to get latest version: %w", err)
-	}
+		var err error
+		version, downloadURL, err = GetComposerLatestVersion()
+		if err != nil {
+			return fmt.Errorf("failed to get latest version: %w", err)
+		}
 
-	fmt.Printf("    Latest version: %s\n", version)
+		fmt.Printf("    Latest version: %s\n", version)
+	}
 
 	// Create temp directory
 	tmpDir, err := os.MkdirTemp("", "phm-composer-*")
@@ -159,10 +227,13 @@ func (m *Manager) installComposer(tool *Tool, force bool) error {
 	if err := DownloadFile(pharPath, downloadURL); err != nil {
 		return fmt.Errorf("download failed: %w", err)
 	}
+	if err := pin.check(pharPath); err != nil {
+		return fmt.Errorf("composer %s: %w", version, err)
+	}
 
 	// Install phar
 	destPhar := filepath.Join(m.toolsPrefix, "composer.phar")
-	if err := m.sudoCopy(pharPath, destPhar); err != nil {
+	if err := m.installFile(pharPath, destPhar); err != nil {
 		return fmt.Errorf("failed to install phar: %w", err)
 	}
 
@@ -175,7 +246,7 @@ func (m *Manager) installComposer(tool *Tool, force bool) error {
 		return fmt.Errorf("failed to create wrapper: %w", err)
 	}
 
-	if err := m.sudoCopy(wrapperTmp, wrapperPath); err != nil {
+	if err := m.installFile(wrapperTmp, wrapperPath); err != nil {
 		return fmt.Errorf("failed to install wrapper: %w", err)
 	}
 
@@ -202,15 +273,21 @@ func (m *Manager) installComposer(tool *Tool, force bool) error {
 }
 
 // installBinary installs a binary tool from GitHub releases
-func (m *Manager) installBinary(tool *Tool, force bool) error {
-	fmt.Printf("\033[34m==>\033[0m Fetching latest version of %s...\n", tool.Name)
+func (m *Manager) installBinary(tool *Tool, pin *Pin) error {
+	var version, downloadURL string
+	if pin != nil {
+		version, downloadURL = pin.Version, pin.SourceURL
+	} else {
+		fmt.Printf("\033[34m==>\033[0m Fetching latest version of %s...\n", tool.Name)
 
-	version, downloadURL, err := GetBinaryLatestVersion(tool, m.platform)
-	if err != nil {
-		return fmt.Errorf("failed to get latest version: %w", err)
-	}
+		var err error
+		version, downloadURL, err = GetBinaryLatestVersion(tool, m.platform)
+		if err != nil {
+			return fmt.Errorf("failed to get latest version: %w", err)
+		}
 
-	fmt.Printf("    Latest version: %s\n", version)
+		fmt.Printf("    Latest version: %s\n", version)
+	}
 
 	// Create temp directory
 	tmpDir, err := os.MkdirTemp("", "phm-tool-*")
@@ -254,9 +331,13 @@ func (m *Manager) installBinary(tool *Tool, force bool) error {
 		}
 	}
 
+	if err := pin.check(binaryPath); err != nil {
+		return fmt.Errorf("%s %s: %w", tool.Name, version, err)
+	}
+
 	// Install binary
 	destPath := filepath.Join(m.toolsPrefix, tool.Name)
-	if err := m.sudoCopy(binaryPath, destPath); err != nil {
+	if err := m.installFile(binaryPath, destPath); err != nil {
 		return fmt.Errorf("failed to install binary: %w", err)
 	}
 
@@ -283,7 +364,7 @@ func (m *Manager) installBinary(tool *Tool, force bool) error {
 }
 
 // installPharViaComposer installs a phar tool using composer require
-func (m *Manager) installPharViaComposer(tool *Tool, force bool) error {
+func (m *Manager) installPharViaComposer(tool *Tool, pin *Pin) error {
 	// Check if composer is installed
 	if !m.IsInstalled("composer") {
 		// Check if composer exists on disk anyway
@@ -306,10 +387,14 @@ func (m *Manager) installPharViaComposer(tool *Tool, force bool) error {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// Run composer require
-	fmt.Printf("    Running: composer require %s\n", tool.ComposerPkg)
+	// Run composer require (with an exact version constraint when pinned)
+	requirement := tool.ComposerPkg
+	if pin != nil {
+		requirement += ":" + pin.Version
+	}
+	fmt.Printf("    Running: composer require %s\n", requirement)
 
-	cmd := exec.Command(m.composerBin, "require", tool.ComposerPkg, "--no-interaction", "--no-progress")
+	cmd := exec.Command(m.composerBin, "require", requirement, "--no-interaction", "--no-progress")
 	cmd.Dir = tmpDir
 	cmd.Env = append(os.Environ(), "COMPOSER_HOME="+tmpDir)
 	output, err := cmd.CombinedOutput()
@@ -322,6 +407,9 @@ func (m *Manager) installPharViaComposer(tool *Tool, force bool) error {
 	if _, err := os.Stat(pharPath); os.IsNotExist(err) {
 		return fmt.Errorf("expected file not found: vendor/%s", tool.PharInVendor)
 	}
+	if err := pin.check(pharPath); err != nil {
+		return fmt.Errorf("%s %s: %w", tool.Name, pin.Version, err)
+	}
 
 	// Get version from composer.lock
 	version := m.getVersionFromComposerLock(tmpDir, tool.ComposerPkg)
@@ -336,7 +424,7 @@ func (m *Manager) installPharViaComposer(tool *Tool, force bool) error {
 	if strings.HasSuffix(tool.PharInVendor, ".phar") {
 		// It's a phar - copy it and create wrapper
 		destPhar := filepath.Join(m.toolsPrefix, tool.Name+".phar")
-		if err := m.sudoCopy(pharPath, destPhar); err != nil {
+		if err := m.installFile(pharPath, destPhar); err != nil {
 			return fmt.Errorf("failed to install phar: %w", err)
 		}
 
@@ -348,7 +436,7 @@ func (m *Manager) installPharViaComposer(tool *Tool, force bool) error {
 			return fmt.Errorf("failed to create wrapper: %w", err)
 		}
 
-		if err := m.sudoCopy(wrapperTmp, wrapperPath); err != nil {
+		if err := m.installFile(wrapperTmp, wrapperPath); err != nil {
 			return fmt.Errorf("failed to install wrapper: %w", err)
 		}
 
@@ -357,7 +445,7 @@ func (m *Manager) installPharViaComposer(tool *Tool, force bool) error {
 	} else {
 		// It's a PHP script - copy and create wrapper
 		destScript := filepath.Join(m.toolsPrefix, tool.Name+".php")
-		if err := m.sudoCopy(pharPath, destScript); err != nil {
+		if err := m.installFile(pharPath, destScript); err != nil {
 			return fmt.Errorf("failed to install script: %w", err)
 		}
 
@@ -369,7 +457,7 @@ func (m *Manager) installPharViaComposer(tool *Tool, force bool) error {
 			return fmt.Errorf("failed to create wrapper: %w", err)
 		}
 
-		if err := m.sudoCopy(wrapperTmp, wrapperPath); err != nil {
+		if err := m.installFile(wrapperTmp, wrapperPath); err != nil {
 			return fmt.Errorf("failed to install wrapper: %w", err)
 		}
 
@@ -434,41 +522,17 @@ exec %s "%s" "$@"
 `, m.phpBin, pharPath)
 }
 
+// installFile copies a file into the tools prefix as an executable
+func (m *Manager) installFile(src, dest string) error {
+	return m.fs.CopyFile(src, dest, 0755)
+}
+
 // ensureToolsDir creates the tools directory if it doesn't exist
 func (m *Manager) ensureToolsDir() error {
 	if _, err := os.Stat(m.toolsPrefix); err == nil {
 		return nil
 	}
-
-	// Need to create with sudo
-	cmd := exec.Command("sudo", "mkdir", "-p", m.toolsPrefix)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// sudoCopy copies a file using sudo
-func (m *Manager) sudoCopy(src, dest string) error {
-	cmd := exec.Command("sudo", "cp", src, dest)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-
-	// Set permissions
-	cmd = exec.Command("sudo", "chmod", "755", dest)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// sudoRemove removes a file using sudo
-func (m *Manager) sudoRemove(path string) error {
-	cmd := exec.Command("sudo", "rm", "-f", path)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return m.fs.MkdirAll(m.toolsPrefix)
 }
 
 // Remove removes an installed tool
@@ -482,7 +546,7 @@ func (m *Manager) Remove(name string) error {
 
 	// Remove installed files
 	for _, file := range installed.InstalledFiles {
-		if err := m.sudoRemove(file); err != nil {
+		if err := m.fs.Remove(file); err != nil {
 			fmt.Printf("\033[33mWarning:\033[0m Could not remove %s: %v\n", file, err)
 		}
 	}